@@ -0,0 +1,148 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/khryptorgraphics/ollama-nova/internal/inference"
+	"github.com/khryptorgraphics/ollama-nova/internal/security"
+)
+
+// identityKey holds the caller's *security.Identity in the gin context.
+const identityKey = "nova.identity"
+
+// SetAuthenticators turns on authentication: requests to every endpoint but
+// /health must carry credentials accepted by one of auths, tried in order.
+// Calling it without authenticators turns authentication off again.
+func (s *Server) SetAuthenticators(auths ...security.Authenticator) {
+	s.authMu.Lock()
+	defer s.authMu.Unlock()
+	s.auths = auths
+}
+
+// SetKeyStore enables the /api/keys endpoints for managing API keys.
+func (s *Server) SetKeyStore(keys *security.KeyStore) {
+	s.keys = keys
+}
+
+func (s *Server) authenticators() []security.Authenticator {
+	s.authMu.RLock()
+	defer s.authMu.RUnlock()
+	return s.auths
+}
+
+// require authenticates the request, if authentication is on, and rejects
+// callers without scope. The caller's identity replaces the client address
+// in the fair queue. Only callers with the priority scope may ask for the
+// high priority class. Without authentication, nobody holds the admin or
+// priority scope, so that an open node cannot be used to mint keys.
+func (s *Server) require(scope security.Scope) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		auths := s.authenticators()
+		highPriority := inference.PriorityFromContext(c.Request.Context()) == inference.PriorityHigh
+		if len(auths) == 0 {
+			switch {
+			case scope == security.ScopeAdmin:
+				authError(c, fmt.Errorf("%w: the %s scope needs authentication", security.ErrForbidden, scope), auths)
+			case highPriority:
+				authError(c, fmt.Errorf("%w: the high priority class needs authentication", security.ErrForbidden), auths)
+			default:
+				c.Next()
+			}
+			return
+		}
+
+		id, err := authenticate(c.Request, auths)
+		if err != nil {
+			authError(c, err, auths)
+			return
+		}
+		if !id.Can(scope) {
+			authError(c, fmt.Errorf("%w: %s needs the %s scope", security.ErrForbidden, id.Name, scope), auths)
+			return
+		}
+		if highPriority && !id.Can(security.ScopePriority) {
+			authError(c, fmt.Errorf("%w: %s needs the %s scope for high priority", security.ErrForbidden, id.Name, security.ScopePriority), auths)
+			return
+		}
+
+		c.Set(identityKey, id)
+		c.Request = c.Request.WithContext(inference.WithClient(c.Request.Context(), id.Name))
+		c.Next()
+	}
+}
+
+func authenticate(r *http.Request, auths []security.Authenticator) (*security.Identity, error) {
+	for _, a := range auths {
+		id, err := a.Authenticate(r)
+		if errors.Is(err, security.ErrNoCredentials) {
+			continue
+		}
+		return id, err
+	}
+	return nil, security.ErrNoCredentials
+}
+
+// identity returns the authenticated caller, or nil when authentication is
+// off.
+func identity(c *gin.Context) *security.Identity {
+	id, _ := c.Get(identityKey)
+	identity, _ := id.(*security.Identity)
+	return identity
+}
+
+// checkModel reports an error wrapping security.ErrForbidden if the caller
+// may not use model.
+func checkModel(c *gin.Context, model string) error {
+	if id := identity(c); id != nil && !id.AllowsModel(model) {
+		return fmt.Errorf("%w: %s may not use model %q", security.ErrForbidden, id.Name, model)
+	}
+	return nil
+}
+
+// allowedModels drops the models the caller may not use from a listing.
+func allowedModels(c *gin.Context, models []inference.Model) []inference.Model {
+	id := identity(c)
+	if id == nil {
+		return models
+	}
+	allowed := make([]inference.Model, 0, len(models))
+	for _, m := range models {
+		if id.AllowsModel(m.Name) {
+			allowed = append(allowed, m)
+		}
+	}
+	return allowed
+}
+
+// requestModel returns the model a lifecycle request names, preferring the
+// model field over the legacy name field as the engine does.
+func requestModel(model, name string) string {
+	if model != "" {
+		return model
+	}
+	return name
+}
+
+// authError rejects a request with 401 or 403, in the OpenAI error format
+// on the /v1 endpoints. 401 responses list how to authenticate.
+func authError(c *gin.Context, err error, auths []security.Authenticator) {
+	if errors.Is(err, security.ErrNoCredentials) || errors.Is(err, security.ErrUnauthenticated) {
+		seen := make(map[string]bool)
+		for _, a := range auths {
+			if ch, ok := a.(security.Challenger); ok && !seen[ch.Challenge()] {
+				seen[ch.Challenge()] = true
+				c.Writer.Header().Add("WWW-Authenticate", ch.Challenge())
+			}
+		}
+	}
+	if strings.HasPrefix(c.Request.URL.Path, "/v1/") {
+		openAIEngineError(c, err)
+	} else {
+		engineError(c, err)
+	}
+	c.Abort()
+}
@@ -0,0 +1,116 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/khryptorgraphics/ollama-nova/internal/security"
+)
+
+func TestRequire(t *testing.T) {
+	auth := &fakeAuth{tokens: map[string]*security.Identity{
+		"user":     {Name: "user", Scopes: []security.Scope{security.ScopeGenerate}},
+		"priority": {Name: "priority", Scopes: []security.Scope{security.ScopeGenerate, security.ScopePriority}},
+		"admin":    {Name: "admin", Scopes: []security.Scope{security.ScopeAdmin}},
+		"mistral":  {Name: "mistral", Scopes: []security.Scope{security.ScopeGenerate}, Models: []string{"mistral"}},
+	}}
+
+	tests := []struct {
+		name     string
+		auth     bool
+		method   string
+		path     string
+		token    string
+		priority string
+		status   int
+	}{
+		// Without authentication nobody holds the admin or priority scope.
+		{name: "open generate", method: http.MethodPost, path: "/api/generate", status: http.StatusOK},
+		{name: "open health", method: http.MethodGet, path: "/health", status: http.StatusOK},
+		{name: "open admin", method: http.MethodGet, path: "/api/backends", status: http.StatusForbidden},
+		{name: "open high priority", method: http.MethodPost, path: "/api/generate", priority: "high", status: http.StatusForbidden},
+		{name: "open low priority", method: http.MethodPost, path: "/api/generate", priority: "low", status: http.StatusOK},
+
+		{name: "missing credentials", auth: true, method: http.MethodPost, path: "/api/generate", status: http.StatusUnauthorized},
+		{name: "unknown token", auth: true, method: http.MethodPost, path: "/api/generate", token: "nope", status: http.StatusUnauthorized},
+		{name: "missing credentials on /v1", auth: true, method: http.MethodPost, path: "/v1/chat/completions", status: http.StatusUnauthorized},
+		{name: "health needs no credentials", auth: true, method: http.MethodGet, path: "/health", status: http.StatusOK},
+		{name: "generate scope", auth: true, method: http.MethodPost, path: "/api/generate", token: "user", status: http.StatusOK},
+		{name: "wrong scope", auth: true, method: http.MethodGet, path: "/api/backends", token: "user", status: http.StatusForbidden},
+		{name: "admin scope", auth: true, method: http.MethodGet, path: "/api/backends", token: "admin", status: http.StatusOK},
+		{name: "high priority without the scope", auth: true, method: http.MethodPost, path: "/api/generate", token: "user", priority: "high", status: http.StatusForbidden},
+		{name: "high priority with the scope", auth: true, method: http.MethodPost, path: "/api/generate", token: "priority", priority: "high", status: http.StatusOK},
+		{name: "high priority as admin", auth: true, method: http.MethodPost, path: "/api/generate", token: "admin", priority: "high", status: http.StatusOK},
+		{name: "model not allowed", auth: true, method: http.MethodPost, path: "/api/generate", token: "mistral", status: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := &fakeRouter{}
+			var s *Server
+			if tt.auth {
+				s = newTestServer(t, router, auth)
+			} else {
+				s = newTestServer(t, router)
+			}
+			header := map[string]string{}
+			if tt.token != "" {
+				header["Authorization"] = "Bearer " + tt.token
+			}
+			if tt.priority != "" {
+				header[PriorityHeader] = tt.priority
+			}
+			body := ""
+			if tt.method == http.MethodPost {
+				body = generateBody
+				if tt.path == "/v1/chat/completions" {
+					body = `{"model":"llama3","messages":[{"role":"user","content":"hi"}]}`
+				}
+			}
+
+			w := do(s, tt.method, tt.path, body, header)
+			if w.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+
+			challenge := w.Header().Get("WWW-Authenticate")
+			switch tt.status {
+			case http.StatusUnauthorized:
+				if challenge != auth.Challenge() {
+					t.Errorf("WWW-Authenticate %q, want %q", challenge, auth.Challenge())
+				}
+			case http.StatusForbidden:
+				if challenge != "" {
+					t.Errorf("WWW-Authenticate %q on a 403", challenge)
+				}
+			}
+			if _, calls := router.last(); tt.status != http.StatusOK && calls != 0 {
+				t.Error("rejected request reached the router")
+			}
+		})
+	}
+}
+
+func TestAuthErrorFormat(t *testing.T) {
+	s := newTestServer(t, &fakeRouter{}, &fakeAuth{})
+
+	// Ollama endpoints answer with a plain error string, /v1 endpoints in
+	// the OpenAI format.
+	w := do(s, http.MethodPost, "/api/generate", generateBody, nil)
+	var ollama struct {
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &ollama); err != nil || ollama.Error == "" {
+		t.Errorf("/api/generate body %s, want an error string", w.Body)
+	}
+
+	w = do(s, http.MethodPost, "/v1/chat/completions", `{"model":"llama3","messages":[{"role":"user","content":"hi"}]}`, nil)
+	var openAI struct {
+		Error struct {
+			Type string `json:"type"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &openAI); err != nil || openAI.Error.Type != "authentication_error" {
+		t.Errorf("/v1/chat/completions body %s, want an authentication_error", w.Body)
+	}
+}
@@ -0,0 +1,61 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/khryptorgraphics/ollama-nova/internal/inference"
+)
+
+func (s *Server) handleEmbed(c *gin.Context) {
+	var req inference.EmbedRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := checkModel(c, req.Model); err != nil {
+		engineError(c, err)
+		return
+	}
+
+	resp, err := s.generator.Embed(c.Request.Context(), &req)
+	if err != nil {
+		engineError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// handleEmbeddings serves Ollama's legacy single-prompt embeddings endpoint.
+func (s *Server) handleEmbeddings(c *gin.Context) {
+	var req struct {
+		Model     string                 `json:"model"`
+		Prompt    string                 `json:"prompt"`
+		KeepAlive string                 `json:"keep_alive,omitempty"`
+		Options   map[string]interface{} `json:"options,omitempty"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := checkModel(c, req.Model); err != nil {
+		engineError(c, err)
+		return
+	}
+
+	resp, err := s.generator.Embed(c.Request.Context(), &inference.EmbedRequest{
+		Model:     req.Model,
+		Input:     inference.EmbedInput{req.Prompt},
+		KeepAlive: req.KeepAlive,
+		Options:   req.Options,
+	})
+	if err != nil {
+		engineError(c, err)
+		return
+	}
+	if len(resp.Embeddings) == 0 {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "no embedding returned"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"embedding": resp.Embeddings[0]})
+}
@@ -0,0 +1,109 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/khryptorgraphics/ollama-nova/internal/security"
+)
+
+// CreateKeyRequest is the body of POST /api/keys.
+type CreateKeyRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+	Models []string `json:"models,omitempty"`
+	// ExpiresIn is a Go duration such as "720h"; empty never expires.
+	ExpiresIn string `json:"expires_in,omitempty"`
+}
+
+// CreateKeyResponse carries a newly issued key. Key is the only time the
+// secret is shown.
+type CreateKeyResponse struct {
+	Key string `json:"key"`
+	security.APIKey
+}
+
+func (s *Server) setupKeyRoutes() {
+	admin := s.require(security.ScopeAdmin)
+	s.router.GET("/api/keys", admin, s.handleListKeys)
+	s.router.POST("/api/keys", admin, s.handleCreateKey)
+	s.router.DELETE("/api/keys/:id", admin, s.handleRevokeKey)
+}
+
+func (s *Server) handleListKeys(c *gin.Context) {
+	if !s.keyStoreConfigured(c) {
+		return
+	}
+	keys, err := s.keys.List()
+	if err != nil {
+		engineError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"keys": keys})
+}
+
+func (s *Server) handleCreateKey(c *gin.Context) {
+	if !s.keyStoreConfigured(c) {
+		return
+	}
+	var req CreateKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	scopes := make([]security.Scope, 0, len(req.Scopes))
+	for _, name := range req.Scopes {
+		scope, err := security.ParseScope(name)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		scopes = append(scopes, scope)
+	}
+	if len(scopes) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "scopes must not be empty"})
+		return
+	}
+	var ttl time.Duration
+	if req.ExpiresIn != "" {
+		var err error
+		if ttl, err = time.ParseDuration(req.ExpiresIn); err != nil || ttl <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid expires_in %q", req.ExpiresIn)})
+			return
+		}
+	}
+
+	token, key, err := s.keys.Issue(req.Name, scopes, req.Models, ttl)
+	if err != nil {
+		engineError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, CreateKeyResponse{Key: token, APIKey: key})
+}
+
+func (s *Server) handleRevokeKey(c *gin.Context) {
+	if !s.keyStoreConfigured(c) {
+		return
+	}
+	if err := s.keys.Revoke(c.Param("id")); err != nil {
+		if errors.Is(err, security.ErrKeyNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		engineError(c, err)
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+func (s *Server) keyStoreConfigured(c *gin.Context) bool {
+	if s.keys == nil {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "API keys are not configured on this node"})
+		return false
+	}
+	return true
+}
@@ -0,0 +1,127 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/khryptorgraphics/ollama-nova/internal/inference"
+	"github.com/khryptorgraphics/ollama-nova/internal/security"
+)
+
+func (s *Server) setupModelRoutes() {
+	manage := s.require(security.ScopeManageModels)
+	s.router.POST("/api/pull", manage, s.handlePull)
+	s.router.POST("/api/create", manage, s.handleCreate)
+	s.router.DELETE("/api/delete", manage, s.handleDelete)
+	s.router.POST("/api/copy", manage, s.handleCopy)
+	s.router.POST("/api/show", s.require(security.ScopeGenerate), s.handleShow)
+}
+
+func (s *Server) handlePull(c *gin.Context) {
+	var req inference.PullRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := checkModel(c, requestModel(req.Model, req.Name)); err != nil {
+		engineError(c, err)
+		return
+	}
+
+	s.streamProgress(c, req.Stream, func(fn func(*inference.ProgressResponse) error) error {
+		return s.engine.PullModel(c.Request.Context(), &req, fn)
+	})
+}
+
+func (s *Server) handleCreate(c *gin.Context) {
+	var req inference.CreateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := checkModel(c, requestModel(req.Model, req.Name)); err != nil {
+		engineError(c, err)
+		return
+	}
+
+	s.streamProgress(c, req.Stream, func(fn func(*inference.ProgressResponse) error) error {
+		return s.engine.CreateModel(c.Request.Context(), &req, fn)
+	})
+}
+
+// streamProgress runs a pull or create operation. When streaming, every
+// progress update is forwarded as NDJSON; otherwise only the final status is
+// returned once the operation has finished.
+func (s *Server) streamProgress(c *gin.Context, stream bool, run func(func(*inference.ProgressResponse) error) error) {
+	if !stream {
+		if err := run(nil); err != nil {
+			engineError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, inference.ProgressResponse{Status: "success"})
+		return
+	}
+
+	w := newNDJSONWriter(c)
+	err := run(func(p *inference.ProgressResponse) error {
+		return w.Write(p)
+	})
+	w.Finish(err)
+}
+
+func (s *Server) handleDelete(c *gin.Context) {
+	var req inference.DeleteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := checkModel(c, requestModel(req.Model, req.Name)); err != nil {
+		engineError(c, err)
+		return
+	}
+
+	if err := s.engine.DeleteModel(c.Request.Context(), &req); err != nil {
+		engineError(c, err)
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+func (s *Server) handleCopy(c *gin.Context) {
+	var req inference.CopyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	for _, model := range []string{req.Source, req.Destination} {
+		if err := checkModel(c, model); err != nil {
+			engineError(c, err)
+			return
+		}
+	}
+
+	if err := s.engine.CopyModel(c.Request.Context(), &req); err != nil {
+		engineError(c, err)
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+func (s *Server) handleShow(c *gin.Context) {
+	var req inference.ShowRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := checkModel(c, requestModel(req.Model, req.Name)); err != nil {
+		engineError(c, err)
+		return
+	}
+
+	resp, err := s.engine.ShowModel(c.Request.Context(), &req)
+	if err != nil {
+		engineError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, resp)
+}
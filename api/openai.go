@@ -0,0 +1,582 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/khryptorgraphics/ollama-nova/internal/inference"
+	"github.com/khryptorgraphics/ollama-nova/internal/security"
+)
+
+// The OpenAI facade translates the subset of the OpenAI REST API that common
+// SDKs rely on into inference.Engine calls, so clients only need to point
+// their base URL at /v1.
+
+type openAIMessage struct {
+	Role       string           `json:"role"`
+	Content    json.RawMessage  `json:"content,omitempty"`
+	Name       string           `json:"name,omitempty"`
+	ToolCalls  []openAIToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string           `json:"tool_call_id,omitempty"`
+}
+
+type openAIContentPart struct {
+	Type     string `json:"type"`
+	Text     string `json:"text,omitempty"`
+	ImageURL struct {
+		URL string `json:"url"`
+	} `json:"image_url,omitempty"`
+}
+
+type openAIToolCall struct {
+	Index    *int   `json:"index,omitempty"`
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Function struct {
+		Name      string `json:"name"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+type openAISamplingParams struct {
+	Temperature      *float64        `json:"temperature,omitempty"`
+	TopP             *float64        `json:"top_p,omitempty"`
+	MaxTokens        *int            `json:"max_tokens,omitempty"`
+	Seed             *int            `json:"seed,omitempty"`
+	FrequencyPenalty *float64        `json:"frequency_penalty,omitempty"`
+	PresencePenalty  *float64        `json:"presence_penalty,omitempty"`
+	Stop             json.RawMessage `json:"stop,omitempty"`
+	Stream           bool            `json:"stream"`
+	StreamOptions    *struct {
+		IncludeUsage bool `json:"include_usage"`
+	} `json:"stream_options,omitempty"`
+}
+
+type openAIChatRequest struct {
+	openAISamplingParams
+	Model          string           `json:"model"`
+	Messages       []openAIMessage  `json:"messages"`
+	Tools          []inference.Tool `json:"tools,omitempty"`
+	ResponseFormat *struct {
+		Type string `json:"type"`
+	} `json:"response_format,omitempty"`
+}
+
+type openAICompletionRequest struct {
+	openAISamplingParams
+	Model  string          `json:"model"`
+	Prompt json.RawMessage `json:"prompt"`
+}
+
+type openAIEmbeddingRequest struct {
+	Model          string               `json:"model"`
+	Input          inference.EmbedInput `json:"input"`
+	EncodingFormat string               `json:"encoding_format,omitempty"`
+}
+
+type openAIEmbedding struct {
+	Object    string      `json:"object"`
+	Embedding interface{} `json:"embedding"`
+	Index     int         `json:"index"`
+}
+
+type openAIUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+type openAIChoice struct {
+	Index        int                 `json:"index"`
+	Message      *openAIReplyMessage `json:"message,omitempty"`
+	Delta        *openAIReplyMessage `json:"delta,omitempty"`
+	Text         *string             `json:"text,omitempty"`
+	FinishReason *string             `json:"finish_reason"`
+	Logprobs     *struct{}           `json:"logprobs"`
+}
+
+type openAIReplyMessage struct {
+	Role      string           `json:"role,omitempty"`
+	Content   string           `json:"content"`
+	ToolCalls []openAIToolCall `json:"tool_calls,omitempty"`
+}
+
+type openAIResponse struct {
+	ID      string         `json:"id"`
+	Object  string         `json:"object"`
+	Created int64          `json:"created"`
+	Model   string         `json:"model"`
+	Choices []openAIChoice `json:"choices"`
+	Usage   *openAIUsage   `json:"usage,omitempty"`
+}
+
+type openAIModel struct {
+	ID      string `json:"id"`
+	Object  string `json:"object"`
+	Created int64  `json:"created"`
+	OwnedBy string `json:"owned_by"`
+}
+
+func (s *Server) setupOpenAIRoutes() {
+	v1 := s.router.Group("/v1", s.require(security.ScopeGenerate))
+	v1.POST("/chat/completions", s.handleOpenAIChat)
+	v1.POST("/completions", s.handleOpenAICompletion)
+	v1.POST("/embeddings", s.handleOpenAIEmbeddings)
+	v1.GET("/models", s.handleOpenAIListModels)
+	v1.GET("/models/:model", s.handleOpenAIGetModel)
+}
+
+func openAIError(c *gin.Context, status int, errType, message string) {
+	c.JSON(status, gin.H{"error": gin.H{
+		"message": message,
+		"type":    errType,
+		"param":   nil,
+		"code":    nil,
+	}})
+}
+
+func openAIEngineError(c *gin.Context, err error) {
+	status := errorStatus(err)
+	errType := "api_error"
+	switch status {
+	case http.StatusBadRequest:
+		errType = "invalid_request_error"
+	case http.StatusUnauthorized:
+		errType = "authentication_error"
+	case http.StatusForbidden:
+		errType = "permission_error"
+	}
+	setRetryAfter(c, err)
+	openAIError(c, status, errType, err.Error())
+}
+
+func (s *Server) handleOpenAIChat(c *gin.Context) {
+	var req openAIChatRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		openAIError(c, http.StatusBadRequest, "invalid_request_error", err.Error())
+		return
+	}
+
+	chatReq, err := req.toChatRequest()
+	if err != nil {
+		openAIError(c, http.StatusBadRequest, "invalid_request_error", err.Error())
+		return
+	}
+	if err := checkModel(c, chatReq.Model); err != nil {
+		openAIEngineError(c, err)
+		return
+	}
+
+	id := "chatcmpl-" + newCompletionID()
+	created := time.Now().Unix()
+
+	if !req.Stream {
+		resp, err := s.generator.Chat(c.Request.Context(), chatReq)
+		if err != nil {
+			openAIEngineError(c, err)
+			return
+		}
+		msg := toOpenAIReply(resp.Message)
+		c.JSON(http.StatusOK, openAIResponse{
+			ID:      id,
+			Object:  "chat.completion",
+			Created: created,
+			Model:   req.Model,
+			Choices: []openAIChoice{{
+				Message:      &msg,
+				FinishReason: finishReason(resp.DoneReason, len(msg.ToolCalls) > 0),
+			}},
+			Usage: usageOf(resp.PromptEvalCount, resp.EvalCount),
+		})
+		return
+	}
+
+	stream := newSSEWriter(c)
+	sentRole := false
+	err = s.generator.ChatStream(c.Request.Context(), chatReq, func(resp *inference.ChatResponse) error {
+		delta := toOpenAIReply(resp.Message)
+		if sentRole {
+			delta.Role = ""
+		}
+		sentRole = true
+
+		chunk := openAIResponse{
+			ID:      id,
+			Object:  "chat.completion.chunk",
+			Created: created,
+			Model:   req.Model,
+			Choices: []openAIChoice{{Delta: &delta}},
+		}
+		if resp.Done {
+			chunk.Choices[0].FinishReason = finishReason(resp.DoneReason, len(delta.ToolCalls) > 0)
+		}
+		if err := stream.Write(chunk); err != nil {
+			return err
+		}
+		if resp.Done && req.includeUsage() {
+			return stream.Write(openAIResponse{
+				ID:      id,
+				Object:  "chat.completion.chunk",
+				Created: created,
+				Model:   req.Model,
+				Choices: []openAIChoice{},
+				Usage:   usageOf(resp.PromptEvalCount, resp.EvalCount),
+			})
+		}
+		return nil
+	})
+	stream.Finish(err)
+}
+
+func (s *Server) handleOpenAICompletion(c *gin.Context) {
+	var req openAICompletionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		openAIError(c, http.StatusBadRequest, "invalid_request_error", err.Error())
+		return
+	}
+
+	prompt, err := parseCompletionPrompt(req.Prompt)
+	if err != nil {
+		openAIError(c, http.StatusBadRequest, "invalid_request_error", err.Error())
+		return
+	}
+	options, err := req.options()
+	if err != nil {
+		openAIError(c, http.StatusBadRequest, "invalid_request_error", err.Error())
+		return
+	}
+	if err := checkModel(c, req.Model); err != nil {
+		openAIEngineError(c, err)
+		return
+	}
+	genReq := &inference.Request{
+		Model:   req.Model,
+		Prompt:  prompt,
+		Options: options,
+	}
+
+	id := "cmpl-" + newCompletionID()
+	created := time.Now().Unix()
+
+	if !req.Stream {
+		resp, err := s.generator.Process(c.Request.Context(), genReq)
+		if err != nil {
+			openAIEngineError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, openAIResponse{
+			ID:      id,
+			Object:  "text_completion",
+			Created: created,
+			Model:   req.Model,
+			Choices: []openAIChoice{{
+				Text:         &resp.Response,
+				FinishReason: finishReason(resp.DoneReason, false),
+			}},
+			Usage: usageOf(resp.PromptEvalCount, resp.EvalCount),
+		})
+		return
+	}
+
+	stream := newSSEWriter(c)
+	err = s.generator.ProcessStream(c.Request.Context(), genReq, func(resp *inference.Response) error {
+		text := resp.Response
+		chunk := openAIResponse{
+			ID:      id,
+			Object:  "text_completion",
+			Created: created,
+			Model:   req.Model,
+			Choices: []openAIChoice{{Text: &text}},
+		}
+		if resp.Done {
+			chunk.Choices[0].FinishReason = finishReason(resp.DoneReason, false)
+		}
+		if err := stream.Write(chunk); err != nil {
+			return err
+		}
+		if resp.Done && req.includeUsage() {
+			return stream.Write(openAIResponse{
+				ID:      id,
+				Object:  "text_completion",
+				Created: created,
+				Model:   req.Model,
+				Choices: []openAIChoice{},
+				Usage:   usageOf(resp.PromptEvalCount, resp.EvalCount),
+			})
+		}
+		return nil
+	})
+	stream.Finish(err)
+}
+
+func (s *Server) handleOpenAIEmbeddings(c *gin.Context) {
+	var req openAIEmbeddingRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		openAIError(c, http.StatusBadRequest, "invalid_request_error", err.Error())
+		return
+	}
+	if req.EncodingFormat != "" && req.EncodingFormat != "float" && req.EncodingFormat != "base64" {
+		openAIError(c, http.StatusBadRequest, "invalid_request_error", "encoding_format must be float or base64")
+		return
+	}
+	if err := checkModel(c, req.Model); err != nil {
+		openAIEngineError(c, err)
+		return
+	}
+
+	resp, err := s.generator.Embed(c.Request.Context(), &inference.EmbedRequest{
+		Model: req.Model,
+		Input: req.Input,
+	})
+	if err != nil {
+		openAIEngineError(c, err)
+		return
+	}
+
+	data := make([]openAIEmbedding, len(resp.Embeddings))
+	for i, vec := range resp.Embeddings {
+		data[i] = openAIEmbedding{Object: "embedding", Embedding: vec, Index: i}
+		if req.EncodingFormat == "base64" {
+			data[i].Embedding = encodeFloat32s(vec)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"object": "list",
+		"data":   data,
+		"model":  req.Model,
+		"usage": gin.H{
+			"prompt_tokens": resp.PromptEvalCount,
+			"total_tokens":  resp.PromptEvalCount,
+		},
+	})
+}
+
+// encodeFloat32s packs vec as little-endian float32s, the layout OpenAI
+// clients expect for encoding_format=base64.
+func encodeFloat32s(vec []float32) string {
+	buf := make([]byte, 4*len(vec))
+	for i, f := range vec {
+		binary.LittleEndian.PutUint32(buf[4*i:], math.Float32bits(f))
+	}
+	return base64.StdEncoding.EncodeToString(buf)
+}
+
+func (s *Server) handleOpenAIListModels(c *gin.Context) {
+	models, err := s.engine.ListModels(c.Request.Context())
+	if err != nil {
+		openAIEngineError(c, err)
+		return
+	}
+
+	models = allowedModels(c, models)
+	data := make([]openAIModel, 0, len(models))
+	for _, m := range models {
+		data = append(data, toOpenAIModel(m))
+	}
+	c.JSON(http.StatusOK, gin.H{"object": "list", "data": data})
+}
+
+func (s *Server) handleOpenAIGetModel(c *gin.Context) {
+	models, err := s.engine.ListModels(c.Request.Context())
+	if err != nil {
+		openAIEngineError(c, err)
+		return
+	}
+
+	name := c.Param("model")
+	for _, m := range allowedModels(c, models) {
+		if m.Name == name {
+			c.JSON(http.StatusOK, toOpenAIModel(m))
+			return
+		}
+	}
+	openAIError(c, http.StatusNotFound, "invalid_request_error", fmt.Sprintf("model %q not found", name))
+}
+
+func toOpenAIModel(m inference.Model) openAIModel {
+	return openAIModel{
+		ID:      m.Name,
+		Object:  "model",
+		Created: m.Modified.Unix(),
+		OwnedBy: "library",
+	}
+}
+
+// options maps OpenAI sampling parameters onto Ollama option names.
+func (p *openAISamplingParams) options() (map[string]interface{}, error) {
+	options := make(map[string]interface{})
+	if p.Temperature != nil {
+		options["temperature"] = *p.Temperature
+	}
+	if p.TopP != nil {
+		options["top_p"] = *p.TopP
+	}
+	if p.MaxTokens != nil {
+		options["num_predict"] = *p.MaxTokens
+	}
+	if p.Seed != nil {
+		options["seed"] = *p.Seed
+	}
+	if p.FrequencyPenalty != nil {
+		options["frequency_penalty"] = *p.FrequencyPenalty
+	}
+	if p.PresencePenalty != nil {
+		options["presence_penalty"] = *p.PresencePenalty
+	}
+
+	if len(p.Stop) > 0 && string(p.Stop) != "null" {
+		var stop []string
+		var single string
+		if err := json.Unmarshal(p.Stop, &single); err == nil {
+			stop = []string{single}
+		} else if err := json.Unmarshal(p.Stop, &stop); err != nil {
+			return nil, fmt.Errorf("stop must be a string or an array of strings")
+		}
+		options["stop"] = stop
+	}
+
+	return options, nil
+}
+
+func (p *openAISamplingParams) includeUsage() bool {
+	return p.StreamOptions != nil && p.StreamOptions.IncludeUsage
+}
+
+func (r *openAIChatRequest) toChatRequest() (*inference.ChatRequest, error) {
+	if len(r.Messages) == 0 {
+		return nil, fmt.Errorf("messages must not be empty")
+	}
+
+	options, err := r.options()
+	if err != nil {
+		return nil, err
+	}
+
+	chatReq := &inference.ChatRequest{
+		Model:   r.Model,
+		Tools:   r.Tools,
+		Stream:  r.Stream,
+		Options: options,
+	}
+	if r.ResponseFormat != nil && r.ResponseFormat.Type == "json_object" {
+		chatReq.Format = json.RawMessage(`"json"`)
+	}
+
+	for i, m := range r.Messages {
+		msg, err := m.toMessage()
+		if err != nil {
+			return nil, fmt.Errorf("messages[%d]: %w", i, err)
+		}
+		chatReq.Messages = append(chatReq.Messages, msg)
+	}
+
+	return chatReq, nil
+}
+
+// toMessage accepts both plain string content and the array-of-parts form
+// used for multimodal input. Images must be inline data URLs since Ollama
+// does not fetch remote images.
+func (m *openAIMessage) toMessage() (inference.Message, error) {
+	msg := inference.Message{Role: m.Role}
+
+	if len(m.Content) > 0 && string(m.Content) != "null" {
+		if err := json.Unmarshal(m.Content, &msg.Content); err != nil {
+			var parts []openAIContentPart
+			if err := json.Unmarshal(m.Content, &parts); err != nil {
+				return msg, fmt.Errorf("content must be a string or an array of content parts")
+			}
+			var text []string
+			for _, part := range parts {
+				switch part.Type {
+				case "text":
+					text = append(text, part.Text)
+				case "image_url":
+					_, data, ok := strings.Cut(part.ImageURL.URL, ";base64,")
+					if !ok || !strings.HasPrefix(part.ImageURL.URL, "data:") {
+						return msg, fmt.Errorf("only base64 data URLs are supported for images")
+					}
+					msg.Images = append(msg.Images, data)
+				default:
+					return msg, fmt.Errorf("unsupported content part type %q", part.Type)
+				}
+			}
+			msg.Content = strings.Join(text, "\n")
+		}
+	}
+
+	for _, tc := range m.ToolCalls {
+		var args map[string]interface{}
+		if tc.Function.Arguments != "" {
+			if err := json.Unmarshal([]byte(tc.Function.Arguments), &args); err != nil {
+				return msg, fmt.Errorf("tool call %s: arguments are not a JSON object", tc.ID)
+			}
+		}
+		msg.ToolCalls = append(msg.ToolCalls, inference.ToolCall{
+			Function: inference.ToolCallFunction{Name: tc.Function.Name, Arguments: args},
+		})
+	}
+
+	return msg, nil
+}
+
+func toOpenAIReply(m inference.Message) openAIReplyMessage {
+	reply := openAIReplyMessage{Role: m.Role, Content: m.Content}
+	for i, tc := range m.ToolCalls {
+		args, _ := json.Marshal(tc.Function.Arguments)
+		call := openAIToolCall{ID: "call_" + newCompletionID(), Type: "function"}
+		index := i
+		call.Index = &index
+		call.Function.Name = tc.Function.Name
+		call.Function.Arguments = string(args)
+		reply.ToolCalls = append(reply.ToolCalls, call)
+	}
+	return reply
+}
+
+func parseCompletionPrompt(raw json.RawMessage) (string, error) {
+	var prompt string
+	if err := json.Unmarshal(raw, &prompt); err == nil {
+		return prompt, nil
+	}
+	var prompts []string
+	if err := json.Unmarshal(raw, &prompts); err != nil || len(prompts) != 1 {
+		return "", fmt.Errorf("prompt must be a string or an array containing exactly one string")
+	}
+	return prompts[0], nil
+}
+
+// finishReason maps Ollama's done_reason onto OpenAI's finish_reason.
+func finishReason(doneReason string, toolCalls bool) *string {
+	reason := "stop"
+	switch {
+	case toolCalls:
+		reason = "tool_calls"
+	case doneReason == "length":
+		reason = "length"
+	}
+	return &reason
+}
+
+func usageOf(promptTokens, completionTokens int) *openAIUsage {
+	return &openAIUsage{
+		PromptTokens:     promptTokens,
+		CompletionTokens: completionTokens,
+		TotalTokens:      promptTokens + completionTokens,
+	}
+}
+
+func newCompletionID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
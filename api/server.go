@@ -1,56 +1,327 @@
 package api
 
 import (
-"context"
-"net/http"
+	"context"
+	"crypto/tls"
+	"errors"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
 
-"github.com/gin-gonic/gin"
-"github.com/khryptorgraphics/ollama-nova/internal/inference"
+	"github.com/gin-gonic/gin"
+	"github.com/khryptorgraphics/ollama-nova/internal/inference"
+	"github.com/khryptorgraphics/ollama-nova/internal/routing"
+	"github.com/khryptorgraphics/ollama-nova/internal/security"
 )
 
 type Server struct {
-engine *inference.Engine
-router *gin.Engine
+	engine *inference.Engine
+	// generator runs generate, chat and embed requests; it is the engine
+	// itself unless a peer-aware router is installed with SetRouter.
+	generator routing.Router
+	router    *gin.Engine
+	peers     PeerCatalog
+
+	authMu sync.RWMutex
+	auths  []security.Authenticator
+	keys   *security.KeyStore
+
+	httpServer *http.Server
+}
+
+// PeerCatalog reports the models served by connected peers, keyed by peer
+// ID. It is implemented by p2p.Catalog.
+type PeerCatalog interface {
+	PeerModels(ctx context.Context) map[string][]inference.Model
 }
 
 func NewServer(engine *inference.Engine) *Server {
-return &Server{
-engine: engine,
-router: gin.Default(),
+	s := &Server{
+		engine:    engine,
+		generator: engine,
+		router:    gin.Default(),
+	}
+	s.httpServer = &http.Server{Handler: s.router}
+	return s
 }
+
+// SetRouter makes generate, chat and embed requests go through r, which
+// may run them on peers.
+func (s *Server) SetRouter(r routing.Router) {
+	s.generator = r
+}
+
+// SetPeerCatalog enables the network-wide view of /api/models.
+func (s *Server) SetPeerCatalog(peers PeerCatalog) {
+	s.peers = peers
 }
 
 func (s *Server) SetupRoutes() {
-s.router.POST("/api/generate", s.handleGenerate)
-s.router.GET("/api/models", s.handleListModels)
-s.router.GET("/health", s.handleHealth)
+	s.router.Use(s.requestContext)
+	generate := s.require(security.ScopeGenerate)
+	s.router.POST("/api/generate", generate, s.handleGenerate)
+	s.router.POST("/api/chat", generate, s.handleChat)
+	s.router.POST("/api/embed", generate, s.handleEmbed)
+	s.router.POST("/api/embeddings", generate, s.handleEmbeddings)
+	s.router.GET("/api/tags", generate, s.handleListTags)
+	s.router.GET("/api/models", generate, s.handleListModels)
+	s.router.GET("/api/peers", generate, s.handleListPeers)
+	s.router.GET("/api/backends", s.require(security.ScopeAdmin), s.handleListBackends)
+	s.router.GET("/health", s.handleHealth)
+	s.setupModelRoutes()
+	s.setupKeyRoutes()
+	s.setupOpenAIRoutes()
 }
 
 func (s *Server) handleGenerate(c *gin.Context) {
-var req inference.Request
-if err := c.ShouldBindJSON(&req); err != nil {
-c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-return
+	var req inference.Request
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := checkModel(c, req.Model); err != nil {
+		engineError(c, err)
+		return
+	}
+
+	if req.Stream {
+		s.streamGenerate(c, &req)
+		return
+	}
+
+	resp, err := s.generator.Process(c.Request.Context(), &req)
+	if err != nil {
+		engineError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// streamGenerate writes each chunk as a line of NDJSON and flushes it
+// immediately. The request context is canceled when the client goes away,
+// which in turn aborts the upstream generation.
+func (s *Server) streamGenerate(c *gin.Context, req *inference.Request) {
+	stream := newNDJSONWriter(c)
+	err := s.generator.ProcessStream(c.Request.Context(), req, func(resp *inference.Response) error {
+		return stream.Write(resp)
+	})
+	stream.Finish(err)
 }
 
-resp, err := s.engine.Process(context.Background(), &req)
-if err != nil {
-c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-return
+func (s *Server) handleChat(c *gin.Context) {
+	var req inference.ChatRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(req.Messages) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "messages must not be empty"})
+		return
+	}
+	if err := checkModel(c, req.Model); err != nil {
+		engineError(c, err)
+		return
+	}
+
+	if req.Stream {
+		stream := newNDJSONWriter(c)
+		err := s.generator.ChatStream(c.Request.Context(), &req, func(resp *inference.ChatResponse) error {
+			return stream.Write(resp)
+		})
+		stream.Finish(err)
+		return
+	}
+
+	resp, err := s.generator.Chat(c.Request.Context(), &req)
+	if err != nil {
+		engineError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
 }
 
-c.JSON(http.StatusOK, resp)
+func (s *Server) handleListTags(c *gin.Context) {
+	models, err := s.engine.ListModels(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"models": allowedModels(c, models)})
 }
 
+// networkModel is a /api/tags entry annotated with where the model can run.
+type networkModel struct {
+	inference.Model
+	Local bool     `json:"local"`
+	Peers []string `json:"peers,omitempty"`
+}
+
+// handleListModels merges the local model list with the models reported by
+// connected peers. If the local Ollama is unreachable, peer models are still
+// listed.
 func (s *Server) handleListModels(c *gin.Context) {
-c.JSON(http.StatusOK, gin.H{"models": []string{"llama2", "mistral"}})
+	ctx := c.Request.Context()
+
+	local, err := s.engine.ListModels(ctx)
+	if err != nil && s.peers == nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+
+	byName := make(map[string]*networkModel)
+	var names []string
+	for _, m := range allowedModels(c, local) {
+		byName[m.Name] = &networkModel{Model: m, Local: true}
+		names = append(names, m.Name)
+	}
+
+	if s.peers != nil {
+		for peerID, models := range s.peers.PeerModels(ctx) {
+			for _, m := range allowedModels(c, models) {
+				entry, ok := byName[m.Name]
+				if !ok {
+					m.Loaded = false
+					entry = &networkModel{Model: m}
+					byName[m.Name] = entry
+					names = append(names, m.Name)
+				}
+				entry.Peers = append(entry.Peers, peerID)
+			}
+		}
+	}
+
+	sort.Strings(names)
+	result := make([]*networkModel, 0, len(names))
+	for _, name := range names {
+		sort.Strings(byName[name].Peers)
+		result = append(result, byName[name])
+	}
+
+	c.JSON(http.StatusOK, gin.H{"models": result})
+}
+
+type peerEntry struct {
+	ID     string   `json:"id"`
+	Models []string `json:"models"`
+}
+
+// handleListPeers lists the connected peers that serve models, with the
+// names of their models.
+func (s *Server) handleListPeers(c *gin.Context) {
+	peers := []peerEntry{}
+	if s.peers != nil {
+		for id, models := range s.peers.PeerModels(c.Request.Context()) {
+			entry := peerEntry{ID: id, Models: make([]string, 0, len(models))}
+			for _, m := range allowedModels(c, models) {
+				entry.Models = append(entry.Models, m.Name)
+			}
+			sort.Strings(entry.Models)
+			peers = append(peers, entry)
+		}
+	}
+	sort.Slice(peers, func(i, j int) bool { return peers[i].ID < peers[j].ID })
+
+	c.JSON(http.StatusOK, gin.H{"peers": peers})
+}
+
+// handleListBackends reports the health and inventory of the Ollama
+// instances behind this node.
+func (s *Server) handleListBackends(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"backends": s.engine.Backends()})
+}
+
+// errorStatus maps engine errors onto HTTP status codes.
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, inference.ErrInvalidRequest), errors.Is(err, inference.ErrInvalidOptions):
+		return http.StatusBadRequest
+	case errors.Is(err, inference.ErrModelNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, inference.ErrQueueFull), errors.Is(err, inference.ErrNoBackend), errors.Is(err, security.ErrAuthUnavailable):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, inference.ErrUnsupported):
+		return http.StatusNotImplemented
+	case errors.Is(err, security.ErrNoCredentials), errors.Is(err, security.ErrUnauthenticated):
+		return http.StatusUnauthorized
+	case errors.Is(err, security.ErrForbidden):
+		return http.StatusForbidden
+	}
+	return http.StatusInternalServerError
+}
+
+// engineError reports an engine error as an Ollama-style JSON response.
+func engineError(c *gin.Context, err error) {
+	setRetryAfter(c, err)
+	c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+}
+
+// setRetryAfter tells clients rejected by a full queue when to come back.
+func setRetryAfter(c *gin.Context, err error) {
+	var full *inference.QueueFullError
+	if errors.As(err, &full) && full.RetryAfter > 0 {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(full.RetryAfter.Seconds()))))
+	}
+}
+
+// PriorityHeader selects the priority class of a request: low, normal or
+// high. High needs the priority scope.
+const PriorityHeader = "X-Nova-Priority"
+
+// requestContext tags every request with its client and priority class for
+// the engine's fair queue.
+func (s *Server) requestContext(c *gin.Context) {
+	priority, err := inference.ParsePriority(c.GetHeader(PriorityHeader))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx := inference.WithClient(c.Request.Context(), c.ClientIP())
+	ctx = inference.WithPriority(ctx, priority)
+	c.Request = c.Request.WithContext(ctx)
+	c.Next()
 }
 
 func (s *Server) handleHealth(c *gin.Context) {
-c.JSON(http.StatusOK, gin.H{"status": "healthy"})
+	c.JSON(http.StatusOK, gin.H{"status": "healthy"})
+}
+
+// SetTLSConfig makes Start serve HTTPS with cfg, as created by
+// security.Manager.CreateTLSConfig. A nil cfg serves plain HTTP.
+func (s *Server) SetTLSConfig(cfg *tls.Config) {
+	s.httpServer.TLSConfig = cfg
 }
 
+// Start serves the API on addr until Shutdown is called, at which point it
+// returns nil.
 func (s *Server) Start(addr string) error {
-s.SetupRoutes()
-return s.router.Run(addr)
+	s.SetupRoutes()
+	s.httpServer.Addr = addr
+	var err error
+	if s.httpServer.TLSConfig != nil {
+		// The certificate comes from the TLS configuration.
+		err = s.httpServer.ListenAndServeTLS("", "")
+	} else {
+		err = s.httpServer.ListenAndServe()
+	}
+	if !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// Shutdown stops accepting connections and waits for in-flight requests,
+// including streaming ones, to finish. When ctx expires first, the
+// remaining connections are closed, which cancels their requests.
+func (s *Server) Shutdown(ctx context.Context) error {
+	err := s.httpServer.Shutdown(ctx)
+	if err != nil {
+		s.httpServer.Close()
+	}
+	return err
 }
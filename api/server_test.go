@@ -0,0 +1,206 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/khryptorgraphics/ollama-nova/internal/inference"
+	"github.com/khryptorgraphics/ollama-nova/internal/security"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// fakeRouter answers generate requests with err, if set, and records the
+// priority class of the last request.
+type fakeRouter struct {
+	err error
+
+	mu       sync.Mutex
+	priority inference.Priority
+	calls    int
+}
+
+func (f *fakeRouter) record(ctx context.Context) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls++
+	f.priority = inference.PriorityFromContext(ctx)
+	return f.err
+}
+
+func (f *fakeRouter) last() (inference.Priority, int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.priority, f.calls
+}
+
+func (f *fakeRouter) Process(ctx context.Context, req *inference.Request) (*inference.Response, error) {
+	if err := f.record(ctx); err != nil {
+		return nil, err
+	}
+	return &inference.Response{Model: req.Model, Response: "ok", Done: true}, nil
+}
+
+func (f *fakeRouter) ProcessStream(ctx context.Context, req *inference.Request, fn func(*inference.Response) error) error {
+	if err := f.record(ctx); err != nil {
+		return err
+	}
+	return fn(&inference.Response{Model: req.Model, Response: "ok", Done: true})
+}
+
+func (f *fakeRouter) Chat(ctx context.Context, req *inference.ChatRequest) (*inference.ChatResponse, error) {
+	if err := f.record(ctx); err != nil {
+		return nil, err
+	}
+	return &inference.ChatResponse{Model: req.Model, Done: true}, nil
+}
+
+func (f *fakeRouter) ChatStream(ctx context.Context, req *inference.ChatRequest, fn func(*inference.ChatResponse) error) error {
+	if err := f.record(ctx); err != nil {
+		return err
+	}
+	return fn(&inference.ChatResponse{Model: req.Model, Done: true})
+}
+
+func (f *fakeRouter) Embed(ctx context.Context, req *inference.EmbedRequest) (*inference.EmbedResponse, error) {
+	if err := f.record(ctx); err != nil {
+		return nil, err
+	}
+	return &inference.EmbedResponse{Model: req.Model, Embeddings: make([][]float32, len(req.Input))}, nil
+}
+
+// fakeAuth accepts bearer tokens from a fixed table.
+type fakeAuth struct {
+	tokens map[string]*security.Identity
+}
+
+func (a *fakeAuth) Authenticate(r *http.Request) (*security.Identity, error) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return nil, security.ErrNoCredentials
+	}
+	id, ok := a.tokens[token]
+	if !ok {
+		return nil, security.ErrUnauthenticated
+	}
+	return id, nil
+}
+
+func (a *fakeAuth) Challenge() string {
+	return `Bearer realm="nova"`
+}
+
+// newTestServer returns a server that routes generate requests to router
+// and authenticates with auths, if any.
+func newTestServer(t *testing.T, router *fakeRouter, auths ...security.Authenticator) *Server {
+	t.Helper()
+	s := NewServer(inference.NewEngine())
+	s.SetRouter(router)
+	s.SetAuthenticators(auths...)
+	s.SetupRoutes()
+	return s
+}
+
+// do sends a request to s with the given headers.
+func do(s *Server, method, path, body string, header map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	return w
+}
+
+const generateBody = `{"model":"llama3","prompt":"hi"}`
+
+func TestQueueFullResponse(t *testing.T) {
+	full := &inference.QueueFullError{RetryAfter: 1500 * time.Millisecond}
+	s := newTestServer(t, &fakeRouter{err: full})
+
+	tests := []struct {
+		path string
+		body string
+	}{
+		{"/api/generate", generateBody},
+		{"/api/generate", `{"model":"llama3","prompt":"hi","stream":true}`},
+		{"/api/chat", `{"model":"llama3","messages":[{"role":"user","content":"hi"}]}`},
+		{"/api/embed", `{"model":"llama3","input":"hi"}`},
+		{"/v1/chat/completions", `{"model":"llama3","messages":[{"role":"user","content":"hi"}]}`},
+	}
+	for _, tt := range tests {
+		w := do(s, http.MethodPost, tt.path, tt.body, nil)
+		if w.Code != http.StatusServiceUnavailable {
+			t.Errorf("%s %s: status %d, want 503", tt.path, tt.body, w.Code)
+		}
+		if got := w.Header().Get("Retry-After"); got != "2" {
+			t.Errorf("%s %s: Retry-After %q, want 2", tt.path, tt.body, got)
+		}
+	}
+}
+
+func TestPriorityHeader(t *testing.T) {
+	auth := &fakeAuth{tokens: map[string]*security.Identity{
+		"user":  {Name: "user", Scopes: []security.Scope{security.ScopeGenerate}},
+		"batch": {Name: "batch", Scopes: []security.Scope{security.ScopeGenerate, security.ScopePriority}},
+	}}
+
+	tests := []struct {
+		name     string
+		auth     bool
+		token    string
+		priority string
+		status   int
+		want     inference.Priority
+	}{
+		{name: "default", status: http.StatusOK, want: inference.PriorityNormal},
+		{name: "low", priority: "low", status: http.StatusOK, want: inference.PriorityLow},
+		{name: "unknown class", priority: "urgent", status: http.StatusBadRequest},
+		{name: "high without auth", priority: "high", status: http.StatusForbidden},
+		{name: "high without the scope", auth: true, token: "user", priority: "high", status: http.StatusForbidden},
+		{name: "high with the scope", auth: true, token: "batch", priority: "HIGH", status: http.StatusOK, want: inference.PriorityHigh},
+		{name: "low with auth", auth: true, token: "user", priority: "low", status: http.StatusOK, want: inference.PriorityLow},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := &fakeRouter{}
+			var s *Server
+			if tt.auth {
+				s = newTestServer(t, router, auth)
+			} else {
+				s = newTestServer(t, router)
+			}
+			header := map[string]string{}
+			if tt.priority != "" {
+				header[PriorityHeader] = tt.priority
+			}
+			if tt.token != "" {
+				header["Authorization"] = "Bearer " + tt.token
+			}
+
+			w := do(s, http.MethodPost, "/api/generate", generateBody, header)
+			if w.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			priority, calls := router.last()
+			if tt.status != http.StatusOK {
+				if calls != 0 {
+					t.Error("rejected request reached the router")
+				}
+				return
+			}
+			if priority != tt.want {
+				t.Errorf("priority %s, want %s", priority, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ndjsonWriter streams newline-delimited JSON objects to the client. Headers
+// are sent lazily with the first object so that an error occurring before
+// any output can still be reported with a proper status code.
+type ndjsonWriter struct {
+	c       *gin.Context
+	started bool
+}
+
+func newNDJSONWriter(c *gin.Context) *ndjsonWriter {
+	return &ndjsonWriter{c: c}
+}
+
+func (w *ndjsonWriter) Write(v interface{}) error {
+	if !w.started {
+		w.c.Header("Content-Type", "application/x-ndjson")
+		w.c.Header("Cache-Control", "no-cache")
+		w.c.Status(http.StatusOK)
+		w.started = true
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if _, err := w.c.Writer.Write(data); err != nil {
+		return err
+	}
+	w.c.Writer.Flush()
+	return nil
+}
+
+// Finish reports err to the client. Before the first object it becomes a
+// regular JSON error response; mid-stream it is sent as a final
+// {"error": ...} line, matching Ollama's behaviour. Errors caused by the
+// client disconnecting are dropped.
+func (w *ndjsonWriter) Finish(err error) {
+	if err == nil || w.c.Request.Context().Err() != nil {
+		return
+	}
+	if !w.started {
+		engineError(w.c, err)
+		return
+	}
+	w.Write(gin.H{"error": err.Error()})
+}
+
+// sseWriter streams OpenAI-style server-sent events, terminated by a
+// "data: [DONE]" event.
+type sseWriter struct {
+	c       *gin.Context
+	started bool
+}
+
+func newSSEWriter(c *gin.Context) *sseWriter {
+	return &sseWriter{c: c}
+}
+
+func (w *sseWriter) Write(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return w.event(data)
+}
+
+func (w *sseWriter) event(data []byte) error {
+	if !w.started {
+		w.c.Header("Content-Type", "text/event-stream")
+		w.c.Header("Cache-Control", "no-cache")
+		w.c.Header("Connection", "keep-alive")
+		w.c.Status(http.StatusOK)
+		w.started = true
+	}
+
+	if _, err := fmt.Fprintf(w.c.Writer, "data: %s\n\n", data); err != nil {
+		return err
+	}
+	w.c.Writer.Flush()
+	return nil
+}
+
+// Finish terminates the event stream. An error before the first event is
+// returned as a regular OpenAI error response instead.
+func (w *sseWriter) Finish(err error) {
+	if w.c.Request.Context().Err() != nil {
+		return
+	}
+	if err != nil {
+		if !w.started {
+			openAIEngineError(w.c, err)
+			return
+		}
+		w.Write(gin.H{"error": gin.H{"message": err.Error(), "type": "api_error"}})
+	}
+	w.event([]byte("[DONE]"))
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/khryptorgraphics/ollama-nova/internal/config"
+	"github.com/khryptorgraphics/ollama-nova/internal/p2p"
+	"github.com/khryptorgraphics/ollama-nova/internal/security"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// runCA implements "ca init", "ca issue", "ca list", "ca revoke", "ca crl"
+// and "ca cert", which manage the built-in CA in security.ca_dir on the
+// local machine.
+func runCA(args []string) error {
+	if len(args) == 0 {
+		return usageError("ca needs a subcommand: init, issue, list, revoke, crl or cert")
+	}
+
+	sub := args[0]
+	if sub == "issue" {
+		return runCAIssue(args[1:])
+	}
+
+	fs := flag.NewFlagSet("ca "+sub, flag.ExitOnError)
+	configPath := fs.String("config", defaultConfigPath, "configuration file naming the CA directory")
+	dir := fs.String("dir", "", "CA directory, overriding security.ca_dir")
+	var name, org, keyType, parent *string
+	var validity *time.Duration
+	if sub == "init" {
+		name = fs.String("name", "Ollama-Nova CA", "common name of the CA")
+		org = fs.String("org", "Ollama-Nova", "organization of the CA and the certificates it issues")
+		keyType = fs.String("key-type", string(security.KeyECDSA), "key algorithm: ecdsa or ed25519")
+		validity = fs.Duration("validity", security.DefaultCAValidity, "lifetime of the CA certificate")
+		parent = fs.String("parent", "", "directory of a CA to sign this one as an intermediate")
+	}
+	fs.Parse(args[1:])
+	if sub == "revoke" {
+		if fs.NArg() == 0 {
+			return usageError("ca revoke needs at least one serial number")
+		}
+	} else if fs.NArg() > 0 {
+		return usageError(fmt.Sprintf("ca %s takes no arguments", sub))
+	}
+
+	switch sub {
+	case "init":
+		caDir, err := resolveCADir(*configPath, *dir)
+		if err != nil {
+			return err
+		}
+		alg, err := security.ParseKeyAlgorithm(*keyType)
+		if err != nil {
+			return usageError(err.Error())
+		}
+		opts := security.CAOptions{
+			CommonName:   *name,
+			Organization: *org,
+			Validity:     *validity,
+			KeyAlgorithm: alg,
+		}
+		if *parent != "" {
+			if opts.Parent, err = security.OpenCA(*parent); err != nil {
+				return err
+			}
+		}
+		ca, err := security.InitCA(caDir, opts)
+		if err != nil {
+			return err
+		}
+		kind := "root"
+		if opts.Parent != nil {
+			kind = "intermediate"
+		}
+		fmt.Printf("Created %s CA %q in %s, valid until %s\n", kind, ca.Certificate().Subject.CommonName, caDir, ca.Certificate().NotAfter.Local().Format(time.DateTime))
+		return nil
+
+	case "list":
+		ca, err := openCA(*configPath, *dir)
+		if err != nil {
+			return err
+		}
+		issued, err := ca.Issued()
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+		fmt.Fprintln(w, "SERIAL\tKIND\tSUBJECT\tNAMES\tEXPIRES")
+		for _, c := range issued {
+			names := strings.Join(append(append([]string{}, c.DNSNames...), c.IPAddresses...), ",")
+			if names == "" {
+				names = "-"
+			}
+			expires := c.NotAfter.Local().Format(time.DateTime)
+			if c.RevokedAt != nil {
+				expires += " (revoked)"
+			} else if time.Now().After(c.NotAfter) {
+				expires += " (expired)"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", c.Serial, c.Kind, c.Subject, names, expires)
+		}
+		return w.Flush()
+
+	case "revoke":
+		ca, err := openCA(*configPath, *dir)
+		if err != nil {
+			return err
+		}
+		for _, serial := range fs.Args() {
+			if err := ca.Revoke(serial); err != nil {
+				return err
+			}
+			fmt.Printf("revoked %s\n", serial)
+		}
+		return nil
+
+	case "crl":
+		ca, err := openCA(*configPath, *dir)
+		if err != nil {
+			return err
+		}
+		crl, err := ca.CRL()
+		if err != nil {
+			return err
+		}
+		_, err = os.Stdout.Write(crl)
+		return err
+
+	case "cert":
+		ca, err := openCA(*configPath, *dir)
+		if err != nil {
+			return err
+		}
+		_, err = os.Stdout.Write(ca.RootPEM())
+		return err
+	}
+	return usageError(fmt.Sprintf("unknown ca subcommand %q", sub))
+}
+
+// runCAIssue implements "ca issue node" and "ca issue client". Without
+// --out, a node certificate is installed at security.cert_path and
+// security.key_path, replacing the one a running node serves.
+func runCAIssue(args []string) error {
+	if len(args) == 0 {
+		return usageError("ca issue needs a certificate kind: node or client")
+	}
+
+	kind := security.CertKind(args[0])
+	if kind != security.CertKindNode && kind != security.CertKindClient {
+		return usageError(fmt.Sprintf("unknown certificate kind %q (want node or client)", args[0]))
+	}
+	fs := flag.NewFlagSet("ca issue "+args[0], flag.ExitOnError)
+	configPath := fs.String("config", defaultConfigPath, "configuration file naming the CA directory and certificate paths")
+	dir := fs.String("dir", "", "CA directory, overriding security.ca_dir")
+	name := fs.String("name", "", "common name of the certificate")
+	units := fs.String("ou", "", "comma-separated organizational units, which client_certs roles can match")
+	keyType := fs.String("key-type", string(security.KeyECDSA), "key algorithm: ecdsa or ed25519")
+	validity := fs.Duration("validity", security.DefaultCertValidity, "lifetime of the certificate")
+	out := fs.String("out", "", "write <out>.crt and <out>.key instead of installing the certificate")
+	var dnsNames, ips, peerID *string
+	if kind == security.CertKindNode {
+		dnsNames = fs.String("dns", "", "comma-separated DNS names (default this host's name and localhost, unless --ip is set)")
+		ips = fs.String("ip", "", "comma-separated IP addresses (default 127.0.0.1 and ::1, unless --dns is set)")
+		peerID = fs.String("peer-id", "", "libp2p peer ID to bind the certificate to (default that of p2p.key_file when installing)")
+	}
+	fs.Parse(args[1:])
+	if fs.NArg() > 0 {
+		return usageError(fmt.Sprintf("ca issue %s takes no arguments", kind))
+	}
+
+	alg, err := security.ParseKeyAlgorithm(*keyType)
+	if err != nil {
+		return usageError(err.Error())
+	}
+	req := security.CertRequest{
+		Kind:                kind,
+		CommonName:          *name,
+		OrganizationalUnits: splitList(*units),
+		Validity:            *validity,
+		KeyAlgorithm:        alg,
+	}
+	if kind == security.CertKindNode {
+		req.DNSNames = splitList(*dnsNames)
+		for _, s := range splitList(*ips) {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return usageError(fmt.Sprintf("invalid IP address %q", s))
+			}
+			req.IPAddresses = append(req.IPAddresses, ip)
+		}
+		if len(req.DNSNames) == 0 && len(req.IPAddresses) == 0 {
+			req.DNSNames, req.IPAddresses = defaultNodeNames()
+		}
+		if *peerID != "" {
+			id, err := peer.Decode(*peerID)
+			if err != nil {
+				return usageError(fmt.Sprintf("invalid peer ID %q: %v", *peerID, err))
+			}
+			req.PeerID = id.String()
+		}
+	} else {
+		if req.CommonName == "" {
+			return usageError("ca issue client needs --name")
+		}
+		if *out == "" {
+			*out = req.CommonName
+		}
+	}
+
+	var secCfg security.Config
+	if *out == "" || *dir == "" {
+		cfg, err := config.LoadConfig(*configPath)
+		if err != nil {
+			return err
+		}
+		secCfg = cfg.Security
+		// A certificate installed for this node carries its peer ID.
+		if *out == "" && req.PeerID == "" && cfg.P2P.KeyFile != "" {
+			id, err := p2p.IdentityPeerID(cfg.P2P.KeyFile)
+			if err != nil {
+				return err
+			}
+			req.PeerID = id.String()
+		}
+	}
+	if *dir == "" {
+		*dir = secCfg.CADir
+	}
+	ca, err := security.OpenCA(*dir)
+	if err != nil {
+		return err
+	}
+
+	if *out == "" {
+		// Only the certificate paths matter here. With tls on, SetConfig
+		// would also load ca_path, which may not have been written yet.
+		secCfg.TLSEnabled = false
+		manager := security.NewManager()
+		if err := manager.SetConfig(secCfg); err != nil {
+			return err
+		}
+		issued, err := manager.InstallNodeCert(ca, req)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Installed certificate %s for %s at %s, valid until %s\n", issued.Serial, issued.Subject, secCfg.CertPath, issued.NotAfter.Local().Format(time.DateTime))
+		if issued.PeerID != "" {
+			fmt.Printf("Bound to peer %s\n", issued.PeerID)
+		}
+		return nil
+	}
+
+	certPath, keyPath := *out+".crt", *out+".key"
+	for _, path := range []string{certPath, keyPath} {
+		if _, err := os.Stat(path); err == nil {
+			return fmt.Errorf("%s already exists", path)
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	certPEM, keyPEM, issued, err := ca.Issue(req)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(keyPath, keyPEM, 0o600); err != nil {
+		return err
+	}
+	if err := os.WriteFile(certPath, certPEM, 0o644); err != nil {
+		return err
+	}
+	fmt.Printf("Issued certificate %s for %s to %s and %s, valid until %s\n", issued.Serial, issued.Subject, certPath, keyPath, issued.NotAfter.Local().Format(time.DateTime))
+	return nil
+}
+
+// defaultNodeNames returns the names a node certificate covers when none
+// are given: this host's name and the loopback addresses.
+func defaultNodeNames() ([]string, []net.IP) {
+	names := []string{"localhost"}
+	if host, err := os.Hostname(); err == nil && host != "" && host != "localhost" {
+		names = append([]string{host}, names...)
+	}
+	return names, []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback}
+}
+
+func openCA(configPath, dir string) (*security.CA, error) {
+	caDir, err := resolveCADir(configPath, dir)
+	if err != nil {
+		return nil, err
+	}
+	return security.OpenCA(caDir)
+}
+
+// resolveCADir returns dir, or security.ca_dir from the configuration file
+// if dir is empty.
+func resolveCADir(configPath, dir string) (string, error) {
+	if dir != "" {
+		return dir, nil
+	}
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		return "", err
+	}
+	return cfg.Security.CADir, nil
+}
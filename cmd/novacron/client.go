@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// defaultHost is the API the client commands talk to unless --host or
+// NOVA_HOST says otherwise.
+const defaultHost = "http://127.0.0.1:8080"
+
+// apiClient talks to a running node over its HTTP API.
+type apiClient struct {
+	base   string
+	apiKey string
+	http   *http.Client
+}
+
+// clientFlags holds the flags shared by the client commands.
+type clientFlags struct {
+	host   *string
+	apiKey *string
+}
+
+// addClientFlags registers --host and --api-key on fs.
+func addClientFlags(fs *flag.FlagSet) *clientFlags {
+	host := os.Getenv("NOVA_HOST")
+	if host == "" {
+		host = defaultHost
+	}
+	return &clientFlags{
+		host:   fs.String("host", host, "address of the node's API (env NOVA_HOST)"),
+		apiKey: fs.String("api-key", os.Getenv("NOVA_API_KEY"), "API key to authenticate with (env NOVA_API_KEY)"),
+	}
+}
+
+func (f *clientFlags) client() *apiClient {
+	return newAPIClient(*f.host, *f.apiKey)
+}
+
+func newAPIClient(host, apiKey string) *apiClient {
+	if !strings.Contains(host, "://") {
+		host = "http://" + host
+	}
+	return &apiClient{
+		base:   strings.TrimRight(host, "/"),
+		apiKey: apiKey,
+		http:   &http.Client{},
+	}
+}
+
+// do sends a JSON request and decodes the JSON reply into out, if given.
+func (c *apiClient) do(ctx context.Context, method, path string, body, out interface{}) error {
+	resp, err := c.send(ctx, method, path, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+// stream sends a JSON request and calls fn for every line of the NDJSON
+// reply. An {"error": ...} line ends the stream with that error.
+func (c *apiClient) stream(ctx context.Context, method, path string, body interface{}, fn func(json.RawMessage) error) error {
+	resp, err := c.send(ctx, method, path, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return fmt.Errorf("failed to read response: %w", err)
+		}
+
+		var status struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(raw, &status) == nil && status.Error != "" {
+			return fmt.Errorf("%s", status.Error)
+		}
+		if err := fn(raw); err != nil {
+			return err
+		}
+	}
+}
+
+func (c *apiClient) send(ctx context.Context, method, path string, body interface{}) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal request: %w", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.base+path, reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach %s: %w", c.base, err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&apiErr) == nil && apiErr.Error != "" {
+			return nil, fmt.Errorf("%s: %s", resp.Status, apiErr.Error)
+		}
+		return nil, fmt.Errorf("%s", resp.Status)
+	}
+	return resp, nil
+}
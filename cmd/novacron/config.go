@@ -0,0 +1,44 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/khryptorgraphics/ollama-nova/internal/config"
+	"gopkg.in/yaml.v3"
+)
+
+// runConfig implements "config validate" and "config print-effective".
+func runConfig(args []string) error {
+	if len(args) == 0 {
+		return usageError("config needs a subcommand: validate or print-effective")
+	}
+
+	sub := args[0]
+	fs := flag.NewFlagSet("config "+sub, flag.ExitOnError)
+	configPath := fs.String("config", defaultConfigPath, "path to the configuration file")
+	fs.Parse(args[1:])
+
+	switch sub {
+	case "validate":
+		if _, err := config.LoadConfig(*configPath); err != nil {
+			return err
+		}
+		fmt.Printf("%s is valid\n", *configPath)
+		return nil
+
+	case "print-effective":
+		cfg, err := config.LoadConfig(*configPath)
+		if err != nil {
+			return err
+		}
+		enc := yaml.NewEncoder(os.Stdout)
+		enc.SetIndent(2)
+		if err := enc.Encode(cfg.Redacted()); err != nil {
+			return err
+		}
+		return enc.Close()
+	}
+	return usageError(fmt.Sprintf("unknown config subcommand %q", sub))
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/khryptorgraphics/ollama-nova/api"
+	"github.com/khryptorgraphics/ollama-nova/internal/security"
+)
+
+// runKeys implements "keys create", "keys list" and "keys revoke". They go
+// through the node's API with an admin key, or with --file straight to the
+// key file, which is how the first admin key is issued.
+func runKeys(args []string) error {
+	if len(args) == 0 {
+		return usageError("keys needs a subcommand: create, list or revoke")
+	}
+
+	sub := args[0]
+	fs := flag.NewFlagSet("keys "+sub, flag.ExitOnError)
+	remote := addClientFlags(fs)
+	file := fs.String("file", "", "manage the key file at this path instead of going through the API")
+	var name, scopes, models *string
+	var expires *time.Duration
+	if sub == "create" {
+		name = fs.String("name", "", "description of the key")
+		scopes = fs.String("scopes", string(security.ScopeGenerate), "comma-separated scopes: generate, manage-models, priority, admin")
+		models = fs.String("models", "", "comma-separated models the key may use (default all)")
+		expires = fs.Duration("expires", 0, "lifetime of the key, e.g. 720h (default never)")
+	}
+	fs.Parse(args[1:])
+
+	ctx, cancel := signalContext()
+	defer cancel()
+
+	switch sub {
+	case "create":
+		if fs.NArg() > 0 {
+			return usageError("keys create takes no arguments")
+		}
+		req := api.CreateKeyRequest{
+			Name:   *name,
+			Scopes: splitList(*scopes),
+			Models: splitList(*models),
+		}
+		if *expires > 0 {
+			req.ExpiresIn = expires.String()
+		}
+		resp, err := createKey(ctx, remote, *file, &req)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Created key %s. Store it now, it is not shown again:\n%s\n", resp.ID, resp.Key)
+		return nil
+
+	case "list":
+		if fs.NArg() > 0 {
+			return usageError("keys list takes no arguments")
+		}
+		keys, err := listKeys(ctx, remote, *file)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+		fmt.Fprintln(w, "ID\tNAME\tSCOPES\tMODELS\tCREATED\tEXPIRES")
+		for _, k := range keys {
+			scopes := make([]string, len(k.Scopes))
+			for i, s := range k.Scopes {
+				scopes[i] = string(s)
+			}
+			models := "all"
+			if len(k.Models) > 0 {
+				models = strings.Join(k.Models, ",")
+			}
+			expires := "never"
+			if k.ExpiresAt != nil {
+				expires = k.ExpiresAt.Local().Format(time.DateTime)
+				if k.Expired(time.Now()) {
+					expires += " (expired)"
+				}
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", k.ID, k.Name, strings.Join(scopes, ","), models, k.CreatedAt.Local().Format(time.DateTime), expires)
+		}
+		return w.Flush()
+
+	case "revoke":
+		if fs.NArg() == 0 {
+			return usageError("keys revoke needs at least one key ID")
+		}
+		for _, id := range fs.Args() {
+			if err := revokeKey(ctx, remote, *file, id); err != nil {
+				return err
+			}
+			fmt.Printf("revoked %s\n", id)
+		}
+		return nil
+	}
+	return usageError(fmt.Sprintf("unknown keys subcommand %q", sub))
+}
+
+func createKey(ctx context.Context, remote *clientFlags, file string, req *api.CreateKeyRequest) (*api.CreateKeyResponse, error) {
+	if file == "" {
+		var resp api.CreateKeyResponse
+		if err := remote.client().do(ctx, "POST", "/api/keys", req, &resp); err != nil {
+			return nil, err
+		}
+		return &resp, nil
+	}
+
+	scopes := make([]security.Scope, 0, len(req.Scopes))
+	for _, name := range req.Scopes {
+		scope, err := security.ParseScope(name)
+		if err != nil {
+			return nil, usageError(err.Error())
+		}
+		scopes = append(scopes, scope)
+	}
+	var ttl time.Duration
+	if req.ExpiresIn != "" {
+		ttl, _ = time.ParseDuration(req.ExpiresIn)
+	}
+	store, err := security.OpenKeyStore(file)
+	if err != nil {
+		return nil, err
+	}
+	token, key, err := store.Issue(req.Name, scopes, req.Models, ttl)
+	if err != nil {
+		return nil, err
+	}
+	return &api.CreateKeyResponse{Key: token, APIKey: key}, nil
+}
+
+func listKeys(ctx context.Context, remote *clientFlags, file string) ([]security.APIKey, error) {
+	if file == "" {
+		var resp struct {
+			Keys []security.APIKey `json:"keys"`
+		}
+		if err := remote.client().do(ctx, "GET", "/api/keys", nil, &resp); err != nil {
+			return nil, err
+		}
+		return resp.Keys, nil
+	}
+
+	store, err := security.OpenKeyStore(file)
+	if err != nil {
+		return nil, err
+	}
+	return store.List()
+}
+
+func revokeKey(ctx context.Context, remote *clientFlags, file, id string) error {
+	if file == "" {
+		return remote.client().do(ctx, "DELETE", "/api/keys/"+id, nil, nil)
+	}
+
+	store, err := security.OpenKeyStore(file)
+	if err != nil {
+		return err
+	}
+	return store.Revoke(id)
+}
+
+// splitList splits a comma-separated flag value, dropping empty entries.
+func splitList(s string) []string {
+	var out []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
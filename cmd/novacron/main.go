@@ -1,59 +1,89 @@
 package main
 
 import (
-"context"
-"log"
-"os"
-"os/signal"
-"syscall"
-
-"github.com/khryptorgraphics/ollama-nova/api"
-"github.com/khryptorgraphics/ollama-nova/internal/config"
-"github.com/khryptorgraphics/ollama-nova/internal/inference"
-"github.com/khryptorgraphics/ollama-nova/internal/monitoring"
-"github.com/khryptorgraphics/ollama-nova/internal/p2p"
-"github.com/khryptorgraphics/ollama-nova/internal/security"
+	"errors"
+	"fmt"
+	"io"
+	"os"
 )
 
-func main() {
-ctx, cancel := context.WithCancel(context.Background())
-defer cancel()
+const defaultConfigPath = "configs/prod.yaml"
 
-// Load configuration
-cfg, err := config.LoadConfig("configs/prod.yaml")
-if err != nil {
-log.Fatal("Failed to load config:", err)
-}
+const usage = `Usage: novacron <command> [flags] [arguments]
 
-// Initialize components
-monitor := monitoring.NewMonitor()
-security := security.NewManager()
-engine := inference.NewEngine()
+Commands:
+  serve                   start the node
+  run <model> <prompt>    generate a completion through the local API
+  list                    list models
+  pull <model>            download a model
+  rm <model>              delete a model
+  peers                   show connected nodes and their models
+  keys create             issue an API key
+  keys list               list API keys
+  keys revoke <id>...     revoke API keys
+  ca init                 create the built-in certificate authority
+  ca issue node|client    issue a node or client certificate
+  ca list                 list issued certificates
+  ca revoke <serial>...   revoke certificates and update the CRL
+  ca crl                  write and print a fresh CRL
+  ca cert                 print the CA certificate to trust
+  config validate         check a configuration file
+  config print-effective  print the configuration after defaults and
+                          NOVA_* overrides
 
-// Start P2P node
-p2pNode, dht, err := p2p.NewP2PNode(ctx, cfg.P2P.Bootstrap)
-if err != nil {
-log.Fatal("P2P initialization failed:", err)
-}
-defer p2pNode.Close()
-defer dht.Close()
-
-// Start API server
-server := api.NewServer(engine)
-go func() {
-if err := server.Start(":8080"); err != nil {
-log.Fatal("Server failed:", err)
-}
-}()
+Run "novacron <command> -h" for the flags of a command.
+`
+
+// usageError is returned for malformed command lines; it makes main print
+// the usage text and exit with status 2.
+type usageError string
 
-// Start monitoring
-go monitor.StartMetricsServer(cfg.Monitoring.MetricsPort)
+func (e usageError) Error() string { return string(e) }
 
-log.Println("Phase 1 MVP started on :8080")
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	cmd, args := os.Args[1], os.Args[2:]
+	var err error
+	switch cmd {
+	case "serve":
+		err = runServe(args)
+	case "run":
+		err = runGenerate(args)
+	case "list", "ls":
+		err = runList(args)
+	case "pull":
+		err = runPull(args)
+	case "rm":
+		err = runRemove(args)
+	case "peers":
+		err = runPeers(args)
+	case "keys":
+		err = runKeys(args)
+	case "ca":
+		err = runCA(args)
+	case "config":
+		err = runConfig(args)
+	case "help", "-h", "--help":
+		fmt.Fprint(os.Stdout, usage)
+	default:
+		err = usageError(fmt.Sprintf("unknown command %q", cmd))
+	}
+
+	if err != nil {
+		exit(os.Stderr, err)
+	}
+}
 
-// Handle shutdown
-sigChan := make(chan os.Signal, 1)
-signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-<-sigChan
-log.Println("Shutting down Phase 1 MVP...")
+func exit(w io.Writer, err error) {
+	var uerr usageError
+	if errors.As(err, &uerr) {
+		fmt.Fprintf(w, "Error: %v\n\n%s", err, usage)
+		os.Exit(2)
+	}
+	fmt.Fprintf(w, "Error: %v\n", err)
+	os.Exit(1)
 }
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/khryptorgraphics/ollama-nova/internal/inference"
+)
+
+// signalContext is canceled on Ctrl-C so that streaming commands stop the
+// request on the node as well.
+func signalContext() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), os.Interrupt)
+}
+
+// runGenerate streams a completion for a single prompt to stdout.
+func runGenerate(args []string) error {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	remote := addClientFlags(fs)
+	system := fs.String("system", "", "system prompt")
+	fs.Parse(args)
+	if fs.NArg() < 2 {
+		return usageError("run needs a model and a prompt")
+	}
+
+	ctx, cancel := signalContext()
+	defer cancel()
+
+	req := inference.Request{
+		Model:  fs.Arg(0),
+		Prompt: strings.Join(fs.Args()[1:], " "),
+		System: *system,
+		Stream: true,
+	}
+	err := remote.client().stream(ctx, "POST", "/api/generate", &req, func(raw json.RawMessage) error {
+		var chunk inference.Response
+		if err := json.Unmarshal(raw, &chunk); err != nil {
+			return fmt.Errorf("failed to decode response: %w", err)
+		}
+		fmt.Print(chunk.Response)
+		return nil
+	})
+	fmt.Println()
+	return err
+}
+
+// runList prints the local models, or with --all every model reachable
+// through the network.
+func runList(args []string) error {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	remote := addClientFlags(fs)
+	all := fs.Bool("all", false, "include models served by peers")
+	fs.Parse(args)
+
+	ctx, cancel := signalContext()
+	defer cancel()
+
+	var resp struct {
+		Models []struct {
+			inference.Model
+			Local *bool    `json:"local"`
+			Peers []string `json:"peers"`
+		} `json:"models"`
+	}
+	path := "/api/tags"
+	if *all {
+		path = "/api/models"
+	}
+	if err := remote.client().do(ctx, "GET", path, nil, &resp); err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	if *all {
+		fmt.Fprintln(w, "NAME\tSIZE\tLOCAL\tPEERS")
+	} else {
+		fmt.Fprintln(w, "NAME\tID\tSIZE\tMODIFIED")
+	}
+	for _, m := range resp.Models {
+		if *all {
+			fmt.Fprintf(w, "%s\t%s\t%v\t%d\n", m.Name, formatSize(m.Size), m.Local != nil && *m.Local, len(m.Peers))
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", m.Name, shortDigest(m.Digest), formatSize(m.Size), formatAge(m.Modified))
+	}
+	return w.Flush()
+}
+
+// runPull downloads a model on the node, printing its progress.
+func runPull(args []string) error {
+	fs := flag.NewFlagSet("pull", flag.ExitOnError)
+	remote := addClientFlags(fs)
+	insecure := fs.Bool("insecure", false, "allow insecure connections to the registry")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return usageError("pull needs exactly one model")
+	}
+
+	ctx, cancel := signalContext()
+	defer cancel()
+
+	req := inference.PullRequest{Model: fs.Arg(0), Insecure: *insecure, Stream: true}
+	var last string
+	err := remote.client().stream(ctx, "POST", "/api/pull", &req, func(raw json.RawMessage) error {
+		var p inference.ProgressResponse
+		if err := json.Unmarshal(raw, &p); err != nil {
+			return fmt.Errorf("failed to decode progress: %w", err)
+		}
+		line := p.Status
+		if p.Total > 0 {
+			line = fmt.Sprintf("%s %3d%% (%s/%s)", p.Status, p.Completed*100/p.Total, formatSize(p.Completed), formatSize(p.Total))
+		}
+		if line != last {
+			fmt.Printf("\r\033[K%s", line)
+			if p.Total == 0 || p.Completed >= p.Total {
+				fmt.Println()
+			}
+			last = line
+		}
+		return nil
+	})
+	if err != nil {
+		fmt.Println()
+	}
+	return err
+}
+
+// runRemove deletes models from the node.
+func runRemove(args []string) error {
+	fs := flag.NewFlagSet("rm", flag.ExitOnError)
+	remote := addClientFlags(fs)
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		return usageError("rm needs at least one model")
+	}
+
+	ctx, cancel := signalContext()
+	defer cancel()
+
+	client := remote.client()
+	for _, name := range fs.Args() {
+		if err := client.do(ctx, "DELETE", "/api/delete", &inference.DeleteRequest{Model: name}, nil); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		fmt.Printf("deleted '%s'\n", name)
+	}
+	return nil
+}
+
+// runPeers lists the connected nodes and the models they serve.
+func runPeers(args []string) error {
+	fs := flag.NewFlagSet("peers", flag.ExitOnError)
+	remote := addClientFlags(fs)
+	fs.Parse(args)
+
+	ctx, cancel := signalContext()
+	defer cancel()
+
+	var resp struct {
+		Peers []struct {
+			ID     string   `json:"id"`
+			Models []string `json:"models"`
+		} `json:"peers"`
+	}
+	if err := remote.client().do(ctx, "GET", "/api/peers", nil, &resp); err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "PEER\tMODELS")
+	for _, p := range resp.Peers {
+		fmt.Fprintf(w, "%s\t%s\n", p.ID, strings.Join(p.Models, ", "))
+	}
+	return w.Flush()
+}
+
+func formatSize(n int64) string {
+	const unit = 1000
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for v := n / unit; v >= unit; v /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+func formatAge(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	d := time.Since(t)
+	switch {
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		return fmt.Sprintf("%d minutes ago", int(d.Minutes()))
+	case d < 48*time.Hour:
+		return fmt.Sprintf("%d hours ago", int(d.Hours()))
+	}
+	return fmt.Sprintf("%d days ago", int(d.Hours()/24))
+}
+
+func shortDigest(digest string) string {
+	digest = strings.TrimPrefix(digest, "sha256:")
+	if len(digest) > 12 {
+		return digest[:12]
+	}
+	return digest
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/khryptorgraphics/ollama-nova/api"
+	"github.com/khryptorgraphics/ollama-nova/internal/config"
+	"github.com/khryptorgraphics/ollama-nova/internal/inference"
+	"github.com/khryptorgraphics/ollama-nova/internal/monitoring"
+	"github.com/khryptorgraphics/ollama-nova/internal/p2p"
+	"github.com/khryptorgraphics/ollama-nova/internal/routing"
+	"github.com/khryptorgraphics/ollama-nova/internal/security"
+)
+
+// runServe starts the node and blocks until it receives SIGINT or SIGTERM.
+func runServe(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	configPath := fs.String("config", defaultConfigPath, "path to the configuration file")
+	listen := fs.String("listen", "", "API listen address, overriding api.listen")
+	fs.Parse(args)
+	if fs.NArg() > 0 {
+		return usageError("serve takes no arguments")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Load configuration
+	cfg, err := config.LoadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	reloader := config.NewReloader(*configPath, cfg)
+	if *listen != "" {
+		applyListen := func(cfg *config.Config) { cfg.API.Listen = *listen }
+		applyListen(cfg)
+		if err := cfg.Validate(); err != nil {
+			return err
+		}
+		reloader.Override(applyListen)
+	}
+	if err := monitoring.SetupLogging(cfg.Monitoring.LogLevel); err != nil {
+		return err
+	}
+
+	// Initialize components
+	monitor := monitoring.NewMonitor()
+	engine := inference.NewEngine()
+	engine.SetConfig(cfg.Inference.Config)
+	engine.SetMetrics(monitor)
+	go engine.RunHealthChecks(ctx)
+	monitor.AddHealthCheck("ollama", engine.CheckBackends, 30*time.Second, 5*time.Second)
+
+	securityManager := security.NewManager()
+	if err := securityManager.SetConfig(cfg.Security); err != nil {
+		return fmt.Errorf("security initialization failed: %w", err)
+	}
+	tlsConfig, err := securityManager.CreateTLSConfig()
+	if err != nil {
+		return fmt.Errorf("TLS initialization failed: %w", err)
+	}
+	if tlsConfig != nil {
+		securityManager.SetMetrics(monitor)
+		go securityManager.RunCertRotation(ctx)
+		monitor.AddHealthCheck("tls_certificate", securityManager.CheckCertExpiry, time.Minute, 5*time.Second)
+	}
+
+	// Start P2P node, admitting only the peers the configuration allows
+	gater, err := p2p.NewGater(cfg.P2P, securityManager, securityManager)
+	if err != nil {
+		return fmt.Errorf("P2P initialization failed: %w", err)
+	}
+	p2pNode, dht, err := p2p.NewP2PNode(ctx, cfg.P2P, gater)
+	if err != nil {
+		return fmt.Errorf("P2P initialization failed: %w", err)
+	}
+	defer p2pNode.Close()
+	defer dht.Close()
+
+	// Share our model list with peers and collect theirs
+	p2p.ServeModels(p2pNode, engine)
+
+	// Execute inference requests from peers and allow forwarding to them
+	inferenceService := p2p.ServeInference(p2pNode, engine)
+	inferenceClient := p2p.NewInferenceClient(p2pNode)
+	inferenceClient.SetMetrics(monitor)
+	engine.SetRemote(inferenceClient)
+
+	// Advertise local models in the DHT
+	discovery := p2p.NewDiscovery(dht, engine, p2p.DefaultAdvertiseInterval)
+	engine.OnModelChange(discovery.ModelChanged)
+	go discovery.Run(ctx)
+
+	// Route generate, chat and embed requests between the local engine and
+	// peers
+	catalog := p2p.NewCatalog(p2pNode)
+	router, err := routing.NewPeerRouter(engine, cfg.Routing, catalog, discovery)
+	if err != nil {
+		return fmt.Errorf("router initialization failed: %w", err)
+	}
+
+	// Set up the API server, with TLS and API key, LDAP, OIDC and client
+	// certificate authentication when enabled
+	keys, err := security.OpenKeyStore(cfg.Security.Auth.KeyFile)
+	if err != nil {
+		return fmt.Errorf("API key store initialization failed: %w", err)
+	}
+	server := api.NewServer(engine)
+	server.SetPeerCatalog(catalog)
+	server.SetRouter(router)
+	server.SetKeyStore(keys)
+	server.SetTLSConfig(tlsConfig)
+	ldapAuth, err := security.NewLDAPAuthenticator(cfg.Security.Auth.LDAP)
+	if err != nil {
+		return fmt.Errorf("LDAP initialization failed: %w", err)
+	}
+	defer ldapAuth.Close()
+	jwtAuth := security.NewJWTValidator(cfg.Security.Auth.OIDC)
+	certAuth := security.NewCertAuthenticator(cfg.Security.Auth.ClientCerts)
+	applyAuth := func(auth security.AuthConfig) {
+		if !auth.Enabled {
+			server.SetAuthenticators()
+			return
+		}
+		auths := []security.Authenticator{keys}
+		if auth.LDAP.Enabled {
+			if err := ldapAuth.SetConfig(auth.LDAP); err != nil {
+				slog.Warn("LDAP settings not reloaded", "err", err)
+			}
+			auths = append(auths, ldapAuth)
+		}
+		if auth.OIDC.Enabled {
+			jwtAuth.SetConfig(auth.OIDC)
+			auths = append(auths, jwtAuth)
+		}
+		// Last, so that explicit credentials win over the certificate.
+		if auth.ClientCerts.Enabled {
+			certAuth.SetConfig(auth.ClientCerts)
+			auths = append(auths, certAuth)
+		}
+		server.SetAuthenticators(auths...)
+	}
+	applyAuth(cfg.Security.Auth)
+
+	// Apply configuration changes without a restart
+	reloader.Subscribe(func(cfg *config.Config) {
+		if err := monitoring.SetLogLevel(cfg.Monitoring.LogLevel); err != nil {
+			slog.Warn("Log level not reloaded", "err", err)
+		}
+		engine.SetConfig(cfg.Inference.Config)
+		if err := securityManager.SetConfig(cfg.Security); err != nil {
+			slog.Warn("Security settings not reloaded", "err", err)
+		}
+		if err := gater.SetConfig(cfg.P2P); err != nil {
+			slog.Warn("P2P peer settings not reloaded", "err", err)
+		}
+		applyAuth(cfg.Security.Auth)
+		if err := router.Configure(cfg.Routing); err != nil {
+			slog.Warn("Routing settings not reloaded", "err", err)
+		}
+	})
+	go reloader.Watch(ctx, config.DefaultWatchInterval)
+	go func() {
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hup:
+				if err := reloader.Reload(); err != nil {
+					slog.Error("Configuration reload failed, keeping the active configuration", "err", err)
+				}
+			}
+		}
+	}()
+
+	// Start API server
+	go func() {
+		if err := server.Start(cfg.API.Listen); err != nil {
+			log.Fatal("Server failed:", err)
+		}
+	}()
+
+	// Start monitoring
+	monitor.StartMetricsServer(cfg.Monitoring.MetricsPort)
+
+	if tlsConfig != nil {
+		log.Printf("Phase 1 MVP started on %s (TLS)", cfg.API.Listen)
+	} else {
+		log.Printf("Phase 1 MVP started on %s", cfg.API.Listen)
+	}
+
+	// Handle shutdown
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	<-sigChan
+	log.Println("Shutting down Phase 1 MVP...")
+
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), reloader.Current().API.ShutdownTimeout)
+	defer cancelShutdown()
+
+	// Stop taking new work and let in-flight requests, from clients and
+	// from peers, finish within the deadline
+	monitor.SetDraining()
+	peerDrained := make(chan error, 1)
+	go func() { peerDrained <- inferenceService.Shutdown(shutdownCtx) }()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		slog.Warn("API requests still running at the shutdown deadline were canceled", "err", err)
+	}
+	if err := <-peerDrained; err != nil {
+		slog.Warn("Peer requests still running at the shutdown deadline were canceled", "err", err)
+	}
+
+	// Stop announcing our models and stop the background loops
+	discovery.WithdrawAll()
+	cancel()
+
+	if err := monitor.Shutdown(shutdownCtx); err != nil {
+		slog.Warn("Metrics server shutdown failed", "err", err)
+	}
+
+	// The deferred DHT and host Close calls run last
+	log.Println("Shutdown complete")
+	return nil
+}
@@ -1,36 +1,121 @@
 package config
 
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/khryptorgraphics/ollama-nova/internal/inference"
+	"github.com/khryptorgraphics/ollama-nova/internal/p2p"
+	"github.com/khryptorgraphics/ollama-nova/internal/routing"
+	"github.com/khryptorgraphics/ollama-nova/internal/security"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the node configuration. Sections that belong to a single
+// component use that component's own Config type, so one file drives every
+// component without the settings being declared twice.
 type Config struct {
-P2P      P2PConfig      `yaml:"p2p"`
-Inference InferenceConfig `yaml:"inference"`
-Security SecurityConfig  `yaml:"security"`
-Monitoring MonitoringConfig `yaml:"monitoring"`
+	API        APIConfig        `yaml:"api"`
+	P2P        p2p.Config       `yaml:"p2p"`
+	Inference  InferenceConfig  `yaml:"inference"`
+	Security   security.Config  `yaml:"security"`
+	Monitoring MonitoringConfig `yaml:"monitoring"`
+	Routing    routing.Config   `yaml:"routing"`
 }
 
-type P2PConfig struct {
-Port        int      `yaml:"port"`
-Bootstrap   []string `yaml:"bootstrap"`
-MaxPeers    int      `yaml:"max_peers"`
+type APIConfig struct {
+	Listen string `yaml:"listen"`
+	// ShutdownTimeout bounds how long shutdown waits for in-flight
+	// requests, including streaming generations, before cutting them off.
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
 }
 
 type InferenceConfig struct {
-ModelPath   string `yaml:"model_path"`
-MaxTokens   int    `yaml:"max_tokens"`
-Temperature float64 `yaml:"temperature"`
+	ModelPath        string `yaml:"model_path"`
+	inference.Config `yaml:",inline"`
 }
 
-type SecurityConfig struct {
-TLS         bool   `yaml:"tls"`
-CertPath    string `yaml:"cert_path"`
-KeyPath     string `yaml:"key_path"`
+type MonitoringConfig struct {
+	MetricsPort int `yaml:"metrics_port"`
+	// LogLevel is the lowest level logged: debug, info, warn or error. A
+	// change takes effect on reload.
+	LogLevel string `yaml:"log_level"`
 }
 
-type MonitoringConfig struct {
-MetricsPort int    `yaml:"metrics_port"`
-LogLevel    string `yaml:"log_level"`
+// Default returns the configuration used for every setting that neither the
+// file nor the environment provides.
+func Default() *Config {
+	return &Config{
+		API: APIConfig{
+			Listen:          ":8080",
+			ShutdownTimeout: 30 * time.Second,
+		},
+		P2P: p2p.DefaultConfig(),
+		Inference: InferenceConfig{
+			Config: inference.DefaultConfig(),
+		},
+		Security: security.DefaultConfig(),
+		Monitoring: MonitoringConfig{
+			MetricsPort: 9090,
+			LogLevel:    "info",
+		},
+		Routing: routing.DefaultConfig(),
+	}
 }
 
+// LoadConfig reads the YAML file at path on top of the defaults, applies
+// NOVA_* environment overrides and validates the result. An empty path skips
+// the file. Validation problems are returned together as ValidationErrors.
 func LoadConfig(path string) (*Config, error) {
-// Implementation
-return &Config{}, nil
+	cfg := Default()
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config: %w", err)
+		}
+		if err := decode(data, cfg); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+	}
+
+	problems := applyEnv(cfg, os.LookupEnv)
+	problems = append(problems, cfg.validate()...)
+	if len(problems) > 0 {
+		return nil, problems
+	}
+	return cfg, nil
+}
+
+// decode unmarshals a YAML document into cfg, rejecting unknown keys so
+// that typos do not silently fall back to defaults.
+func decode(data []byte, cfg *Config) error {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	return nil
+}
+
+// redactedValue replaces secrets in Redacted.
+const redactedValue = "<redacted>"
+
+// Redacted returns a copy of c with secrets replaced, fit for printing.
+func (c *Config) Redacted() *Config {
+	out := *c
+	out.Inference.Backends = append([]inference.BackendConfig(nil), c.Inference.Backends...)
+	for i := range out.Inference.Backends {
+		if out.Inference.Backends[i].APIKey != "" {
+			out.Inference.Backends[i].APIKey = redactedValue
+		}
+	}
+	if out.Security.Auth.LDAP.BindPassword != "" {
+		out.Security.Auth.LDAP.BindPassword = redactedValue
+	}
+	return &out
 }
@@ -0,0 +1,250 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/khryptorgraphics/ollama-nova/internal/inference"
+	"github.com/khryptorgraphics/ollama-nova/internal/security"
+)
+
+// writeConfig writes a YAML file into a temporary directory and returns
+// its path.
+func writeConfig(t *testing.T, yaml string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "nova.yaml")
+	if err := os.WriteFile(path, []byte(yaml), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// fields returns the settings named in err, which must be
+// ValidationErrors.
+func fields(t *testing.T, err error) []string {
+	t.Helper()
+	var problems ValidationErrors
+	if !errors.As(err, &problems) {
+		t.Fatalf("err = %v, want ValidationErrors", err)
+	}
+	var names []string
+	for _, p := range problems {
+		names = append(names, p.Field)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestLoadConfig(t *testing.T) {
+	tests := []struct {
+		name  string
+		yaml  string // no file if empty
+		env   map[string]string
+		check func(t *testing.T, cfg *Config)
+		// wantFields lists the settings reported invalid; wantErr a
+		// substring of any other error.
+		wantFields []string
+		wantErr    string
+	}{
+		{
+			name: "defaults",
+			check: func(t *testing.T, cfg *Config) {
+				if !reflect.DeepEqual(cfg, Default()) {
+					t.Errorf("config = %+v, want the defaults", cfg)
+				}
+				if cfg.P2P.Port != 4001 {
+					t.Errorf("p2p.port = %d, want 4001", cfg.P2P.Port)
+				}
+				if cfg.API.Listen != ":8080" || cfg.Monitoring.LogLevel != "info" {
+					t.Errorf("api.listen = %q, log_level = %q", cfg.API.Listen, cfg.Monitoring.LogLevel)
+				}
+			},
+		},
+		{
+			name: "file overrides defaults",
+			yaml: "p2p:\n  port: 5001\nrouting:\n  policy: least-loaded\n",
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.P2P.Port != 5001 || cfg.Routing.Policy != "least-loaded" {
+					t.Errorf("p2p.port = %d, routing.policy = %q", cfg.P2P.Port, cfg.Routing.Policy)
+				}
+				if cfg.P2P.MaxPeers != 50 {
+					t.Errorf("p2p.max_peers = %d, want the default 50", cfg.P2P.MaxPeers)
+				}
+			},
+		},
+		{
+			name: "environment overrides file",
+			yaml: "p2p:\n  port: 5001\n",
+			env: map[string]string{
+				"NOVA_P2P_PORT":                "6001",
+				"NOVA_ROUTING_ATTEMPT_TIMEOUT": "5s",
+				"NOVA_SECURITY_CRL_PATHS":      "a.pem, ,b.pem",
+				"NOVA_INFERENCE_TEMPERATURE":   "0.5",
+				"NOVA_INFERENCE_OLLAMA_URL":    "http://gpu:11434",
+			},
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.P2P.Port != 6001 {
+					t.Errorf("p2p.port = %d, want 6001", cfg.P2P.Port)
+				}
+				if cfg.Routing.AttemptTimeout != 5*time.Second {
+					t.Errorf("routing.attempt_timeout = %s, want 5s", cfg.Routing.AttemptTimeout)
+				}
+				if want := []string{"a.pem", "b.pem"}; !reflect.DeepEqual(cfg.Security.CRLPaths, want) {
+					t.Errorf("security.crl_paths = %v, want %v", cfg.Security.CRLPaths, want)
+				}
+				if cfg.Inference.Temperature == nil || *cfg.Inference.Temperature != 0.5 {
+					t.Errorf("inference.temperature = %v, want 0.5", cfg.Inference.Temperature)
+				}
+				if cfg.Inference.OllamaURL != "http://gpu:11434" {
+					t.Errorf("inference.ollama_url = %q", cfg.Inference.OllamaURL)
+				}
+			},
+		},
+		{
+			name:    "unknown key",
+			yaml:    "p2p:\n  prot: 5001\n",
+			wantErr: "field prot not found",
+		},
+		{
+			name:    "malformed file",
+			yaml:    "p2p: [",
+			wantErr: "failed to parse",
+		},
+		{
+			name:       "unparsable environment values",
+			env:        map[string]string{"NOVA_P2P_PORT": "high", "NOVA_API_SHUTDOWN_TIMEOUT": "soon", "NOVA_SECURITY_TLS": "maybe"},
+			wantFields: []string{"api.shutdown_timeout", "p2p.port", "security.tls"},
+		},
+		{
+			name:       "invalid values",
+			yaml:       "p2p:\n  port: 70000\nmonitoring:\n  log_level: verbose\nrouting:\n  policy: fastest\n",
+			wantFields: []string{"monitoring.log_level", "p2p.port", "routing.policy"},
+		},
+		{
+			name:       "environment values are validated",
+			env:        map[string]string{"NOVA_ROUTING_MAX_ATTEMPTS": "-1"},
+			wantFields: []string{"routing.max_attempts"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+			path := ""
+			if tt.yaml != "" {
+				path = writeConfig(t, tt.yaml)
+			}
+
+			cfg, err := LoadConfig(path)
+			switch {
+			case tt.wantFields != nil:
+				if got := fields(t, err); !reflect.DeepEqual(got, tt.wantFields) {
+					t.Errorf("invalid settings %v, want %v", got, tt.wantFields)
+				}
+			case tt.wantErr != "":
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("err = %v, want one containing %q", err, tt.wantErr)
+				}
+			case err != nil:
+				t.Fatal(err)
+			default:
+				tt.check(t, cfg)
+			}
+		})
+	}
+}
+
+func TestLoadConfigMissingFile(t *testing.T) {
+	_, err := LoadConfig(filepath.Join(t.TempDir(), "missing.yaml"))
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("err = %v, want a missing file", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(cfg *Config)
+		want   []string
+	}{
+		{"defaults", func(cfg *Config) {}, nil},
+		{"random p2p port", func(cfg *Config) { cfg.P2P.Port = 0 }, nil},
+		{"negative p2p port", func(cfg *Config) { cfg.P2P.Port = -1 }, []string{"p2p.port"}},
+		{"zero metrics port", func(cfg *Config) { cfg.Monitoring.MetricsPort = 0 }, []string{"monitoring.metrics_port"}},
+		{"listen without port", func(cfg *Config) { cfg.API.Listen = "localhost" }, []string{"api.listen"}},
+		{"bad bootstrap address", func(cfg *Config) { cfg.P2P.Bootstrap = []string{"/ip4/10.0.0.1/tcp/4001"} }, []string{"p2p.bootstrap[0]"}},
+		{"bad peer ID", func(cfg *Config) { cfg.P2P.DenyPeers = []string{"nope"} }, []string{"p2p.deny_peers[0]"}},
+		{"log level", func(cfg *Config) { cfg.Monitoring.LogLevel = "trace" }, []string{"monitoring.log_level"}},
+		{"top_p out of range", func(cfg *Config) {
+			topP := 1.5
+			cfg.Inference.TopP = &topP
+		}, []string{"inference.top_p"}},
+		{"ollama URL", func(cfg *Config) { cfg.Inference.OllamaURL = "gpu:11434" }, []string{"inference.ollama_url"}},
+		{"backends replace ollama URL", func(cfg *Config) {
+			cfg.Inference.OllamaURL = ""
+			cfg.Inference.Backends = []inference.BackendConfig{{Name: "gpu", URL: "http://gpu:11434"}}
+		}, nil},
+		{"duplicate backends", func(cfg *Config) {
+			cfg.Inference.Backends = []inference.BackendConfig{
+				{Name: "gpu", URL: "http://gpu-0:11434"},
+				{Name: "gpu", URL: "ftp://gpu-1"},
+			}
+		}, []string{"inference.backends[1].name", "inference.backends[1].url"}},
+		{"negative model limit", func(cfg *Config) {
+			cfg.Inference.Queue.ModelLimits = map[string]int{"llama3": -1}
+		}, []string{"inference.queue.model_limits.llama3"}},
+		{"tls without files", func(cfg *Config) {
+			cfg.Security.TLSEnabled = true
+			cfg.Security.CertPath, cfg.Security.KeyPath = "", ""
+		}, []string{"security.cert_path", "security.key_path"}},
+		{"client auth without tls", func(cfg *Config) {
+			cfg.Security.ClientAuth = security.ClientAuthRequire
+			cfg.Security.CAPath = "ca.pem"
+		}, []string{"security.client_auth"}},
+		{"certificates without tls", func(cfg *Config) {
+			cfg.P2P.RequireCertificates = true
+			cfg.Security.CAPath = ""
+		}, []string{"p2p.key_file", "p2p.require_certificates", "security.ca_path"}},
+		{"auth without key file", func(cfg *Config) {
+			cfg.Security.Auth.Enabled = true
+			cfg.Security.Auth.KeyFile = ""
+		}, []string{"security.auth.key_file"}},
+		{"negative routing timeout", func(cfg *Config) { cfg.Routing.AttemptTimeout = -time.Second }, []string{"routing.attempt_timeout"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Default()
+			tt.modify(cfg)
+			err := cfg.Validate()
+			if tt.want == nil {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if got := fields(t, err); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("invalid settings %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRestartRequired(t *testing.T) {
+	prev := Default()
+	next := Default()
+	next.P2P.Port = 5001
+	next.Routing.Policy = "round-robin"
+	next.Monitoring.MetricsPort = 9191
+
+	want := []string{"p2p.port", "monitoring.metrics_port"}
+	if got := RestartRequired(prev, next); !reflect.DeepEqual(got, want) {
+		t.Errorf("RestartRequired = %v, want %v", got, want)
+	}
+}
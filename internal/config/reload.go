@@ -0,0 +1,174 @@
+package config
+
+import (
+	"context"
+	"log"
+	"log/slog"
+	"os"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// restartOnly lists the settings that are only read at startup. Changing
+// them in a reload is reported instead of applied.
+var restartOnly = []string{
+	"api.listen",
+	"p2p.port",
+	"p2p.bootstrap",
+	"p2p.max_peers",
+	"p2p.key_file",
+	"p2p.swarm_key_file",
+	"inference.model_path",
+	"security.tls",
+	"security.auth.key_file",
+	"monitoring.metrics_port",
+}
+
+// DefaultWatchInterval is how often Watch checks the file for changes.
+const DefaultWatchInterval = 5 * time.Second
+
+// Reloader holds the active configuration and replaces it when the file is
+// reloaded. A reload that fails to load or validate leaves the active
+// configuration untouched.
+type Reloader struct {
+	path    string
+	current atomic.Pointer[Config]
+
+	// mu serializes reloads so subscribers see configurations in order.
+	mu          sync.Mutex
+	subscribers []func(*Config)
+	overrides   []func(*Config)
+}
+
+func NewReloader(path string, cfg *Config) *Reloader {
+	r := &Reloader{path: path}
+	r.current.Store(cfg)
+	return r
+}
+
+// Current returns the active configuration. Callers must not modify it.
+func (r *Reloader) Current() *Config {
+	return r.current.Load()
+}
+
+// Subscribe registers fn to be called with every configuration that
+// replaces the active one. Subscribers run synchronously, in registration
+// order.
+func (r *Reloader) Subscribe(fn func(*Config)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.subscribers = append(r.subscribers, fn)
+}
+
+// Override registers fn to be applied to every reloaded configuration
+// before it is validated, so settings given on the command line keep
+// precedence over the file.
+func (r *Reloader) Override(fn func(*Config)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.overrides = append(r.overrides, fn)
+}
+
+// Reload reads and validates the file again, swaps the new configuration in
+// and pushes it to the subscribers. Changes to restart-only settings are
+// logged, since they only take effect after a restart.
+func (r *Reloader) Reload() error {
+	next, err := LoadConfig(r.path)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.overrides) > 0 {
+		for _, fn := range r.overrides {
+			fn(next)
+		}
+		if err := next.Validate(); err != nil {
+			return err
+		}
+	}
+
+	prev := r.current.Load()
+	if pending := RestartRequired(prev, next); len(pending) > 0 {
+		slog.Warn("Configuration changes need a restart to take effect", "settings", pending)
+	}
+	r.current.Store(next)
+
+	for _, fn := range r.subscribers {
+		fn(next)
+	}
+	log.Printf("Configuration reloaded from %s", r.path)
+	return nil
+}
+
+// Watch reloads the configuration whenever the file's modification time or
+// size changes, checking every interval until ctx is canceled. A change is
+// only picked up once the file looks the same on two consecutive checks, so
+// a file that is still being written is not loaded half-way.
+func (r *Reloader) Watch(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultWatchInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	loaded, _ := os.Stat(r.path)
+	pending := loaded
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		info, err := os.Stat(r.path)
+		if err != nil {
+			// Editors that replace the file may leave it missing briefly.
+			continue
+		}
+		settled := sameFile(info, pending)
+		pending = info
+		if !settled || sameFile(info, loaded) {
+			continue
+		}
+		loaded = info
+
+		if err := r.Reload(); err != nil {
+			slog.Error("Configuration reload failed, keeping the active configuration", "err", err)
+		}
+	}
+}
+
+func sameFile(a, b os.FileInfo) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.ModTime().Equal(b.ModTime()) && a.Size() == b.Size()
+}
+
+// RestartRequired returns the paths of restart-only settings that differ
+// between prev and next.
+func RestartRequired(prev, next *Config) []string {
+	before := fieldValues(prev)
+	after := fieldValues(next)
+
+	var changed []string
+	for _, path := range restartOnly {
+		if !reflect.DeepEqual(before[path], after[path]) {
+			changed = append(changed, path)
+		}
+	}
+	return changed
+}
+
+func fieldValues(cfg *Config) map[string]interface{} {
+	values := make(map[string]interface{})
+	walkFields(reflect.ValueOf(cfg).Elem(), "", func(path string, v reflect.Value) {
+		values[path] = v.Interface()
+	})
+	return values
+}
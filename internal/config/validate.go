@@ -0,0 +1,344 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/khryptorgraphics/ollama-nova/internal/inference"
+	"github.com/khryptorgraphics/ollama-nova/internal/monitoring"
+	"github.com/khryptorgraphics/ollama-nova/internal/routing"
+	"github.com/khryptorgraphics/ollama-nova/internal/security"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// FieldError is a problem with a single setting, identified by its YAML
+// path such as "p2p.bootstrap[0]".
+type FieldError struct {
+	Field   string
+	Message string
+}
+
+func (e FieldError) Error() string {
+	return e.Field + ": " + e.Message
+}
+
+// ValidationErrors collects every problem found in a configuration so they
+// can be fixed in one go.
+type ValidationErrors []FieldError
+
+func (v ValidationErrors) Error() string {
+	lines := make([]string, len(v))
+	for i, e := range v {
+		lines[i] = e.Error()
+	}
+	return "invalid configuration:\n  " + strings.Join(lines, "\n  ")
+}
+
+func (v *ValidationErrors) add(field, format string, args ...interface{}) {
+	*v = append(*v, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// Validate checks the configuration and returns ValidationErrors listing
+// every problem, or nil.
+func (c *Config) Validate() error {
+	if problems := c.validate(); len(problems) > 0 {
+		return problems
+	}
+	return nil
+}
+
+func (c *Config) validate() ValidationErrors {
+	var v ValidationErrors
+
+	if _, _, err := net.SplitHostPort(c.API.Listen); err != nil {
+		v.add("api.listen", "must be host:port, got %q", c.API.Listen)
+	}
+	if c.API.ShutdownTimeout < 0 {
+		v.add("api.shutdown_timeout", "must not be negative")
+	}
+
+	checkPort(&v, "p2p.port", c.P2P.Port, true)
+	for i, addr := range c.P2P.Bootstrap {
+		if _, err := peer.AddrInfoFromString(addr); err != nil {
+			v.add(fmt.Sprintf("p2p.bootstrap[%d]", i), "invalid peer address %q: %v", addr, err)
+		}
+	}
+	if c.P2P.MaxPeers < 0 {
+		v.add("p2p.max_peers", "must not be negative")
+	}
+	for i, id := range c.P2P.AllowPeers {
+		if _, err := peer.Decode(id); err != nil {
+			v.add(fmt.Sprintf("p2p.allow_peers[%d]", i), "invalid peer ID %q: %v", id, err)
+		}
+	}
+	for i, id := range c.P2P.DenyPeers {
+		if _, err := peer.Decode(id); err != nil {
+			v.add(fmt.Sprintf("p2p.deny_peers[%d]", i), "invalid peer ID %q: %v", id, err)
+		}
+	}
+	if c.P2P.RequireCertificates {
+		if !c.Security.TLSEnabled {
+			v.add("p2p.require_certificates", "needs security.tls to be enabled, for the node's own certificate")
+		}
+		if c.Security.CAPath == "" {
+			v.add("security.ca_path", "is required when p2p.require_certificates is on")
+		}
+		if c.P2P.KeyFile == "" {
+			v.add("p2p.key_file", "is required when require_certificates is on, since certificates are bound to the peer ID")
+		}
+	}
+
+	c.validateInference(&v)
+
+	if c.Security.TLSEnabled {
+		if c.Security.CertPath == "" {
+			v.add("security.cert_path", "is required when tls is enabled")
+		}
+		if c.Security.KeyPath == "" {
+			v.add("security.key_path", "is required when tls is enabled")
+		}
+	}
+	for i, path := range c.Security.CRLPaths {
+		if path == "" {
+			v.add(fmt.Sprintf("security.crl_paths[%d]", i), "must not be empty")
+		}
+	}
+	if c.Security.Rotation.RenewBefore < 0 {
+		v.add("security.rotation.renew_before", "must not be negative")
+	}
+	if c.Security.Rotation.WarnBefore < 0 {
+		v.add("security.rotation.warn_before", "must not be negative")
+	}
+	if c.Security.Rotation.CheckInterval < 0 {
+		v.add("security.rotation.check_interval", "must not be negative")
+	}
+	switch c.Security.ClientAuth {
+	case security.ClientAuthNone:
+	case security.ClientAuthOptional, security.ClientAuthRequire:
+		if !c.Security.TLSEnabled {
+			v.add("security.client_auth", "needs tls to be enabled")
+		}
+		if c.Security.CAPath == "" {
+			v.add("security.ca_path", "is required when client_auth is %s", c.Security.ClientAuth)
+		}
+	default:
+		v.add("security.client_auth", "must be one of none, optional, require, got %q", c.Security.ClientAuth)
+	}
+	if c.Security.Auth.ClientCerts.Enabled && c.Security.ClientAuth == security.ClientAuthNone {
+		v.add("security.auth.client_certs.enabled", "needs client_auth to be optional or require")
+	}
+	for subject, scopes := range c.Security.Auth.ClientCerts.Roles {
+		for _, scope := range scopes {
+			if _, err := security.ParseScope(string(scope)); err != nil {
+				v.add(fmt.Sprintf("security.auth.client_certs.roles[%q]", subject), "%v", err)
+			}
+		}
+	}
+	if c.Security.Auth.Enabled && c.Security.Auth.KeyFile == "" {
+		v.add("security.auth.key_file", "is required when auth is enabled")
+	}
+	if c.Security.Auth.LDAP.Enabled {
+		c.validateLDAP(&v)
+	}
+	if c.Security.Auth.OIDC.Enabled {
+		c.validateOIDC(&v)
+	}
+
+	checkPort(&v, "monitoring.metrics_port", c.Monitoring.MetricsPort, false)
+	if _, err := monitoring.ParseLogLevel(c.Monitoring.LogLevel); err != nil {
+		v.add("monitoring.log_level", "%v", err)
+	}
+
+	if _, err := routing.NewPolicy(c.Routing.Policy); err != nil {
+		v.add("routing.policy", "%v", err)
+	}
+	if c.Routing.AttemptTimeout < 0 {
+		v.add("routing.attempt_timeout", "must not be negative")
+	}
+	if c.Routing.MaxAttempts < 0 {
+		v.add("routing.max_attempts", "must not be negative")
+	}
+
+	return v
+}
+
+func (c *Config) validateInference(v *ValidationErrors) {
+	in := c.Inference.Config
+
+	// With backends configured, ollama_url is unused and may be left empty.
+	if len(in.Backends) == 0 || in.OllamaURL != "" {
+		if !isHTTPURL(in.OllamaURL) {
+			v.add("inference.ollama_url", "must be an http or https URL, got %q", in.OllamaURL)
+		}
+	}
+	if in.MaxTokens != nil && *in.MaxTokens < 0 {
+		v.add("inference.max_tokens", "must not be negative")
+	}
+	if in.Temperature != nil && *in.Temperature < 0 {
+		v.add("inference.temperature", "must not be negative")
+	}
+	if in.TopP != nil && (*in.TopP < 0 || *in.TopP > 1) {
+		v.add("inference.top_p", "must be between 0 and 1")
+	}
+	if in.Timeout < 0 {
+		v.add("inference.timeout", "must not be negative")
+	}
+	if in.EmbedBatchSize < 0 {
+		v.add("inference.embed_batch_size", "must not be negative")
+	}
+	if in.EmbedConcurrency < 0 {
+		v.add("inference.embed_concurrency", "must not be negative")
+	}
+
+	q := in.Queue
+	if q.MaxConcurrent < 0 {
+		v.add("inference.queue.max_concurrent", "must not be negative")
+	}
+	if q.MaxPerModel < 0 {
+		v.add("inference.queue.max_per_model", "must not be negative")
+	}
+	if q.MaxQueued < 0 {
+		v.add("inference.queue.max_queued", "must not be negative")
+	}
+	if q.RetryAfter < 0 {
+		v.add("inference.queue.retry_after", "must not be negative")
+	}
+	limited := make([]string, 0, len(q.ModelLimits))
+	for model := range q.ModelLimits {
+		limited = append(limited, model)
+	}
+	sort.Strings(limited)
+	for _, model := range limited {
+		if q.ModelLimits[model] < 0 {
+			v.add("inference.queue.model_limits."+model, "must not be negative")
+		}
+	}
+
+	names := make(map[string]bool, len(in.Backends))
+	for i, b := range in.Backends {
+		field := fmt.Sprintf("inference.backends[%d]", i)
+		if !isHTTPURL(b.URL) {
+			v.add(field+".url", "must be an http or https URL, got %q", b.URL)
+		}
+		if types := inference.BackendTypes(); b.Type != "" && !slices.Contains(types, b.Type) {
+			v.add(field+".type", "must be one of %s, got %q", strings.Join(types, ", "), b.Type)
+		}
+		if b.Name != "" && names[b.Name] {
+			v.add(field+".name", "duplicate backend name %q", b.Name)
+		}
+		names[b.Name] = true
+	}
+	h := in.Health
+	if h.Interval < 0 {
+		v.add("inference.health.interval", "must not be negative")
+	}
+	if h.Timeout < 0 {
+		v.add("inference.health.timeout", "must not be negative")
+	}
+	if h.FailureThreshold < 0 {
+		v.add("inference.health.failure_threshold", "must not be negative")
+	}
+	if h.Cooldown < 0 {
+		v.add("inference.health.cooldown", "must not be negative")
+	}
+
+	if _, err := inference.ValidateOptions(in.Options); err != nil {
+		v.add("inference.options", "%v", err)
+	}
+	models := make([]string, 0, len(in.ModelOptions))
+	for model := range in.ModelOptions {
+		models = append(models, model)
+	}
+	sort.Strings(models)
+	for _, model := range models {
+		if _, err := inference.ValidateOptions(in.ModelOptions[model]); err != nil {
+			v.add("inference.model_options."+model, "%v", err)
+		}
+	}
+}
+
+func (c *Config) validateLDAP(v *ValidationErrors) {
+	ldap := &c.Security.Auth.LDAP
+	u, err := url.Parse(ldap.URL)
+	switch {
+	case err != nil || u.Host == "" || (u.Scheme != "ldap" && u.Scheme != "ldaps"):
+		v.add("security.auth.ldap.url", "must be an ldap:// or ldaps:// URL, got %q", ldap.URL)
+	case u.Scheme == "ldaps" && ldap.StartTLS:
+		v.add("security.auth.ldap.start_tls", "cannot be combined with an ldaps:// URL")
+	}
+	if ldap.BaseDN == "" {
+		v.add("security.auth.ldap.base_dn", "is required when ldap is enabled")
+	}
+	if !strings.Contains(ldap.UserFilter, "%s") {
+		v.add("security.auth.ldap.user_filter", "must contain %%s for the user name, got %q", ldap.UserFilter)
+	}
+	if ldap.GroupFilter != "" && !strings.Contains(ldap.GroupFilter, "%s") {
+		v.add("security.auth.ldap.group_filter", "must contain %%s for the user DN, got %q", ldap.GroupFilter)
+	}
+	if ldap.BindDN != "" && ldap.BindPassword == "" {
+		v.add("security.auth.ldap.bind_password", "is required with bind_dn")
+	}
+	for group, scopes := range ldap.Roles {
+		for _, scope := range scopes {
+			if _, err := security.ParseScope(string(scope)); err != nil {
+				v.add(fmt.Sprintf("security.auth.ldap.roles[%q]", group), "%v", err)
+			}
+		}
+	}
+	if ldap.PoolSize < 0 {
+		v.add("security.auth.ldap.pool_size", "must not be negative")
+	}
+	if ldap.CacheTTL < 0 {
+		v.add("security.auth.ldap.cache_ttl", "must not be negative")
+	}
+	if ldap.Timeout < 0 {
+		v.add("security.auth.ldap.timeout", "must not be negative")
+	}
+}
+
+func (c *Config) validateOIDC(v *ValidationErrors) {
+	oidc := &c.Security.Auth.OIDC
+	if !isHTTPURL(oidc.Issuer) {
+		v.add("security.auth.oidc.issuer", "must be an http(s) URL, got %q", oidc.Issuer)
+	}
+	if oidc.Audience == "" {
+		v.add("security.auth.oidc.audience", "is required when oidc is enabled")
+	}
+	if oidc.JWKSURL != "" && !isHTTPURL(oidc.JWKSURL) {
+		v.add("security.auth.oidc.jwks_url", "must be an http(s) URL, got %q", oidc.JWKSURL)
+	}
+	if oidc.UsernameClaim == "" {
+		v.add("security.auth.oidc.username_claim", "is required when oidc is enabled")
+	}
+	for value, scopes := range oidc.Roles {
+		for _, scope := range scopes {
+			if _, err := security.ParseScope(string(scope)); err != nil {
+				v.add(fmt.Sprintf("security.auth.oidc.roles[%q]", value), "%v", err)
+			}
+		}
+	}
+	if oidc.JWKSCacheTTL < 0 {
+		v.add("security.auth.oidc.jwks_cache_ttl", "must not be negative")
+	}
+	if oidc.ClockSkew < 0 {
+		v.add("security.auth.oidc.clock_skew", "must not be negative")
+	}
+	if oidc.Timeout < 0 {
+		v.add("security.auth.oidc.timeout", "must not be negative")
+	}
+}
+
+func isHTTPURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+func checkPort(v *ValidationErrors, field string, port int, allowZero bool) {
+	if port < 0 || port > 65535 || (port == 0 && !allowZero) {
+		v.add(field, "must be a valid TCP port, got %d", port)
+	}
+}
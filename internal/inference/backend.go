@@ -0,0 +1,255 @@
+package inference
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Backend is an inference server the engine forwards requests to. Requests
+// reach a backend with their options already resolved.
+//
+// Generate and Chat call fn once per streamed chunk when the request has
+// Stream set, and once with the complete response otherwise. Embed handles
+// a single batch; the engine splits large requests. Connection failures are
+// reported by wrapping ErrUnreachable, which makes the engine fail over to
+// another backend.
+type Backend interface {
+	Name() string
+	Capabilities() []Capability
+	Generate(ctx context.Context, req *Request, fn func(*Response) error) error
+	Chat(ctx context.Context, req *ChatRequest, fn func(*ChatResponse) error) error
+	Embed(ctx context.Context, req *EmbedRequest) (*EmbedResponse, error)
+	ListModels(ctx context.Context) ([]Model, error)
+	Pull(ctx context.Context, req *PullRequest, fn func(*ProgressResponse) error) error
+}
+
+// ModelManager is implemented by backends that can delete, copy, inspect and
+// create models.
+type ModelManager interface {
+	DeleteModel(ctx context.Context, name string) error
+	CopyModel(ctx context.Context, req *CopyRequest) error
+	ShowModel(ctx context.Context, req *ShowRequest) (*ShowResponse, error)
+	CreateModel(ctx context.Context, req *CreateRequest, fn func(*ProgressResponse) error) error
+}
+
+// RunningLister is implemented by backends that can tell which models are
+// currently loaded in memory. Requests prefer backends that have the model
+// loaded.
+type RunningLister interface {
+	RunningModels(ctx context.Context) ([]string, error)
+}
+
+// Capability is something a backend can do. The first group applies to the
+// models a backend serves and is advertised on them; the second describes
+// the backend itself.
+type Capability string
+
+const (
+	CapCompletion Capability = "completion"
+	CapEmbedding  Capability = "embedding"
+	CapTools      Capability = "tools"
+	CapVision     Capability = "vision"
+
+	CapPull Capability = "pull"
+)
+
+var modelCapabilities = map[Capability]bool{
+	CapCompletion: true,
+	CapEmbedding:  true,
+	CapTools:      true,
+	CapVision:     true,
+}
+
+// ErrUnsupported is wrapped by errors for requests that no configured
+// backend is able to serve, such as pulling a model through an
+// OpenAI-compatible upstream.
+var ErrUnsupported = errors.New("not supported by backend")
+
+// ErrUnreachable is wrapped by backend errors for requests that could not
+// be delivered at all, as opposed to being rejected. The engine retries
+// them on another backend.
+var ErrUnreachable = errors.New("backend unreachable")
+
+func hasCapability(b Backend, c Capability) bool {
+	for _, have := range b.Capabilities() {
+		if have == c {
+			return true
+		}
+	}
+	return false
+}
+
+// BackendConfig names one inference server fronted by the engine.
+type BackendConfig struct {
+	Name string `yaml:"name"`
+	// Type selects the adapter: "ollama" (the default), "llamacpp" for the
+	// llama.cpp server, or "openai" for OpenAI-compatible servers such as
+	// vLLM.
+	Type string `yaml:"type"`
+	URL  string `yaml:"url"`
+	// APIKey is sent as a bearer token to OpenAI-compatible upstreams.
+	APIKey string `yaml:"api_key"`
+}
+
+// BackendFactory creates a backend from its configuration. All backends of
+// an engine share client; request deadlines come from the context.
+type BackendFactory func(cfg BackendConfig, client *http.Client) (Backend, error)
+
+var (
+	factoriesMu sync.RWMutex
+	factories   = map[string]BackendFactory{
+		"ollama":   newOllamaBackend,
+		"llamacpp": newLlamaCppBackend,
+		"openai":   newOpenAIBackend,
+	}
+)
+
+// RegisterBackend makes a backend type available to the configuration. It
+// is meant to be called from init functions.
+func RegisterBackend(typ string, factory BackendFactory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+	factories[typ] = factory
+}
+
+// BackendTypes lists the registered backend types.
+func BackendTypes() []string {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+	types := make([]string, 0, len(factories))
+	for typ := range factories {
+		types = append(types, typ)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// NewBackend creates the backend described by cfg.
+func NewBackend(cfg BackendConfig, client *http.Client) (Backend, error) {
+	typ := cfg.Type
+	if typ == "" {
+		typ = "ollama"
+	}
+	factoriesMu.RLock()
+	factory, ok := factories[typ]
+	factoriesMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown backend type %q (want one of %s)", cfg.Type, strings.Join(BackendTypes(), ", "))
+	}
+	return factory(cfg, client)
+}
+
+// httpBackend holds what the HTTP-based adapters share.
+type httpBackend struct {
+	name   string
+	kind   string
+	url    string
+	apiKey string
+	client *http.Client
+}
+
+func newHTTPBackend(cfg BackendConfig, kind string, client *http.Client) httpBackend {
+	return httpBackend{
+		name:   cfg.Name,
+		kind:   kind,
+		url:    strings.TrimRight(cfg.URL, "/"),
+		apiKey: cfg.APIKey,
+		client: client,
+	}
+}
+
+func (h *httpBackend) Name() string { return h.name }
+
+// do sends body as JSON, or no body when it is nil. Non-200 responses are
+// turned into errors carrying the server's error message, if any; a 404
+// wraps ErrModelNotFound.
+func (h *httpBackend) do(ctx context.Context, method, path string, body interface{}) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		jsonData, err := json.Marshal(body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal request: %w", err)
+		}
+		reader = bytes.NewReader(jsonData)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, method, h.url+path, reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	if body != nil {
+		httpReq.Header.Set("Content-Type", "application/json")
+	}
+	if h.apiKey != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+h.apiKey)
+	}
+
+	resp, err := h.client.Do(httpReq)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("failed to send request: %w", err)
+		}
+		return nil, fmt.Errorf("%w: failed to send request to %s: %w", ErrUnreachable, h.name, err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		msg := resp.Status
+		if detail := errorMessage(resp.Body); detail != "" {
+			msg = resp.Status + ": " + detail
+		}
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, fmt.Errorf("%w: %s API error: %s", ErrModelNotFound, h.kind, msg)
+		}
+		return nil, fmt.Errorf("%s API error: %s", h.kind, msg)
+	}
+
+	return resp, nil
+}
+
+// call sends body like do and decodes the response into v, if non-nil.
+func (h *httpBackend) call(ctx context.Context, method, path string, body, v interface{}) error {
+	resp, err := h.do(ctx, method, path, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if v == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+// errorMessage extracts the message from an error body, accepting both
+// Ollama's {"error": "..."} and OpenAI's {"error": {"message": "..."}}.
+func errorMessage(r io.Reader) string {
+	var body struct {
+		Error json.RawMessage `json:"error"`
+	}
+	if json.NewDecoder(io.LimitReader(r, 1<<20)).Decode(&body) != nil || len(body.Error) == 0 {
+		return ""
+	}
+	var msg string
+	if json.Unmarshal(body.Error, &msg) == nil {
+		return msg
+	}
+	var obj struct {
+		Message string `json:"message"`
+	}
+	if json.Unmarshal(body.Error, &obj) == nil {
+		return obj.Message
+	}
+	return ""
+}
@@ -0,0 +1,149 @@
+package inference
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// ChatRequest mirrors the body of Ollama's POST /api/chat.
+type ChatRequest struct {
+	Model     string                 `json:"model"`
+	Messages  []Message              `json:"messages"`
+	Tools     []Tool                 `json:"tools,omitempty"`
+	Format    json.RawMessage        `json:"format,omitempty"`
+	Stream    bool                   `json:"stream"`
+	KeepAlive string                 `json:"keep_alive,omitempty"`
+	Options   map[string]interface{} `json:"options,omitempty"`
+}
+
+// Message is a single turn of a chat conversation. Role is one of
+// "system", "user", "assistant" or "tool"; Images holds base64 encoded
+// image data for multimodal models.
+type Message struct {
+	Role      string     `json:"role"`
+	Content   string     `json:"content"`
+	Images    []string   `json:"images,omitempty"`
+	ToolCalls []ToolCall `json:"tool_calls,omitempty"`
+}
+
+type ToolCall struct {
+	Function ToolCallFunction `json:"function"`
+}
+
+type ToolCallFunction struct {
+	Name      string                 `json:"name"`
+	Arguments map[string]interface{} `json:"arguments"`
+}
+
+// Tool describes a function the model may call. Parameters is a JSON
+// schema and is passed to Ollama untouched.
+type Tool struct {
+	Type     string       `json:"type"`
+	Function ToolFunction `json:"function"`
+}
+
+type ToolFunction struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Parameters  json.RawMessage `json:"parameters,omitempty"`
+}
+
+type ChatResponse struct {
+	Model              string        `json:"model"`
+	CreatedAt          time.Time     `json:"created_at"`
+	Message            Message       `json:"message"`
+	Done               bool          `json:"done"`
+	DoneReason         string        `json:"done_reason,omitempty"`
+	TotalDuration      time.Duration `json:"total_duration,omitempty"`
+	LoadDuration       time.Duration `json:"load_duration,omitempty"`
+	PromptEvalCount    int           `json:"prompt_eval_count,omitempty"`
+	PromptEvalDuration time.Duration `json:"prompt_eval_duration,omitempty"`
+	EvalCount          int           `json:"eval_count,omitempty"`
+	EvalDuration       time.Duration `json:"eval_duration,omitempty"`
+}
+
+// Chat runs a non-streaming chat completion and returns the final message.
+func (e *Engine) Chat(ctx context.Context, req *ChatRequest) (*ChatResponse, error) {
+	if peerID, ok := PeerFromContext(ctx); ok {
+		remote, err := e.remoteExecutor(peerID)
+		if err != nil {
+			return nil, err
+		}
+		return remote.Chat(ctx, peerID, req)
+	}
+
+	release, err := e.queue.acquire(ctx, req.Model)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	ctx, cancel := e.withTimeout(ctx)
+	defer cancel()
+
+	var response *ChatResponse
+	err = e.chat(ctx, req, false, func(r *ChatResponse) error {
+		response = r
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+// ChatStream runs a chat completion in streaming mode, calling fn for every
+// partial message as Ollama produces it.
+func (e *Engine) ChatStream(ctx context.Context, req *ChatRequest, fn func(*ChatResponse) error) error {
+	if peerID, ok := PeerFromContext(ctx); ok {
+		remote, err := e.remoteExecutor(peerID)
+		if err != nil {
+			return err
+		}
+		return remote.ChatStream(ctx, peerID, req, fn)
+	}
+
+	release, err := e.queue.acquire(ctx, req.Model)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	return e.chat(ctx, req, true, fn)
+}
+
+func (e *Engine) chat(ctx context.Context, req *ChatRequest, stream bool, fn func(*ChatResponse) error) error {
+	if len(req.Messages) == 0 {
+		return fmt.Errorf("%w: chat request has no messages", ErrInvalidRequest)
+	}
+
+	options, err := e.resolveOptions(req.Model, req.Options)
+	if err != nil {
+		return err
+	}
+
+	backendReq := *req
+	backendReq.Stream = stream
+	backendReq.Options = options
+
+	return e.pool.use(ctx, req.Model, supports(chatCapabilities(req)...), func(b Backend) error {
+		return b.Chat(ctx, &backendReq, fn)
+	})
+}
+
+// chatCapabilities lists what a backend needs to serve req.
+func chatCapabilities(req *ChatRequest) []Capability {
+	caps := []Capability{CapCompletion}
+	if len(req.Tools) > 0 {
+		caps = append(caps, CapTools)
+	}
+	for _, m := range req.Messages {
+		if len(m.Images) > 0 {
+			caps = append(caps, CapVision)
+			break
+		}
+	}
+	return caps
+}
@@ -0,0 +1,177 @@
+package inference
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// EmbedInput is a list of texts to embed. It unmarshals from either a
+// single string or an array of strings.
+type EmbedInput []string
+
+func (in *EmbedInput) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*in = EmbedInput{single}
+		return nil
+	}
+
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return fmt.Errorf("input must be a string or an array of strings")
+	}
+	*in = many
+	return nil
+}
+
+// EmbedRequest mirrors the body of Ollama's POST /api/embed.
+type EmbedRequest struct {
+	Model     string                 `json:"model"`
+	Input     EmbedInput             `json:"input"`
+	Truncate  *bool                  `json:"truncate,omitempty"`
+	KeepAlive string                 `json:"keep_alive,omitempty"`
+	Options   map[string]interface{} `json:"options,omitempty"`
+}
+
+type EmbedResponse struct {
+	Model           string        `json:"model"`
+	Embeddings      [][]float32   `json:"embeddings"`
+	TotalDuration   time.Duration `json:"total_duration,omitempty"`
+	LoadDuration    time.Duration `json:"load_duration,omitempty"`
+	PromptEvalCount int           `json:"prompt_eval_count,omitempty"`
+}
+
+// Embed computes one embedding per input, returned in input order. Inputs
+// beyond Config.EmbedBatchSize are split into batches that are sent to
+// the backends concurrently, at most Config.EmbedConcurrency at a time.
+func (e *Engine) Embed(ctx context.Context, req *EmbedRequest) (*EmbedResponse, error) {
+	if req.Model == "" {
+		return nil, fmt.Errorf("%w: model name is required", ErrInvalidRequest)
+	}
+	if peerID, ok := PeerFromContext(ctx); ok {
+		remote, err := e.remoteExecutor(peerID)
+		if err != nil {
+			return nil, err
+		}
+		return remote.Embed(ctx, peerID, req)
+	}
+
+	options, err := e.resolveOptions(req.Model, req.Options)
+	if err != nil {
+		return nil, err
+	}
+
+	release, err := e.queue.acquire(ctx, req.Model)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	e.mu.RLock()
+	batchSize := e.config.EmbedBatchSize
+	workers := e.config.EmbedConcurrency
+	e.mu.RUnlock()
+	if batchSize <= 0 {
+		batchSize = len(req.Input)
+	}
+	if workers <= 0 {
+		workers = 1
+	}
+
+	start := time.Now()
+	result := &EmbedResponse{
+		Model:      req.Model,
+		Embeddings: make([][]float32, len(req.Input)),
+	}
+	if len(req.Input) == 0 {
+		return result, nil
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+		sem      = make(chan struct{}, workers)
+	)
+
+	for offset := 0; offset < len(req.Input); offset += batchSize {
+		end := offset + batchSize
+		if end > len(req.Input) {
+			end = len(req.Input)
+		}
+
+		batch := *req
+		batch.Input = req.Input[offset:end]
+		batch.Options = options
+
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		wg.Add(1)
+		go func(offset int, batch EmbedRequest) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			resp, err := e.embedBatch(ctx, &batch)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+					cancel()
+				}
+				return
+			}
+			copy(result.Embeddings[offset:], resp.Embeddings)
+			result.PromptEvalCount += resp.PromptEvalCount
+			if resp.LoadDuration > result.LoadDuration {
+				result.LoadDuration = resp.LoadDuration
+			}
+		}(offset, batch)
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	result.TotalDuration = time.Since(start)
+	return result, nil
+}
+
+func (e *Engine) embedBatch(ctx context.Context, req *EmbedRequest) (*EmbedResponse, error) {
+	ctx, cancel := e.withTimeout(ctx)
+	defer cancel()
+
+	var response *EmbedResponse
+	err := e.pool.use(ctx, req.Model, supports(CapEmbedding), func(b Backend) error {
+		resp, err := b.Embed(ctx, req)
+		if err != nil {
+			return err
+		}
+		if len(resp.Embeddings) != len(req.Input) {
+			return fmt.Errorf("backend %s returned %d embeddings for %d inputs", b.Name(), len(resp.Embeddings), len(req.Input))
+		}
+		response = resp
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return response, nil
+}
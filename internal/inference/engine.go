@@ -1,176 +1,308 @@
 package inference
 
 import (
-"context"
-"encoding/json"
-"fmt"
-"io"
-"net/http"
-"sync"
-"time"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sync"
+	"time"
 )
 
 type Engine struct {
-mu      sync.RWMutex
-models  map[string]*Model
-config  *Config
-client  *http.Client
+	mu     sync.RWMutex
+	models map[string]*Model
+	config *Config
+
+	modelList modelCache
+	queue     *scheduler
+	pool      *pool
+	metrics   Metrics
+	remote    RemoteExecutor
+
+	modelHooks []func(ModelEvent)
+}
+
+// Metrics receives engine events. It is implemented by monitoring.Monitor.
+type Metrics interface {
+	RecordModelLoad(duration time.Duration)
+	// SetQueueDepth and RecordQueueWait report the request queue, per
+	// priority class.
+	SetQueueDepth(priority string, depth int)
+	RecordQueueWait(priority string, wait time.Duration)
 }
 
 type Model struct {
-Name        string    `json:"name"`
-Path        string    `json:"path"`
-Loaded      bool      `json:"loaded"`
-Size        int64     `json:"size"`
-Modified    time.Time `json:"modified"`
-Digest      string    `json:"digest"`
-Details     ModelDetails `json:"details"`
+	Name     string       `json:"name"`
+	Model    string       `json:"model,omitempty"`
+	Path     string       `json:"path,omitempty"`
+	Loaded   bool         `json:"loaded"`
+	Size     int64        `json:"size"`
+	Modified time.Time    `json:"modified_at"`
+	Digest   string       `json:"digest"`
+	Details  ModelDetails `json:"details"`
+	// Capabilities lists what the backends serving the model support.
+	Capabilities []Capability `json:"capabilities,omitempty"`
 }
 
 type ModelDetails struct {
-Format            string   `json:"format"`
-Family            string   `json:"family"`
-Families          []string `json:"families"`
-ParameterSize     string   `json:"parameter_size"`
-QuantizationLevel string   `json:"quantization_level"`
+	ParentModel       string   `json:"parent_model"`
+	Format            string   `json:"format"`
+	Family            string   `json:"family"`
+	Families          []string `json:"families"`
+	ParameterSize     string   `json:"parameter_size"`
+	QuantizationLevel string   `json:"quantization_level"`
 }
 
 type Config struct {
-OllamaURL   string  `yaml:"ollama_url"`
-MaxTokens   int     `yaml:"max_tokens"`
-Temperature float64 `yaml:"temperature"`
-TopP        float64 `yaml:"top_p"`
-Timeout     time.Duration `yaml:"timeout"`
+	// OllamaURL is the Ollama instance used when Backends is empty.
+	OllamaURL string `yaml:"ollama_url"`
+	// MaxTokens, Temperature and TopP are shorthands for the num_predict,
+	// temperature and top_p options. Left unset, the model's own
+	// parameters apply.
+	MaxTokens   *int          `yaml:"max_tokens"`
+	Temperature *float64      `yaml:"temperature"`
+	TopP        *float64      `yaml:"top_p"`
+	Timeout     time.Duration `yaml:"timeout"`
+	// Options holds engine-wide defaults for any Ollama option. Keys set
+	// here override the shorthand fields above.
+	Options map[string]interface{} `yaml:"options"`
+	// ModelOptions holds per-model defaults keyed by "name" or "name:tag".
+	ModelOptions map[string]map[string]interface{} `yaml:"model_options"`
+	// ModelCacheTTL is how long the model list from the backends is served
+	// without asking again.
+	ModelCacheTTL time.Duration `yaml:"model_cache_ttl"`
+	// EmbedBatchSize caps the number of inputs sent to a backend in a single
+	// embed call; EmbedConcurrency caps the batches in flight per request.
+	EmbedBatchSize   int `yaml:"embed_batch_size"`
+	EmbedConcurrency int `yaml:"embed_concurrency"`
+	// Queue limits how many generate, chat and embed requests run at once.
+	Queue QueueConfig `yaml:"queue"`
+	// Backends lists the inference servers this node fronts, e.g. one
+	// Ollama instance per GPU box. Requests go to a healthy backend that
+	// has the model.
+	Backends []BackendConfig `yaml:"backends"`
+	Health   HealthConfig    `yaml:"health"`
 }
 
 type Request struct {
-Model   string `json:"model"`
-Prompt  string `json:"prompt"`
-Stream  bool   `json:"stream"`
-System  string `json:"system,omitempty"`
-Options map[string]interface{} `json:"options,omitempty"`
+	Model   string                 `json:"model"`
+	Prompt  string                 `json:"prompt"`
+	Stream  bool                   `json:"stream"`
+	System  string                 `json:"system,omitempty"`
+	Options map[string]interface{} `json:"options,omitempty"`
 }
 
 type Response struct {
-Model              string `json:"model"`
-CreatedAt          time.Time `json:"created_at"`
-Response           string `json:"response"`
-Done               bool   `json:"done"`
-Context            []int  `json:"context,omitempty"`
-TotalDuration      time.Duration `json:"total_duration"`
-LoadDuration       time.Duration `json:"load_duration"`
-PromptEvalCount    int    `json:"prompt_eval_count"`
-PromptEvalDuration time.Duration `json:"prompt_eval_duration"`
-EvalCount          int    `json:"eval_count"`
-EvalDuration       time.Duration `json:"eval_duration"`
+	Model              string        `json:"model"`
+	CreatedAt          time.Time     `json:"created_at"`
+	Response           string        `json:"response"`
+	Done               bool          `json:"done"`
+	Context            []int         `json:"context,omitempty"`
+	TotalDuration      time.Duration `json:"total_duration"`
+	LoadDuration       time.Duration `json:"load_duration"`
+	PromptEvalCount    int           `json:"prompt_eval_count"`
+	PromptEvalDuration time.Duration `json:"prompt_eval_duration"`
+	EvalCount          int           `json:"eval_count"`
+	EvalDuration       time.Duration `json:"eval_duration"`
+	DoneReason         string        `json:"done_reason,omitempty"`
 }
 
-func NewEngine() *Engine {
-return &Engine{
-models: make(map[string]*Model),
-config: &Config{
-OllamaURL:   "http://localhost:11434",
-MaxTokens:   512,
-Temperature: 0.7,
-TopP:        0.9,
-Timeout:     30 * time.Second,
-},
-client: &http.Client{
-Timeout: 30 * time.Second,
-},
-}
+// DefaultConfig returns the settings NewEngine starts with.
+func DefaultConfig() Config {
+	return Config{
+		OllamaURL:        "http://localhost:11434",
+		Timeout:          30 * time.Second,
+		ModelCacheTTL:    30 * time.Second,
+		EmbedBatchSize:   32,
+		EmbedConcurrency: 4,
+		Queue: QueueConfig{
+			MaxConcurrent: 4,
+			MaxQueued:     64,
+			RetryAfter:    5 * time.Second,
+		},
+		Health: HealthConfig{
+			Interval:         10 * time.Second,
+			Timeout:          5 * time.Second,
+			FailureThreshold: 3,
+			Cooldown:         30 * time.Second,
+		},
+	}
 }
 
-func (e *Engine) Process(ctx context.Context, req *Request) (*Response, error) {
-ollamaReq := map[string]interface{}{
-"model":  req.Model,
-"prompt": req.Prompt,
-"stream": false,
-"options": map[string]interface{}{
-"temperature": e.config.Temperature,
-"top_p":       e.config.TopP,
-"max_tokens":  e.config.MaxTokens,
-},
+func NewEngine() *Engine {
+	cfg := DefaultConfig()
+	return &Engine{
+		models: make(map[string]*Model),
+		config: &cfg,
+		queue:  newScheduler(cfg.Queue),
+		// Backends share one client. It has no overall timeout so that long
+		// generations are bounded only by the request context.
+		pool: newPool(&cfg, &http.Client{}),
+	}
 }
 
-jsonData, err := json.Marshal(ollamaReq)
-if err != nil {
-return nil, fmt.Errorf("failed to marshal request: %w", err)
-}
+// SetConfig replaces the engine configuration. It is safe to call while
+// requests are being served; requests already running keep the settings
+// they started with.
+func (e *Engine) SetConfig(cfg Config) {
+	e.mu.Lock()
+	backendsChanged := !reflect.DeepEqual(backendConfigs(e.config), backendConfigs(&cfg))
+	e.config = &cfg
+	e.mu.Unlock()
 
-httpReq, err := http.NewRequestWithContext(ctx, "POST", e.config.OllamaURL+"/api/generate", 
-io.NopCloser(bytes.NewReader(jsonData)))
-if err != nil {
-return nil, fmt.Errorf("failed to create request: %w", err)
+	e.queue.configure(cfg.Queue)
+	e.pool.configure(&cfg)
+	if backendsChanged {
+		e.invalidateModels()
+	}
 }
-httpReq.Header.Set("Content-Type", "application/json")
 
-resp, err := e.client.Do(httpReq)
-if err != nil {
-return nil, fmt.Errorf("failed to send request: %w", err)
+// withTimeout bounds a non-streaming backend call by Config.Timeout.
+func (e *Engine) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	e.mu.RLock()
+	timeout := e.config.Timeout
+	e.mu.RUnlock()
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
 }
-defer resp.Body.Close()
 
-if resp.StatusCode != http.StatusOK {
-return nil, fmt.Errorf("ollama API error: %s", resp.Status)
-}
+func (e *Engine) Process(ctx context.Context, req *Request) (*Response, error) {
+	if peerID, ok := PeerFromContext(ctx); ok {
+		remote, err := e.remoteExecutor(peerID)
+		if err != nil {
+			return nil, err
+		}
+		return remote.Process(ctx, peerID, req)
+	}
 
-var response Response
-if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
-return nil, fmt.Errorf("failed to decode response: %w", err)
-}
+	release, err := e.queue.acquire(ctx, req.Model)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
 
-return &response, nil
-}
+	ctx, cancel := e.withTimeout(ctx)
+	defer cancel()
 
-func (e *Engine) ListModels(ctx context.Context) ([]Model, error) {
-req, err := http.NewRequestWithContext(ctx, "GET", e.config.OllamaURL+"/api/tags", nil)
-if err != nil {
-return nil, fmt.Errorf("failed to create request: %w", err)
+	var response *Response
+	err = e.generate(ctx, req, false, func(r *Response) error {
+		response = r
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return response, nil
 }
 
-resp, err := e.client.Do(req)
-if err != nil {
-return nil, fmt.Errorf("failed to send request: %w", err)
-}
-defer resp.Body.Close()
+// ProcessStream runs req in streaming mode and calls fn for every
+// NDJSON chunk as it arrives. Canceling ctx aborts the upstream request; an
+// error returned by fn stops the stream and is returned as is.
+func (e *Engine) ProcessStream(ctx context.Context, req *Request, fn func(*Response) error) error {
+	if peerID, ok := PeerFromContext(ctx); ok {
+		remote, err := e.remoteExecutor(peerID)
+		if err != nil {
+			return err
+		}
+		return remote.ProcessStream(ctx, peerID, req, fn)
+	}
 
-var result struct {
-Models []Model `json:"models"`
-}
-if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-return nil, fmt.Errorf("failed to decode response: %w", err)
-}
+	release, err := e.queue.acquire(ctx, req.Model)
+	if err != nil {
+		return err
+	}
+	defer release()
 
-return result.Models, nil
+	return e.generate(ctx, req, true, fn)
 }
 
-func (e *Engine) LoadModel(ctx context.Context, modelName string) error {
-loadReq := map[string]string{
-"model": modelName,
-}
+func (e *Engine) generate(ctx context.Context, req *Request, stream bool, fn func(*Response) error) error {
+	options, err := e.resolveOptions(req.Model, req.Options)
+	if err != nil {
+		return err
+	}
 
-jsonData, err := json.Marshal(loadReq)
-if err != nil {
-return fmt.Errorf("failed to marshal load request: %w", err)
-}
+	backendReq := *req
+	backendReq.Stream = stream
+	backendReq.Options = options
 
-req, err := http.NewRequestWithContext(ctx, "POST", e.config.OllamaURL+"/api/pull",
-io.NopCloser(bytes.NewReader(jsonData)))
-if err != nil {
-return fmt.Errorf("failed to create load request: %w", err)
+	return e.pool.use(ctx, req.Model, supports(CapCompletion), func(b Backend) error {
+		return b.Generate(ctx, &backendReq, fn)
+	})
 }
-req.Header.Set("Content-Type", "application/json")
 
-resp, err := e.client.Do(req)
-if err != nil {
-return fmt.Errorf("failed to load model: %w", err)
-}
-defer resp.Body.Close()
+// ErrInvalidRequest is wrapped by errors for requests that are missing
+// required fields.
+var ErrInvalidRequest = errors.New("invalid request")
+
+// ErrModelNotFound is wrapped by errors for requests naming a model that the
+// backend does not have.
+var ErrModelNotFound = errors.New("model not found")
+
+// fetchModels asks every available backend for its models and merges the
+// lists, keeping the first entry for models present on several backends
+// but the capabilities of all of them.
+// Callers normally go through the cached ListModels instead.
+func (e *Engine) fetchModels(ctx context.Context) ([]Model, error) {
+	backends := e.pool.available()
+	if len(backends) == 0 {
+		return nil, ErrNoBackend
+	}
+	_, health := e.pool.snapshot()
+
+	lists := make([][]Model, len(backends))
+	errs := make([]error, len(backends))
+	var wg sync.WaitGroup
+	for i, m := range backends {
+		wg.Add(1)
+		go func(i int, m *member) {
+			defer wg.Done()
+			lists[i], errs[i] = m.refresh(ctx, health)
+		}(i, m)
+	}
+	wg.Wait()
 
-if resp.StatusCode != http.StatusOK {
-return fmt.Errorf("failed to load model: %s", resp.Status)
+	var models []Model
+	index := make(map[string]int)
+	failed := 0
+	for i, list := range lists {
+		if errs[i] != nil {
+			failed++
+			continue
+		}
+		for _, m := range list {
+			key := NormalizeModelName(m.Name)
+			if j, ok := index[key]; ok {
+				models[j].Capabilities = mergeCapabilities(models[j].Capabilities, m.Capabilities)
+				continue
+			}
+			index[key] = len(models)
+			models = append(models, m)
+		}
+	}
+	if failed == len(backends) {
+		return nil, fmt.Errorf("%w: %v", ErrNoBackend, errs[0])
+	}
+	return models, nil
 }
 
-return nil
+func mergeCapabilities(a, b []Capability) []Capability {
+	merged := append([]Capability(nil), a...)
+	for _, c := range b {
+		found := false
+		for _, have := range merged {
+			if have == c {
+				found = true
+				break
+			}
+		}
+		if !found {
+			merged = append(merged, c)
+		}
+	}
+	return merged
 }
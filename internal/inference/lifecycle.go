@@ -0,0 +1,289 @@
+package inference
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// The request types below accept Ollama's legacy "name" field as an alias
+// for "model".
+
+type PullRequest struct {
+	Model    string `json:"model"`
+	Name     string `json:"name,omitempty"`
+	Insecure bool   `json:"insecure,omitempty"`
+	Stream   bool   `json:"stream"`
+}
+
+// ProgressResponse is one status update of a pull or create operation.
+type ProgressResponse struct {
+	Status    string `json:"status"`
+	Digest    string `json:"digest,omitempty"`
+	Total     int64  `json:"total,omitempty"`
+	Completed int64  `json:"completed,omitempty"`
+}
+
+type DeleteRequest struct {
+	Model string `json:"model"`
+	Name  string `json:"name,omitempty"`
+}
+
+type CopyRequest struct {
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+}
+
+type ShowRequest struct {
+	Model   string `json:"model"`
+	Name    string `json:"name,omitempty"`
+	Verbose bool   `json:"verbose,omitempty"`
+}
+
+type ShowResponse struct {
+	License      string                 `json:"license,omitempty"`
+	Modelfile    string                 `json:"modelfile,omitempty"`
+	Parameters   string                 `json:"parameters,omitempty"`
+	Template     string                 `json:"template,omitempty"`
+	System       string                 `json:"system,omitempty"`
+	Details      ModelDetails           `json:"details"`
+	Messages     []Message              `json:"messages,omitempty"`
+	ModelInfo    map[string]interface{} `json:"model_info,omitempty"`
+	Capabilities []string               `json:"capabilities,omitempty"`
+	ModifiedAt   time.Time              `json:"modified_at"`
+}
+
+// CreateRequest covers both the current file-based create API and the
+// older Modelfile form; Ollama decides which one applies.
+type CreateRequest struct {
+	Model      string                 `json:"model"`
+	Name       string                 `json:"name,omitempty"`
+	From       string                 `json:"from,omitempty"`
+	Files      map[string]string      `json:"files,omitempty"`
+	Adapters   map[string]string      `json:"adapters,omitempty"`
+	Template   string                 `json:"template,omitempty"`
+	License    json.RawMessage        `json:"license,omitempty"`
+	System     string                 `json:"system,omitempty"`
+	Parameters map[string]interface{} `json:"parameters,omitempty"`
+	Messages   []Message              `json:"messages,omitempty"`
+	Quantize   string                 `json:"quantize,omitempty"`
+	Modelfile  string                 `json:"modelfile,omitempty"`
+	Stream     bool                   `json:"stream"`
+}
+
+// ModelEvent describes a change to the engine's model inventory.
+type ModelEvent struct {
+	Model  Model
+	Loaded bool
+}
+
+// OnModelChange registers fn to be called whenever a model is pulled,
+// created, copied or deleted through the engine. fn runs synchronously and
+// must not block. Hooks must be registered before the engine starts serving
+// requests.
+func (e *Engine) OnModelChange(fn func(ModelEvent)) {
+	e.modelHooks = append(e.modelHooks, fn)
+}
+
+func (e *Engine) notifyModelChange(ev ModelEvent) {
+	for _, fn := range e.modelHooks {
+		fn(ev)
+	}
+}
+
+func modelName(model, name string) string {
+	if model != "" {
+		return model
+	}
+	return name
+}
+
+// SetMetrics makes the engine report model loads and queue state to m. It
+// must be called before the engine starts serving requests.
+func (e *Engine) SetMetrics(m Metrics) {
+	e.metrics = m
+	e.queue.setMetrics(m)
+}
+
+// LoadModel pulls modelName without reporting progress.
+func (e *Engine) LoadModel(ctx context.Context, modelName string) error {
+	return e.PullModel(ctx, &PullRequest{Model: modelName}, nil)
+}
+
+// PullModel downloads a model from the registry, calling fn (if non-nil)
+// for every progress update. The model is marked loaded once Ollama reports
+// success, and the pull duration is recorded as a model load.
+func (e *Engine) PullModel(ctx context.Context, req *PullRequest, fn func(*ProgressResponse) error) error {
+	name := modelName(req.Model, req.Name)
+	if name == "" {
+		return fmt.Errorf("%w: model name is required", ErrInvalidRequest)
+	}
+
+	start := time.Now()
+	pullReq := &PullRequest{Model: name, Insecure: req.Insecure, Stream: true}
+	err := e.pool.use(ctx, name, supports(CapPull), func(b Backend) error {
+		return b.Pull(ctx, pullReq, progress(fn))
+	})
+	if err != nil {
+		return err
+	}
+
+	e.markLoaded(name, nil)
+	e.recordLoad(time.Since(start))
+	return nil
+}
+
+// CreateModel creates a model from the given definition, streaming progress
+// to fn (if non-nil) like PullModel.
+func (e *Engine) CreateModel(ctx context.Context, req *CreateRequest, fn func(*ProgressResponse) error) error {
+	name := modelName(req.Model, req.Name)
+	if name == "" {
+		return fmt.Errorf("%w: model name is required", ErrInvalidRequest)
+	}
+
+	createReq := *req
+	createReq.Model = name
+	createReq.Name = ""
+	createReq.Stream = true
+
+	start := time.Now()
+	err := e.pool.use(ctx, name, managesModels, func(b Backend) error {
+		return b.(ModelManager).CreateModel(ctx, &createReq, progress(fn))
+	})
+	if err != nil {
+		return err
+	}
+
+	e.markLoaded(name, nil)
+	e.recordLoad(time.Since(start))
+	return nil
+}
+
+func (e *Engine) DeleteModel(ctx context.Context, req *DeleteRequest) error {
+	name := modelName(req.Model, req.Name)
+	if name == "" {
+		return fmt.Errorf("%w: model name is required", ErrInvalidRequest)
+	}
+
+	ctx, cancel := e.withTimeout(ctx)
+	defer cancel()
+
+	// Remove the model from every backend that has it, so that it does not
+	// come back from another one on the next listing.
+	deleteFn := func(b Backend) error {
+		return b.(ModelManager).DeleteModel(ctx, name)
+	}
+	holding := e.pool.holding(name)
+	for _, m := range holding {
+		if !managesModels(m.Backend) {
+			return fmt.Errorf("%w: backend %s cannot delete models", ErrUnsupported, m.Name())
+		}
+	}
+	var err error
+	if len(holding) == 0 {
+		err = e.pool.use(ctx, name, managesModels, deleteFn)
+	} else {
+		err = e.pool.useEach(ctx, holding, deleteFn)
+	}
+	if err != nil {
+		return err
+	}
+
+	key := NormalizeModelName(name)
+	e.mu.Lock()
+	removed := Model{Name: key, Model: key}
+	if m, ok := e.models[key]; ok {
+		m.Loaded = false
+		removed = *m
+		delete(e.models, key)
+	}
+	e.mu.Unlock()
+	e.invalidateModels()
+
+	e.notifyModelChange(ModelEvent{Model: removed})
+	return nil
+}
+
+func (e *Engine) CopyModel(ctx context.Context, req *CopyRequest) error {
+	if req.Source == "" || req.Destination == "" {
+		return fmt.Errorf("%w: source and destination are required", ErrInvalidRequest)
+	}
+
+	ctx, cancel := e.withTimeout(ctx)
+	defer cancel()
+
+	err := e.pool.use(ctx, req.Source, managesModels, func(b Backend) error {
+		return b.(ModelManager).CopyModel(ctx, req)
+	})
+	if err != nil {
+		return err
+	}
+
+	e.mu.RLock()
+	src := e.models[NormalizeModelName(req.Source)]
+	e.mu.RUnlock()
+	e.markLoaded(req.Destination, src)
+	return nil
+}
+
+func (e *Engine) ShowModel(ctx context.Context, req *ShowRequest) (*ShowResponse, error) {
+	name := modelName(req.Model, req.Name)
+	if name == "" {
+		return nil, fmt.Errorf("%w: model name is required", ErrInvalidRequest)
+	}
+
+	ctx, cancel := e.withTimeout(ctx)
+	defer cancel()
+
+	var show *ShowResponse
+	err := e.pool.use(ctx, name, managesModels, func(b Backend) error {
+		var err error
+		show, err = b.(ModelManager).ShowModel(ctx, &ShowRequest{Model: name, Verbose: req.Verbose})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return show, nil
+}
+
+// progress returns fn, or a function discarding updates when fn is nil.
+func progress(fn func(*ProgressResponse) error) func(*ProgressResponse) error {
+	if fn == nil {
+		return func(*ProgressResponse) error { return nil }
+	}
+	return fn
+}
+
+// markLoaded records name in the engine inventory under its normalized
+// form, copying metadata from template when given, and drops the cached
+// model list so that size and digest are picked up from Ollama on the next
+// listing.
+func (e *Engine) markLoaded(name string, template *Model) {
+	name = NormalizeModelName(name)
+	e.mu.Lock()
+	m, ok := e.models[name]
+	if !ok {
+		m = &Model{}
+		if template != nil {
+			*m = *template
+		}
+		m.Name = name
+		m.Model = name
+		e.models[name] = m
+	}
+	m.Loaded = true
+	m.Modified = time.Now()
+	loaded := *m
+	e.mu.Unlock()
+
+	e.invalidateModels()
+	e.notifyModelChange(ModelEvent{Model: loaded, Loaded: true})
+}
+
+func (e *Engine) recordLoad(d time.Duration) {
+	if e.metrics != nil {
+		e.metrics.RecordModelLoad(d)
+	}
+}
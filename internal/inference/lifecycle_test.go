@@ -0,0 +1,205 @@
+package inference
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeOllama is a stand-in Ollama server holding a list of models. While
+// down, it drops every connection, which the engine sees as unreachable;
+// while failing, it answers generate requests with a server error.
+type fakeOllama struct {
+	srv  *httptest.Server
+	name string
+
+	mu       sync.Mutex
+	models   []string
+	down     bool
+	failing  bool
+	requests map[string]int // by path
+}
+
+func newFakeOllama(t *testing.T, name string, models ...string) *fakeOllama {
+	t.Helper()
+	f := &fakeOllama{name: name, models: models, requests: make(map[string]int)}
+	f.srv = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.srv.Close)
+	return f
+}
+
+func (f *fakeOllama) serve(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	f.requests[r.URL.Path]++
+	down := f.down
+	f.mu.Unlock()
+	if down {
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err == nil {
+			conn.Close()
+		}
+		return
+	}
+
+	var body struct {
+		Model  string `json:"model"`
+		Source string `json:"source"`
+		Dest   string `json:"destination"`
+	}
+	if r.Body != nil {
+		json.NewDecoder(r.Body).Decode(&body)
+	}
+	enc := json.NewEncoder(w)
+	switch r.URL.Path {
+	case "/api/tags":
+		f.mu.Lock()
+		models := make([]Model, len(f.models))
+		for i, name := range f.models {
+			models[i] = Model{Name: name, Model: name}
+		}
+		f.mu.Unlock()
+		enc.Encode(map[string]interface{}{"models": models})
+	case "/api/ps":
+		enc.Encode(map[string]interface{}{"models": []Model{}})
+	case "/api/pull":
+		f.add(NormalizeModelName(body.Model))
+		enc.Encode(ProgressResponse{Status: "success"})
+	case "/api/copy":
+		f.add(NormalizeModelName(body.Dest))
+	case "/api/delete":
+		f.remove(NormalizeModelName(body.Model))
+	case "/api/generate":
+		f.mu.Lock()
+		failing := f.failing
+		f.mu.Unlock()
+		if failing {
+			http.Error(w, `{"error":"out of memory"}`, http.StatusInternalServerError)
+			return
+		}
+		enc.Encode(Response{Model: body.Model, Response: f.name, Done: true})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (f *fakeOllama) add(name string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, m := range f.models {
+		if m == name {
+			return
+		}
+	}
+	f.models = append(f.models, name)
+}
+
+func (f *fakeOllama) remove(name string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, m := range f.models {
+		if m == name {
+			f.models = append(f.models[:i], f.models[i+1:]...)
+			return
+		}
+	}
+}
+
+func (f *fakeOllama) setDown(down bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.down = down
+}
+
+func (f *fakeOllama) setFailing(failing bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failing = failing
+}
+
+func (f *fakeOllama) count(path string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.requests[path]
+}
+
+// newTestEngine returns an engine balancing across backends, with a
+// breaker that opens after two failures for cooldown.
+func newTestEngine(t *testing.T, cooldown time.Duration, backends ...*fakeOllama) *Engine {
+	t.Helper()
+	cfg := DefaultConfig()
+	for _, b := range backends {
+		cfg.Backends = append(cfg.Backends, BackendConfig{Name: b.name, URL: b.srv.URL})
+	}
+	cfg.Timeout = 5 * time.Second
+	cfg.Health.FailureThreshold = 2
+	cfg.Health.Cooldown = cooldown
+	e := NewEngine()
+	e.SetConfig(cfg)
+	return e
+}
+
+func (e *Engine) inventory() []string {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	names := make([]string, 0, len(e.models))
+	for name := range e.models {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestModelLifecycleNormalizesNames(t *testing.T) {
+	backend := newFakeOllama(t, "gpu-0")
+	e := newTestEngine(t, time.Minute, backend)
+	var events []ModelEvent
+	e.OnModelChange(func(ev ModelEvent) { events = append(events, ev) })
+	ctx := context.Background()
+
+	expect := func(step string, inventory []string, event string, loaded bool) {
+		t.Helper()
+		if got := e.inventory(); !reflect.DeepEqual(got, inventory) {
+			t.Errorf("%s: inventory = %v, want %v", step, got, inventory)
+		}
+		if len(events) == 0 {
+			t.Fatalf("%s: no model event", step)
+		}
+		ev := events[len(events)-1]
+		if ev.Model.Name != event || ev.Loaded != loaded {
+			t.Errorf("%s: event for %q (loaded %v), want %q (loaded %v)", step, ev.Model.Name, ev.Loaded, event, loaded)
+		}
+	}
+
+	if err := e.LoadModel(ctx, "llama3"); err != nil {
+		t.Fatal(err)
+	}
+	expect("pull llama3", []string{"llama3:latest"}, "llama3:latest", true)
+
+	if err := e.LoadModel(ctx, "llama3:latest"); err != nil {
+		t.Fatal(err)
+	}
+	expect("pull llama3:latest", []string{"llama3:latest"}, "llama3:latest", true)
+
+	if err := e.CopyModel(ctx, &CopyRequest{Source: "llama3", Destination: "mine"}); err != nil {
+		t.Fatal(err)
+	}
+	expect("copy", []string{"llama3:latest", "mine:latest"}, "mine:latest", true)
+
+	if err := e.DeleteModel(ctx, &DeleteRequest{Model: "llama3"}); err != nil {
+		t.Fatal(err)
+	}
+	expect("delete", []string{"mine:latest"}, "llama3:latest", false)
+
+	if _, err := e.RefreshModels(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := e.inventory(); !reflect.DeepEqual(got, []string{"mine:latest"}) {
+		t.Errorf("refresh: inventory = %v, want [mine:latest]", got)
+	}
+}
@@ -0,0 +1,57 @@
+package inference
+
+import (
+	"context"
+	"net/http"
+)
+
+// llamaCppBackend talks to the llama.cpp server. It speaks the OpenAI API
+// but also accepts llama.cpp's own sampling parameters, and serves exactly
+// the model it was started with, which is always loaded.
+type llamaCppBackend struct {
+	openAIBackend
+}
+
+// llamaCppParams adds the llama.cpp sampling parameters to openAIParams.
+var llamaCppParams = map[string]string{
+	"top_k":            "top_k",
+	"min_p":            "min_p",
+	"typical_p":        "typical_p",
+	"tfs_z":            "tfs_z",
+	"repeat_penalty":   "repeat_penalty",
+	"repeat_last_n":    "repeat_last_n",
+	"penalize_newline": "penalize_nl",
+	"mirostat":         "mirostat",
+	"mirostat_tau":     "mirostat_tau",
+	"mirostat_eta":     "mirostat_eta",
+	"num_keep":         "n_keep",
+}
+
+func newLlamaCppBackend(cfg BackendConfig, client *http.Client) (Backend, error) {
+	params := make(map[string]string, len(openAIParams)+len(llamaCppParams))
+	for k, v := range openAIParams {
+		params[k] = v
+	}
+	for k, v := range llamaCppParams {
+		params[k] = v
+	}
+	return &llamaCppBackend{openAIBackend{
+		httpBackend: newHTTPBackend(cfg, "llama.cpp", client),
+		// Tool calling and images depend on how the server was started
+		// (--jinja, --mmproj), so they are not advertised.
+		caps:   []Capability{CapCompletion, CapEmbedding},
+		params: params,
+	}}, nil
+}
+
+func (l *llamaCppBackend) RunningModels(ctx context.Context) ([]string, error) {
+	models, err := l.ListModels(ctx)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(models))
+	for i, m := range models {
+		names[i] = m.Name
+	}
+	return names, nil
+}
@@ -0,0 +1,113 @@
+package inference
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// modelCache holds the last model list fetched from the backends. A stale
+// entry is still served while a single background refresh replaces it, so
+// callers only block on the backends when nothing has been fetched yet.
+type modelCache struct {
+	mu         sync.Mutex
+	models     []Model
+	fetchedAt  time.Time
+	refreshing bool
+}
+
+// ListModels returns the models available on the local backends. A
+// non-positive ModelCacheTTL disables caching.
+func (e *Engine) ListModels(ctx context.Context) ([]Model, error) {
+	e.mu.RLock()
+	ttl := e.config.ModelCacheTTL
+	e.mu.RUnlock()
+
+	c := &e.modelList
+	c.mu.Lock()
+	if ttl <= 0 || c.fetchedAt.IsZero() {
+		c.mu.Unlock()
+		return e.RefreshModels(ctx)
+	}
+
+	models := append([]Model(nil), c.models...)
+	if time.Since(c.fetchedAt) >= ttl && !c.refreshing {
+		c.refreshing = true
+		go func() {
+			ctx, cancel := e.withTimeout(context.Background())
+			defer cancel()
+			if _, err := e.RefreshModels(ctx); err != nil {
+				slog.Warn("Model list refresh failed", "err", err)
+			}
+		}()
+	}
+	c.mu.Unlock()
+
+	return models, nil
+}
+
+// RefreshModels fetches the model list from the backends, replacing the
+// cached copy and the engine's model inventory.
+func (e *Engine) RefreshModels(ctx context.Context) ([]Model, error) {
+	models, err := e.fetchModels(ctx)
+	for i := range models {
+		models[i].Loaded = true
+	}
+
+	c := &e.modelList
+	c.mu.Lock()
+	c.refreshing = false
+	if err == nil {
+		c.models = models
+		c.fetchedAt = time.Now()
+	}
+	c.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	e.mu.Lock()
+	inventory := make(map[string]*Model, len(models))
+	for i := range models {
+		m := models[i]
+		inventory[NormalizeModelName(m.Name)] = &m
+	}
+	e.models = inventory
+	e.mu.Unlock()
+
+	return append([]Model(nil), models...), nil
+}
+
+// invalidateModels drops the cached model list so the next ListModels call
+// goes to the backends.
+func (e *Engine) invalidateModels() {
+	e.modelList.mu.Lock()
+	e.modelList.fetchedAt = time.Time{}
+	e.modelList.mu.Unlock()
+}
+
+// NormalizeModelName adds Ollama's implicit ":latest" tag to names that
+// carry none, so "llama3" and "llama3:latest" compare equal.
+func NormalizeModelName(name string) string {
+	if name == "" || strings.Contains(name, ":") {
+		return name
+	}
+	return name + ":latest"
+}
+
+// HasModel reports whether the local backend holds the named model.
+func (e *Engine) HasModel(ctx context.Context, name string) bool {
+	models, err := e.ListModels(ctx)
+	if err != nil {
+		return false
+	}
+	name = NormalizeModelName(name)
+	for _, m := range models {
+		if NormalizeModelName(m.Name) == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,157 @@
+package inference
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// ollamaBackend talks to Ollama's native API.
+type ollamaBackend struct {
+	httpBackend
+}
+
+func newOllamaBackend(cfg BackendConfig, client *http.Client) (Backend, error) {
+	return &ollamaBackend{newHTTPBackend(cfg, "ollama", client)}, nil
+}
+
+func (o *ollamaBackend) Capabilities() []Capability {
+	return []Capability{CapCompletion, CapEmbedding, CapTools, CapVision, CapPull}
+}
+
+func (o *ollamaBackend) Generate(ctx context.Context, req *Request, fn func(*Response) error) error {
+	ollamaReq := map[string]interface{}{
+		"model":   req.Model,
+		"prompt":  req.Prompt,
+		"stream":  req.Stream,
+		"options": req.Options,
+	}
+	if req.System != "" {
+		ollamaReq["system"] = req.System
+	}
+	return ollamaStream(ctx, o, "/api/generate", ollamaReq, req.Stream, fn)
+}
+
+func (o *ollamaBackend) Chat(ctx context.Context, req *ChatRequest, fn func(*ChatResponse) error) error {
+	return ollamaStream(ctx, o, "/api/chat", req, req.Stream, fn)
+}
+
+func (o *ollamaBackend) Embed(ctx context.Context, req *EmbedRequest) (*EmbedResponse, error) {
+	var response EmbedResponse
+	if err := o.call(ctx, "POST", "/api/embed", req, &response); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+func (o *ollamaBackend) ListModels(ctx context.Context) ([]Model, error) {
+	var result struct {
+		Models []Model `json:"models"`
+	}
+	if err := o.call(ctx, "GET", "/api/tags", nil, &result); err != nil {
+		return nil, err
+	}
+	return result.Models, nil
+}
+
+func (o *ollamaBackend) RunningModels(ctx context.Context) ([]string, error) {
+	var result struct {
+		Models []struct {
+			Name string `json:"name"`
+		} `json:"models"`
+	}
+	if err := o.call(ctx, "GET", "/api/ps", nil, &result); err != nil {
+		return nil, err
+	}
+	names := make([]string, len(result.Models))
+	for i, m := range result.Models {
+		names[i] = m.Name
+	}
+	return names, nil
+}
+
+func (o *ollamaBackend) Pull(ctx context.Context, req *PullRequest, fn func(*ProgressResponse) error) error {
+	return ollamaStream(ctx, o, "/api/pull", req, true, fn)
+}
+
+func (o *ollamaBackend) DeleteModel(ctx context.Context, name string) error {
+	return o.call(ctx, "DELETE", "/api/delete", &DeleteRequest{Model: name}, nil)
+}
+
+func (o *ollamaBackend) CopyModel(ctx context.Context, req *CopyRequest) error {
+	return o.call(ctx, "POST", "/api/copy", req, nil)
+}
+
+func (o *ollamaBackend) ShowModel(ctx context.Context, req *ShowRequest) (*ShowResponse, error) {
+	var show ShowResponse
+	if err := o.call(ctx, "POST", "/api/show", req, &show); err != nil {
+		return nil, err
+	}
+	return &show, nil
+}
+
+func (o *ollamaBackend) CreateModel(ctx context.Context, req *CreateRequest, fn func(*ProgressResponse) error) error {
+	return ollamaStream(ctx, o, "/api/create", req, true, fn)
+}
+
+// ollamaStream posts body to an endpoint that answers with a single JSON
+// object, or with an NDJSON stream when stream is set.
+func ollamaStream[T any](ctx context.Context, o *ollamaBackend, path string, body interface{}, stream bool, fn func(*T) error) error {
+	resp, err := o.do(ctx, "POST", path, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if stream {
+		return readStream(ctx, resp.Body, fn)
+	}
+	response := new(T)
+	if err := json.NewDecoder(resp.Body).Decode(response); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return fn(response)
+}
+
+// readStream decodes an Ollama NDJSON stream, calling fn for every chunk
+// until one is marked done or the body ends. An {"error": ...} line from
+// Ollama is returned as an error.
+func readStream[T any](ctx context.Context, r io.Reader, fn func(*T) error) error {
+	dec := json.NewDecoder(r)
+	for {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return ctxErr
+			}
+			return fmt.Errorf("failed to decode stream chunk: %w", err)
+		}
+
+		var status struct {
+			Done  bool   `json:"done"`
+			Error string `json:"error"`
+		}
+		if err := json.Unmarshal(raw, &status); err != nil {
+			return fmt.Errorf("failed to decode stream chunk: %w", err)
+		}
+		if status.Error != "" {
+			return fmt.Errorf("ollama API error: %s", status.Error)
+		}
+
+		chunk := new(T)
+		if err := json.Unmarshal(raw, chunk); err != nil {
+			return fmt.Errorf("failed to decode stream chunk: %w", err)
+		}
+		if err := fn(chunk); err != nil {
+			return err
+		}
+		if status.Done {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,454 @@
+package inference
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// openAIBackend talks to servers implementing the OpenAI REST API, such as
+// vLLM, LM Studio or OpenAI itself. Generate requests are sent as a chat
+// completion so that the server applies the model's prompt template, as
+// Ollama does.
+type openAIBackend struct {
+	httpBackend
+	caps []Capability
+	// params maps Ollama option names onto request fields. Options without
+	// an entry are not supported by the server and are dropped.
+	params map[string]string
+}
+
+// openAIParams are the Ollama options with an equivalent in the OpenAI API.
+var openAIParams = map[string]string{
+	"num_predict":       "max_tokens",
+	"temperature":       "temperature",
+	"top_p":             "top_p",
+	"seed":              "seed",
+	"stop":              "stop",
+	"presence_penalty":  "presence_penalty",
+	"frequency_penalty": "frequency_penalty",
+}
+
+func newOpenAIBackend(cfg BackendConfig, client *http.Client) (Backend, error) {
+	return &openAIBackend{
+		httpBackend: newHTTPBackend(cfg, "openai", client),
+		caps:        []Capability{CapCompletion, CapEmbedding, CapTools, CapVision},
+		params:      openAIParams,
+	}, nil
+}
+
+func (o *openAIBackend) Capabilities() []Capability { return o.caps }
+
+// upstreamModel drops the ":latest" tag that Ollama clients add to names
+// without one; OpenAI-style model IDs have no tags.
+func upstreamModel(name string) string {
+	return strings.TrimSuffix(name, ":latest")
+}
+
+type openAIToolCall struct {
+	Index    int    `json:"index"`
+	ID       string `json:"id,omitempty"`
+	Type     string `json:"type,omitempty"`
+	Function struct {
+		Name      string `json:"name,omitempty"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+type openAIMessage struct {
+	Role       string           `json:"role"`
+	Content    interface{}      `json:"content"`
+	ToolCalls  []openAIToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string           `json:"tool_call_id,omitempty"`
+}
+
+type openAIContentPart struct {
+	Type     string          `json:"type"`
+	Text     string          `json:"text,omitempty"`
+	ImageURL *openAIImageURL `json:"image_url,omitempty"`
+}
+
+type openAIImageURL struct {
+	URL string `json:"url"`
+}
+
+type openAIReply struct {
+	Role      string           `json:"role"`
+	Content   string           `json:"content"`
+	ToolCalls []openAIToolCall `json:"tool_calls"`
+}
+
+type openAIChatResponse struct {
+	Model   string `json:"model"`
+	Choices []struct {
+		Message      openAIReply `json:"message"`
+		Delta        openAIReply `json:"delta"`
+		FinishReason *string     `json:"finish_reason"`
+	} `json:"choices"`
+	Usage *struct {
+		PromptTokens     int `json:"prompt_tokens"`
+		CompletionTokens int `json:"completion_tokens"`
+	} `json:"usage"`
+	Error json.RawMessage `json:"error"`
+}
+
+func (o *openAIBackend) Generate(ctx context.Context, req *Request, fn func(*Response) error) error {
+	chatReq := &ChatRequest{
+		Model:   req.Model,
+		Stream:  req.Stream,
+		Options: req.Options,
+	}
+	if req.System != "" {
+		chatReq.Messages = append(chatReq.Messages, Message{Role: "system", Content: req.System})
+	}
+	chatReq.Messages = append(chatReq.Messages, Message{Role: "user", Content: req.Prompt})
+
+	return o.Chat(ctx, chatReq, func(c *ChatResponse) error {
+		return fn(&Response{
+			Model:              c.Model,
+			CreatedAt:          c.CreatedAt,
+			Response:           c.Message.Content,
+			Done:               c.Done,
+			DoneReason:         c.DoneReason,
+			TotalDuration:      c.TotalDuration,
+			PromptEvalCount:    c.PromptEvalCount,
+			PromptEvalDuration: c.PromptEvalDuration,
+			EvalCount:          c.EvalCount,
+			EvalDuration:       c.EvalDuration,
+		})
+	})
+}
+
+func (o *openAIBackend) Chat(ctx context.Context, req *ChatRequest, fn func(*ChatResponse) error) error {
+	body, err := o.chatBody(req)
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	resp, err := o.do(ctx, "POST", "/v1/chat/completions", body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if !req.Stream {
+		var result openAIChatResponse
+		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			return fmt.Errorf("failed to decode response: %w", err)
+		}
+		if len(result.Choices) == 0 {
+			return fmt.Errorf("%s API error: response has no choices", o.kind)
+		}
+		choice := result.Choices[0]
+		msg, err := fromOpenAIReply(choice.Message)
+		if err != nil {
+			return err
+		}
+		final := o.finalChunk(req.Model, msg, choice.FinishReason, start)
+		if result.Usage != nil {
+			final.PromptEvalCount = result.Usage.PromptTokens
+			final.EvalCount = result.Usage.CompletionTokens
+		}
+		return fn(final)
+	}
+
+	// Tool calls arrive in fragments; they are assembled and delivered
+	// with the final chunk, which is how Ollama reports them.
+	var (
+		calls  []openAIToolCall
+		finish *string
+		usage  = struct{ prompt, completion int }{}
+	)
+	err = readSSE(ctx, resp.Body, func(data []byte) error {
+		var chunk openAIChatResponse
+		if err := json.Unmarshal(data, &chunk); err != nil {
+			return fmt.Errorf("failed to decode stream chunk: %w", err)
+		}
+		if len(chunk.Error) > 0 && string(chunk.Error) != "null" {
+			return fmt.Errorf("%s API error: %s", o.kind, errorMessage(bytes.NewReader(data)))
+		}
+		if chunk.Usage != nil {
+			usage.prompt = chunk.Usage.PromptTokens
+			usage.completion = chunk.Usage.CompletionTokens
+		}
+		for _, choice := range chunk.Choices {
+			calls = mergeToolCalls(calls, choice.Delta.ToolCalls)
+			if choice.FinishReason != nil {
+				finish = choice.FinishReason
+			}
+			if choice.Delta.Content == "" {
+				continue
+			}
+			err := fn(&ChatResponse{
+				Model:     req.Model,
+				CreatedAt: time.Now(),
+				Message:   Message{Role: "assistant", Content: choice.Delta.Content},
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	msg, err := fromOpenAIReply(openAIReply{Role: "assistant", ToolCalls: calls})
+	if err != nil {
+		return err
+	}
+	final := o.finalChunk(req.Model, msg, finish, start)
+	final.PromptEvalCount = usage.prompt
+	final.EvalCount = usage.completion
+	return fn(final)
+}
+
+func (o *openAIBackend) finalChunk(model string, msg Message, finish *string, start time.Time) *ChatResponse {
+	reason := "stop"
+	if finish != nil && *finish == "length" {
+		reason = "length"
+	}
+	return &ChatResponse{
+		Model:         model,
+		CreatedAt:     time.Now(),
+		Message:       msg,
+		Done:          true,
+		DoneReason:    reason,
+		TotalDuration: time.Since(start),
+	}
+}
+
+// chatBody translates req into a chat completion request.
+func (o *openAIBackend) chatBody(req *ChatRequest) (map[string]interface{}, error) {
+	messages, err := toOpenAIMessages(req.Messages)
+	if err != nil {
+		return nil, err
+	}
+
+	body := map[string]interface{}{
+		"model":    upstreamModel(req.Model),
+		"messages": messages,
+		"stream":   req.Stream,
+	}
+	if req.Stream {
+		body["stream_options"] = map[string]bool{"include_usage": true}
+	}
+	if len(req.Tools) > 0 {
+		body["tools"] = req.Tools
+	}
+	if format := responseFormat(req.Format); format != nil {
+		body["response_format"] = format
+	}
+	for name, value := range req.Options {
+		if param, ok := o.params[name]; ok {
+			body[param] = value
+		}
+	}
+	return body, nil
+}
+
+// toOpenAIMessages converts a conversation. Ollama does not identify tool
+// calls, so IDs are made up here and handed to the tool messages that
+// follow, in order.
+func toOpenAIMessages(msgs []Message) ([]openAIMessage, error) {
+	var (
+		result  []openAIMessage
+		pending []string
+		nextID  int
+	)
+	for _, m := range msgs {
+		out := openAIMessage{Role: m.Role, Content: m.Content}
+
+		if len(m.Images) > 0 {
+			parts := []openAIContentPart{{Type: "text", Text: m.Content}}
+			for _, img := range m.Images {
+				parts = append(parts, openAIContentPart{
+					Type:     "image_url",
+					ImageURL: &openAIImageURL{URL: "data:" + imageType(img) + ";base64," + img},
+				})
+			}
+			out.Content = parts
+		}
+
+		for i, tc := range m.ToolCalls {
+			args, err := json.Marshal(tc.Function.Arguments)
+			if err != nil {
+				return nil, fmt.Errorf("%w: tool call arguments: %v", ErrInvalidRequest, err)
+			}
+			call := openAIToolCall{Index: i, ID: fmt.Sprintf("call_%d", nextID), Type: "function"}
+			call.Function.Name = tc.Function.Name
+			call.Function.Arguments = string(args)
+			out.ToolCalls = append(out.ToolCalls, call)
+			pending = append(pending, call.ID)
+			nextID++
+		}
+
+		if m.Role == "tool" && len(pending) > 0 {
+			out.ToolCallID = pending[0]
+			pending = pending[1:]
+		}
+		result = append(result, out)
+	}
+	return result, nil
+}
+
+// imageType sniffs the media type of base64 encoded image data.
+func imageType(data string) string {
+	head := data
+	if len(head) > 64 {
+		head = head[:64]
+	}
+	raw, _ := base64.StdEncoding.DecodeString(head[:len(head)/4*4])
+	if t := http.DetectContentType(raw); strings.HasPrefix(t, "image/") {
+		return t
+	}
+	return "image/png"
+}
+
+// responseFormat maps Ollama's format field, either "json" or a JSON
+// schema, onto response_format.
+func responseFormat(format json.RawMessage) interface{} {
+	if len(format) == 0 || string(format) == "null" || string(format) == `""` {
+		return nil
+	}
+	if string(format) == `"json"` {
+		return map[string]string{"type": "json_object"}
+	}
+	return map[string]interface{}{
+		"type": "json_schema",
+		"json_schema": map[string]interface{}{
+			"name":   "response",
+			"schema": format,
+		},
+	}
+}
+
+// mergeToolCalls adds streamed tool call fragments to calls, keyed by their
+// index.
+func mergeToolCalls(calls, fragments []openAIToolCall) []openAIToolCall {
+	for _, f := range fragments {
+		for len(calls) <= f.Index {
+			calls = append(calls, openAIToolCall{Index: len(calls)})
+		}
+		c := &calls[f.Index]
+		if f.ID != "" {
+			c.ID = f.ID
+		}
+		if f.Function.Name != "" {
+			c.Function.Name = f.Function.Name
+		}
+		c.Function.Arguments += f.Function.Arguments
+	}
+	return calls
+}
+
+func fromOpenAIReply(r openAIReply) (Message, error) {
+	msg := Message{Role: "assistant", Content: r.Content}
+	for _, tc := range r.ToolCalls {
+		var args map[string]interface{}
+		if tc.Function.Arguments != "" {
+			if err := json.Unmarshal([]byte(tc.Function.Arguments), &args); err != nil {
+				return msg, fmt.Errorf("tool call %s: arguments are not a JSON object", tc.Function.Name)
+			}
+		}
+		msg.ToolCalls = append(msg.ToolCalls, ToolCall{
+			Function: ToolCallFunction{Name: tc.Function.Name, Arguments: args},
+		})
+	}
+	return msg, nil
+}
+
+// readSSE calls fn with the data of every server-sent event until the
+// stream ends or sends [DONE].
+func readSSE(ctx context.Context, r io.Reader, fn func(data []byte) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64<<10), 16<<20)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data:")
+		if !ok {
+			continue
+		}
+		data = strings.TrimSpace(data)
+		if data == "[DONE]" {
+			return nil
+		}
+		if err := fn([]byte(data)); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		return fmt.Errorf("failed to read stream: %w", err)
+	}
+	return nil
+}
+
+func (o *openAIBackend) Embed(ctx context.Context, req *EmbedRequest) (*EmbedResponse, error) {
+	body := map[string]interface{}{
+		"model": upstreamModel(req.Model),
+		"input": []string(req.Input),
+	}
+
+	start := time.Now()
+	var result struct {
+		Data []struct {
+			Embedding []float32 `json:"embedding"`
+			Index     int       `json:"index"`
+		} `json:"data"`
+		Usage struct {
+			PromptTokens int `json:"prompt_tokens"`
+		} `json:"usage"`
+	}
+	if err := o.call(ctx, "POST", "/v1/embeddings", body, &result); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(result.Data, func(i, j int) bool { return result.Data[i].Index < result.Data[j].Index })
+	response := &EmbedResponse{
+		Model:           req.Model,
+		Embeddings:      make([][]float32, len(result.Data)),
+		TotalDuration:   time.Since(start),
+		PromptEvalCount: result.Usage.PromptTokens,
+	}
+	for i, d := range result.Data {
+		response.Embeddings[i] = d.Embedding
+	}
+	return response, nil
+}
+
+func (o *openAIBackend) ListModels(ctx context.Context) ([]Model, error) {
+	var result struct {
+		Data []struct {
+			ID      string `json:"id"`
+			Created int64  `json:"created"`
+		} `json:"data"`
+	}
+	if err := o.call(ctx, "GET", "/v1/models", nil, &result); err != nil {
+		return nil, err
+	}
+
+	models := make([]Model, len(result.Data))
+	for i, d := range result.Data {
+		models[i] = Model{Name: d.ID, Model: d.ID}
+		if d.Created > 0 {
+			models[i].Modified = time.Unix(d.Created, 0)
+		}
+	}
+	return models, nil
+}
+
+func (o *openAIBackend) Pull(ctx context.Context, req *PullRequest, fn func(*ProgressResponse) error) error {
+	return fmt.Errorf("%w: %s backends cannot pull models", ErrUnsupported, o.kind)
+}
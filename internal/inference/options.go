@@ -0,0 +1,199 @@
+package inference
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// ErrInvalidOptions is wrapped by every error caused by a malformed or
+// unknown entry in a request's options.
+var ErrInvalidOptions = errors.New("invalid options")
+
+type optionKind int
+
+const (
+	optionInt optionKind = iota
+	optionFloat
+	optionBool
+	optionStrings
+)
+
+// knownOptions lists the runtime options documented by Ollama. Anything else
+// is rejected rather than silently ignored by the backend.
+var knownOptions = map[string]optionKind{
+	"numa":              optionBool,
+	"num_ctx":           optionInt,
+	"num_batch":         optionInt,
+	"num_gpu":           optionInt,
+	"main_gpu":          optionInt,
+	"low_vram":          optionBool,
+	"vocab_only":        optionBool,
+	"use_mmap":          optionBool,
+	"use_mlock":         optionBool,
+	"num_thread":        optionInt,
+	"num_keep":          optionInt,
+	"seed":              optionInt,
+	"num_predict":       optionInt,
+	"top_k":             optionInt,
+	"top_p":             optionFloat,
+	"min_p":             optionFloat,
+	"tfs_z":             optionFloat,
+	"typical_p":         optionFloat,
+	"repeat_last_n":     optionInt,
+	"temperature":       optionFloat,
+	"repeat_penalty":    optionFloat,
+	"presence_penalty":  optionFloat,
+	"frequency_penalty": optionFloat,
+	"mirostat":          optionInt,
+	"mirostat_tau":      optionFloat,
+	"mirostat_eta":      optionFloat,
+	"penalize_newline":  optionBool,
+	"stop":              optionStrings,
+}
+
+// ValidateOptions checks opts against the documented Ollama options and
+// returns a normalized copy: integers become int, floats float64 and stop
+// is always a []string.
+func ValidateOptions(opts map[string]interface{}) (map[string]interface{}, error) {
+	normalized := make(map[string]interface{}, len(opts))
+
+	keys := make([]string, 0, len(opts))
+	for k := range opts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		kind, ok := knownOptions[k]
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown option %q", ErrInvalidOptions, k)
+		}
+		v, err := normalizeOption(kind, opts[k])
+		if err != nil {
+			return nil, fmt.Errorf("%w: option %q %v", ErrInvalidOptions, k, err)
+		}
+		normalized[k] = v
+	}
+
+	return normalized, nil
+}
+
+func normalizeOption(kind optionKind, v interface{}) (interface{}, error) {
+	switch kind {
+	case optionInt:
+		switch n := v.(type) {
+		case int:
+			return n, nil
+		case int64:
+			return int(n), nil
+		case float64:
+			if n != math.Trunc(n) {
+				return nil, fmt.Errorf("must be an integer, got %v", n)
+			}
+			return int(n), nil
+		}
+		return nil, fmt.Errorf("must be an integer, got %T", v)
+	case optionFloat:
+		switch n := v.(type) {
+		case float64:
+			return n, nil
+		case float32:
+			return float64(n), nil
+		case int:
+			return float64(n), nil
+		case int64:
+			return float64(n), nil
+		}
+		return nil, fmt.Errorf("must be a number, got %T", v)
+	case optionBool:
+		if b, ok := v.(bool); ok {
+			return b, nil
+		}
+		return nil, fmt.Errorf("must be a boolean, got %T", v)
+	case optionStrings:
+		switch s := v.(type) {
+		case string:
+			return []string{s}, nil
+		case []string:
+			return s, nil
+		case []interface{}:
+			out := make([]string, 0, len(s))
+			for _, item := range s {
+				str, ok := item.(string)
+				if !ok {
+					return nil, fmt.Errorf("must be a list of strings, got %T element", item)
+				}
+				out = append(out, str)
+			}
+			return out, nil
+		}
+		return nil, fmt.Errorf("must be a string or a list of strings, got %T", v)
+	}
+	return nil, fmt.Errorf("has unsupported type")
+}
+
+// resolveOptions merges option layers for a request. Precedence, lowest
+// first: engine configuration, per-model configuration, the request itself.
+func (e *Engine) resolveOptions(model string, reqOpts map[string]interface{}) (map[string]interface{}, error) {
+	e.mu.RLock()
+	layers := []map[string]interface{}{e.configOptions(), e.modelOptions(model)}
+	e.mu.RUnlock()
+
+	merged := make(map[string]interface{})
+	for _, layer := range layers {
+		valid, err := ValidateOptions(layer)
+		if err != nil {
+			// A broken configuration is a server problem, not a bad request.
+			return nil, fmt.Errorf("configured defaults for %s: %v", model, err)
+		}
+		for k, v := range valid {
+			merged[k] = v
+		}
+	}
+
+	valid, err := ValidateOptions(reqOpts)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range valid {
+		merged[k] = v
+	}
+
+	return merged, nil
+}
+
+// configOptions returns the engine-wide defaults. The dedicated Config
+// fields are shorthands for the matching Ollama options and are only sent
+// when set, so that the model's Modelfile parameters apply otherwise.
+// Callers hold e.mu.
+func (e *Engine) configOptions() map[string]interface{} {
+	opts := make(map[string]interface{}, len(e.config.Options)+3)
+	if e.config.Temperature != nil {
+		opts["temperature"] = *e.config.Temperature
+	}
+	if e.config.TopP != nil {
+		opts["top_p"] = *e.config.TopP
+	}
+	if e.config.MaxTokens != nil {
+		opts["num_predict"] = *e.config.MaxTokens
+	}
+	for k, v := range e.config.Options {
+		opts[k] = v
+	}
+	return opts
+}
+
+// modelOptions returns the configured defaults for model, falling back from
+// "name:tag" to "name". Callers hold e.mu.
+func (e *Engine) modelOptions(model string) map[string]interface{} {
+	if opts, ok := e.config.ModelOptions[model]; ok {
+		return opts
+	}
+	if name, _, ok := strings.Cut(model, ":"); ok {
+		return e.config.ModelOptions[name]
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package inference
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestValidateOptions(t *testing.T) {
+	tests := []struct {
+		name string
+		in   map[string]interface{}
+		want map[string]interface{}
+		err  bool
+	}{
+		{
+			name: "normalizes numbers",
+			in:   map[string]interface{}{"num_ctx": 4096.0, "temperature": 1, "seed": int64(7)},
+			want: map[string]interface{}{"num_ctx": 4096, "temperature": 1.0, "seed": 7},
+		},
+		{
+			name: "stop as a string",
+			in:   map[string]interface{}{"stop": "\n"},
+			want: map[string]interface{}{"stop": []string{"\n"}},
+		},
+		{
+			name: "stop as a JSON list",
+			in:   map[string]interface{}{"stop": []interface{}{"a", "b"}},
+			want: map[string]interface{}{"stop": []string{"a", "b"}},
+		},
+		{
+			name: "boolean",
+			in:   map[string]interface{}{"use_mmap": false},
+			want: map[string]interface{}{"use_mmap": false},
+		},
+		{name: "unknown option", in: map[string]interface{}{"max_tokens": 10}, err: true},
+		{name: "fractional integer", in: map[string]interface{}{"num_predict": 1.5}, err: true},
+		{name: "string number", in: map[string]interface{}{"temperature": "0.5"}, err: true},
+		{name: "non-boolean", in: map[string]interface{}{"numa": 1}, err: true},
+		{name: "mixed stop list", in: map[string]interface{}{"stop": []interface{}{"a", 1}}, err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ValidateOptions(tt.in)
+			if tt.err {
+				if !errors.Is(err, ErrInvalidOptions) {
+					t.Fatalf("ValidateOptions(%v) error = %v, want ErrInvalidOptions", tt.in, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ValidateOptions(%v): %v", tt.in, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ValidateOptions(%v) = %v, want %v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveOptionsPrecedence(t *testing.T) {
+	temperature, maxTokens := 0.2, 128
+	cfg := DefaultConfig()
+	cfg.Temperature = &temperature
+	cfg.MaxTokens = &maxTokens
+	cfg.Options = map[string]interface{}{"num_predict": 256, "top_k": 10}
+	cfg.ModelOptions = map[string]map[string]interface{}{
+		"llama3":     {"top_k": 20, "num_ctx": 2048},
+		"llama3:70b": {"num_ctx": 8192},
+	}
+	e := NewEngine()
+	e.SetConfig(cfg)
+
+	tests := []struct {
+		name  string
+		model string
+		req   map[string]interface{}
+		want  map[string]interface{}
+	}{
+		{
+			name:  "options map overrides shorthands",
+			model: "mistral",
+			want:  map[string]interface{}{"temperature": 0.2, "num_predict": 256, "top_k": 10},
+		},
+		{
+			name:  "model name",
+			model: "llama3:8b",
+			want:  map[string]interface{}{"temperature": 0.2, "num_predict": 256, "top_k": 20, "num_ctx": 2048},
+		},
+		{
+			name:  "model tag replaces model name",
+			model: "llama3:70b",
+			want:  map[string]interface{}{"temperature": 0.2, "num_predict": 256, "top_k": 10, "num_ctx": 8192},
+		},
+		{
+			name:  "request overrides everything",
+			model: "llama3:70b",
+			req:   map[string]interface{}{"temperature": 0.0, "num_ctx": 1024.0},
+			want:  map[string]interface{}{"temperature": 0.0, "num_predict": 256, "top_k": 10, "num_ctx": 1024},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := e.resolveOptions(tt.model, tt.req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("resolveOptions(%q, %v) = %v, want %v", tt.model, tt.req, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveOptionsLeavesModelDefaults(t *testing.T) {
+	e := NewEngine()
+
+	got, err := e.resolveOptions("llama3", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 0 {
+		t.Errorf("resolveOptions with the default configuration = %v, want no options", got)
+	}
+}
+
+func TestResolveOptionsRejects(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.ModelOptions = map[string]map[string]interface{}{"broken": {"bogus": 1}}
+	e := NewEngine()
+	e.SetConfig(cfg)
+
+	if _, err := e.resolveOptions("llama3", map[string]interface{}{"bogus": 1}); !errors.Is(err, ErrInvalidOptions) {
+		t.Errorf("bad request options: error = %v, want ErrInvalidOptions", err)
+	}
+	// A broken configuration is not the client's fault.
+	if _, err := e.resolveOptions("broken", nil); err == nil || errors.Is(err, ErrInvalidOptions) {
+		t.Errorf("bad configured options: error = %v, want a server error", err)
+	}
+}
@@ -0,0 +1,486 @@
+package inference
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrNoBackend is returned when every backend is down or its circuit
+// breaker is open.
+var ErrNoBackend = errors.New("no healthy backend")
+
+// HealthConfig controls backend health probes and circuit breaking. A
+// backend whose probes or requests fail FailureThreshold times in a row is
+// taken out of rotation for Cooldown, after which a single request or probe
+// decides whether it comes back.
+type HealthConfig struct {
+	Interval         time.Duration `yaml:"interval"`
+	Timeout          time.Duration `yaml:"timeout"`
+	FailureThreshold int           `yaml:"failure_threshold"`
+	Cooldown         time.Duration `yaml:"cooldown"`
+}
+
+// BackendStatus describes a backend for status endpoints and health checks.
+type BackendStatus struct {
+	Name         string       `json:"name"`
+	Type         string       `json:"type"`
+	URL          string       `json:"url"`
+	Capabilities []Capability `json:"capabilities"`
+	Healthy      bool         `json:"healthy"`
+	Failures     int          `json:"failures"`
+	InFlight     int          `json:"in_flight"`
+	Models       []string     `json:"models"`
+	LastErr      string       `json:"last_error,omitempty"`
+	LastSeen     time.Time    `json:"last_seen,omitempty"`
+}
+
+// member is one backend of the pool with its inventory and breaker state.
+type member struct {
+	Backend
+	cfg BackendConfig
+
+	mu        sync.Mutex
+	models    map[string]bool
+	loaded    map[string]bool
+	inFlight  int
+	failures  int
+	openUntil time.Time
+	lastErr   error
+	lastSeen  time.Time
+}
+
+// available reports whether requests may be sent to the backend. Once the
+// cooldown has passed the breaker is half-open: requests go through and the
+// next failure reopens it.
+func (m *member) available(now time.Time) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return !now.Before(m.openUntil)
+}
+
+func (m *member) has(model string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.models[model]
+}
+
+func (m *member) hasLoaded(model string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.loaded[model]
+}
+
+func (m *member) load() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.inFlight
+}
+
+func (m *member) begin() {
+	m.mu.Lock()
+	m.inFlight++
+	m.mu.Unlock()
+}
+
+func (m *member) end() {
+	m.mu.Lock()
+	m.inFlight--
+	m.mu.Unlock()
+}
+
+func (m *member) success() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.failures = 0
+	m.openUntil = time.Time{}
+	m.lastErr = nil
+	m.lastSeen = time.Now()
+}
+
+func (m *member) failure(err error, health HealthConfig) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.failures++
+	m.lastErr = err
+	if m.failures >= max(health.FailureThreshold, 1) {
+		if m.openUntil.IsZero() || !time.Now().Before(m.openUntil) {
+			slog.Warn("Backend marked unhealthy", "backend", m.Name(), "failures", m.failures, "err", err)
+		}
+		m.openUntil = time.Now().Add(health.Cooldown)
+	}
+}
+
+func (m *member) setModels(models []Model) {
+	inventory := make(map[string]bool, len(models))
+	for _, model := range models {
+		inventory[NormalizeModelName(model.Name)] = true
+	}
+	m.mu.Lock()
+	m.models = inventory
+	m.mu.Unlock()
+}
+
+func (m *member) setLoaded(names []string) {
+	loaded := make(map[string]bool, len(names))
+	for _, name := range names {
+		loaded[NormalizeModelName(name)] = true
+	}
+	m.mu.Lock()
+	m.loaded = loaded
+	m.mu.Unlock()
+}
+
+// refresh lists the models of the backend, records them as its inventory
+// and feeds the outcome to its breaker. The models carry the capabilities
+// the backend declares for them.
+func (m *member) refresh(ctx context.Context, health HealthConfig) ([]Model, error) {
+	models, err := m.ListModels(ctx)
+	if err != nil {
+		if !errors.Is(ctx.Err(), context.Canceled) {
+			m.failure(err, health)
+		}
+		return nil, err
+	}
+
+	var caps []Capability
+	for _, c := range m.Capabilities() {
+		if modelCapabilities[c] {
+			caps = append(caps, c)
+		}
+	}
+	for i := range models {
+		models[i].Capabilities = caps
+	}
+
+	m.setModels(models)
+	m.success()
+	return models, nil
+}
+
+func (m *member) status(now time.Time) BackendStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	st := BackendStatus{
+		Name:         m.Name(),
+		Type:         m.cfg.Type,
+		URL:          m.cfg.URL,
+		Capabilities: m.Capabilities(),
+		Healthy:      !now.Before(m.openUntil),
+		Failures:     m.failures,
+		InFlight:     m.inFlight,
+		Models:       make([]string, 0, len(m.models)),
+		LastSeen:     m.lastSeen,
+	}
+	if st.Type == "" {
+		st.Type = "ollama"
+	}
+	for model := range m.models {
+		st.Models = append(st.Models, model)
+	}
+	sort.Strings(st.Models)
+	if m.lastErr != nil {
+		st.LastErr = m.lastErr.Error()
+	}
+	return st
+}
+
+// pool holds the configured backends. Backends keep their state across
+// reconfiguration as long as their configuration stays the same.
+type pool struct {
+	mu      sync.RWMutex
+	members []*member
+	health  HealthConfig
+	client  *http.Client
+}
+
+func newPool(cfg *Config, client *http.Client) *pool {
+	p := &pool{client: client}
+	p.configure(cfg)
+	return p
+}
+
+// backendConfigs returns the configured backends; without any, OllamaURL
+// is the only one.
+func backendConfigs(cfg *Config) []BackendConfig {
+	if len(cfg.Backends) > 0 {
+		return cfg.Backends
+	}
+	return []BackendConfig{{Name: "default", URL: cfg.OllamaURL}}
+}
+
+func (p *pool) configure(cfg *Config) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	existing := make(map[BackendConfig]*member, len(p.members))
+	for _, m := range p.members {
+		existing[m.cfg] = m
+	}
+
+	members := make([]*member, 0, len(cfg.Backends)+1)
+	for i, bc := range backendConfigs(cfg) {
+		if bc.Name == "" {
+			bc.Name = fmt.Sprintf("backend-%d", i)
+		}
+		if m, ok := existing[bc]; ok {
+			members = append(members, m)
+			continue
+		}
+		b, err := NewBackend(bc, p.client)
+		if err != nil {
+			slog.Error("Backend ignored", "backend", bc.Name, "err", err)
+			continue
+		}
+		members = append(members, &member{Backend: b, cfg: bc})
+	}
+	p.members = members
+	p.health = cfg.Health
+}
+
+func (p *pool) snapshot() ([]*member, HealthConfig) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return append([]*member(nil), p.members...), p.health
+}
+
+// candidates returns the available backends accepted by accept (nil
+// accepts all), in the order they should be tried for model: those with it
+// loaded in memory first, then those that have it installed, then the
+// others, each group by fewest requests in flight. An empty model means any
+// backend. When no configured backend is accepted at all, the error wraps
+// ErrUnsupported.
+func (p *pool) candidates(model string, accept func(Backend) bool) ([]*member, error) {
+	members, _ := p.snapshot()
+	model = NormalizeModelName(model)
+	now := time.Now()
+
+	type ranked struct {
+		m    *member
+		rank int
+		load int
+	}
+	var list []ranked
+	supported := false
+	for _, m := range members {
+		if accept != nil && !accept(m.Backend) {
+			continue
+		}
+		supported = true
+		if !m.available(now) {
+			continue
+		}
+		r := ranked{m: m, load: m.load()}
+		switch {
+		case model == "":
+		case m.hasLoaded(model):
+			r.rank = 2
+		case m.has(model):
+			r.rank = 1
+		}
+		list = append(list, r)
+	}
+	if !supported && len(members) > 0 {
+		return nil, fmt.Errorf("%w: no configured backend can handle the request", ErrUnsupported)
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		if list[i].rank != list[j].rank {
+			return list[i].rank > list[j].rank
+		}
+		return list[i].load < list[j].load
+	})
+
+	result := make([]*member, len(list))
+	for i, r := range list {
+		result[i] = r.m
+	}
+	return result, nil
+}
+
+// supports accepts backends that declare every capability in caps.
+func supports(caps ...Capability) func(Backend) bool {
+	return func(b Backend) bool {
+		for _, c := range caps {
+			if !hasCapability(b, c) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// managesModels accepts backends that implement ModelManager.
+func managesModels(b Backend) bool {
+	_, ok := b.(ModelManager)
+	return ok
+}
+
+// available returns the backends whose breaker is not open, in
+// configuration order.
+func (p *pool) available() []*member {
+	members, _ := p.snapshot()
+	now := time.Now()
+
+	var result []*member
+	for _, m := range members {
+		if m.available(now) {
+			result = append(result, m)
+		}
+	}
+	return result
+}
+
+// holding returns the available backends whose inventory lists model.
+func (p *pool) holding(model string) []*member {
+	members, _ := p.snapshot()
+	model = NormalizeModelName(model)
+	now := time.Now()
+
+	var result []*member
+	for _, m := range members {
+		if m.available(now) && m.has(model) {
+			result = append(result, m)
+		}
+	}
+	return result
+}
+
+func (p *pool) status() []BackendStatus {
+	members, _ := p.snapshot()
+	now := time.Now()
+	result := make([]BackendStatus, len(members))
+	for i, m := range members {
+		result[i] = m.status(now)
+	}
+	return result
+}
+
+// use runs fn against the best backend for model among those accepted,
+// failing over to the next one when a backend cannot be reached. The
+// backend counts the request as in flight while fn runs.
+func (p *pool) use(ctx context.Context, model string, accept func(Backend) bool, fn func(Backend) error) error {
+	candidates, err := p.candidates(model, accept)
+	if err != nil {
+		return err
+	}
+	if len(candidates) == 0 {
+		return ErrNoBackend
+	}
+	_, health := p.snapshot()
+
+	var lastErr error
+	for _, m := range candidates {
+		err := m.run(ctx, health, fn)
+		if !errors.Is(err, ErrUnreachable) {
+			return err
+		}
+		slog.Warn("Backend unreachable, trying next", "backend", m.Name(), "err", err)
+		lastErr = err
+	}
+	return fmt.Errorf("%w: %v", ErrNoBackend, lastErr)
+}
+
+// useEach runs fn against each of members in turn, stopping at the first
+// error.
+func (p *pool) useEach(ctx context.Context, members []*member, fn func(Backend) error) error {
+	_, health := p.snapshot()
+	for _, m := range members {
+		if err := m.run(ctx, health, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// run calls fn with the backend, counting the request as in flight and
+// feeding the outcome to the breaker.
+func (m *member) run(ctx context.Context, health HealthConfig, fn func(Backend) error) error {
+	m.begin()
+	err := fn(m.Backend)
+	m.end()
+	switch {
+	case errors.Is(err, ErrUnreachable):
+		m.failure(err, health)
+	case ctx.Err() == nil:
+		m.success()
+	}
+	return err
+}
+
+// Backends reports the state of every configured backend.
+func (e *Engine) Backends() []BackendStatus {
+	return e.pool.status()
+}
+
+// CheckBackends returns an error unless at least one backend is healthy. It
+// is meant to be registered as a monitoring health check.
+func (e *Engine) CheckBackends() error {
+	for _, st := range e.pool.status() {
+		if st.Healthy {
+			return nil
+		}
+	}
+	return ErrNoBackend
+}
+
+// RunHealthChecks lists the models of every backend, and those it has
+// loaded where it can tell, until ctx is canceled. This refreshes the
+// backends' inventories and feeds their circuit breakers.
+func (e *Engine) RunHealthChecks(ctx context.Context) {
+	for {
+		_, health := e.pool.snapshot()
+		e.probeBackends(ctx)
+
+		interval := health.Interval
+		if interval <= 0 {
+			interval = DefaultConfig().Health.Interval
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+func (e *Engine) probeBackends(ctx context.Context) {
+	members, health := e.pool.snapshot()
+
+	var wg sync.WaitGroup
+	for _, m := range members {
+		wg.Add(1)
+		go func(m *member) {
+			defer wg.Done()
+			pctx, cancel := ctx, context.CancelFunc(func() {})
+			if health.Timeout > 0 {
+				pctx, cancel = context.WithTimeout(ctx, health.Timeout)
+			}
+			defer cancel()
+			if _, err := m.refresh(pctx, health); err != nil {
+				return
+			}
+
+			// Which models sit in memory only affects ordering, so a
+			// failure here does not count against the backend.
+			lister, ok := m.Backend.(RunningLister)
+			if !ok {
+				return
+			}
+			names, err := lister.RunningModels(pctx)
+			if err != nil {
+				if ctx.Err() == nil {
+					slog.Warn("Failed to list running models", "backend", m.Name(), "err", err)
+				}
+				return
+			}
+			m.setLoaded(names)
+		}(m)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,179 @@
+package inference
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// backendStatus returns the status of the named backend.
+func backendStatus(t *testing.T, e *Engine, name string) BackendStatus {
+	t.Helper()
+	for _, st := range e.Backends() {
+		if st.Name == name {
+			return st
+		}
+	}
+	t.Fatalf("no backend %s", name)
+	return BackendStatus{}
+}
+
+// generate runs a request and returns the name of the backend that
+// answered it.
+func generate(e *Engine) (string, error) {
+	resp, err := e.Process(context.Background(), &Request{Model: "llama3"})
+	if err != nil {
+		return "", err
+	}
+	return resp.Response, nil
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	const cooldown = 100 * time.Millisecond
+	backend := newFakeOllama(t, "gpu-0", "llama3:latest")
+	e := newTestEngine(t, cooldown, backend)
+	ctx := context.Background()
+
+	expectHealthy := func(step string, healthy bool, failures int) {
+		t.Helper()
+		st := backendStatus(t, e, "gpu-0")
+		if st.Healthy != healthy || st.Failures != failures {
+			t.Errorf("%s: healthy %v with %d failures, want %v with %d", step, st.Healthy, st.Failures, healthy, failures)
+		}
+	}
+
+	// Failures below the threshold keep the backend in use.
+	backend.setDown(true)
+	e.probeBackends(ctx)
+	expectHealthy("first failure", true, 1)
+
+	// Reaching it opens the breaker: requests are not sent to the backend.
+	e.probeBackends(ctx)
+	expectHealthy("threshold", false, 2)
+	before := backend.count("/api/generate")
+	if _, err := generate(e); !errors.Is(err, ErrNoBackend) {
+		t.Errorf("err = %v, want ErrNoBackend", err)
+	}
+	if backend.count("/api/generate") != before {
+		t.Error("request sent to a backend with an open breaker")
+	}
+
+	// Recovering does not close the breaker before the cooldown.
+	backend.setDown(false)
+	if _, err := generate(e); !errors.Is(err, ErrNoBackend) {
+		t.Errorf("err = %v during cooldown, want ErrNoBackend", err)
+	}
+
+	// After the cooldown the backend is tried again, and a single failure
+	// reopens the breaker.
+	time.Sleep(cooldown)
+	backend.setDown(true)
+	before = backend.count("/api/generate")
+	if _, err := generate(e); !errors.Is(err, ErrNoBackend) {
+		t.Errorf("err = %v, want ErrNoBackend", err)
+	}
+	if backend.count("/api/generate") != before+1 {
+		t.Error("backend not re-probed after the cooldown")
+	}
+	expectHealthy("half-open failure", false, 3)
+
+	// A success after the next cooldown closes it.
+	time.Sleep(cooldown)
+	backend.setDown(false)
+	got, err := generate(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "gpu-0" {
+		t.Errorf("answered by %q, want gpu-0", got)
+	}
+	expectHealthy("recovered", true, 0)
+}
+
+func TestCircuitBreakerFlapping(t *testing.T) {
+	backend := newFakeOllama(t, "gpu-0", "llama3:latest")
+	e := newTestEngine(t, time.Minute, backend)
+	ctx := context.Background()
+
+	// A success resets the count, so alternating failures never reach the
+	// threshold.
+	for i := 0; i < 3; i++ {
+		backend.setDown(true)
+		e.probeBackends(ctx)
+		backend.setDown(false)
+		e.probeBackends(ctx)
+	}
+	if st := backendStatus(t, e, "gpu-0"); !st.Healthy || st.Failures != 0 {
+		t.Errorf("healthy %v with %d failures after flapping, want healthy", st.Healthy, st.Failures)
+	}
+
+	backend.setDown(true)
+	e.probeBackends(ctx)
+	e.probeBackends(ctx)
+	if st := backendStatus(t, e, "gpu-0"); st.Healthy {
+		t.Error("backend healthy after consecutive failures")
+	}
+}
+
+func TestFailover(t *testing.T) {
+	primary := newFakeOllama(t, "gpu-0", "llama3:latest")
+	secondary := newFakeOllama(t, "gpu-1", "llama3:latest")
+	e := newTestEngine(t, time.Minute, primary, secondary)
+	e.probeBackends(context.Background())
+
+	got, err := generate(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "gpu-0" {
+		t.Fatalf("answered by %q, want gpu-0", got)
+	}
+
+	// An unreachable backend fails over to the next one until its breaker
+	// opens, after which it is skipped.
+	primary.setDown(true)
+	for i := 0; i < 3; i++ {
+		got, err := generate(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != "gpu-1" {
+			t.Errorf("request %d answered by %q, want gpu-1", i+1, got)
+		}
+	}
+	if n := primary.count("/api/generate"); n != 3 {
+		t.Errorf("gpu-0 got %d generate requests, want 3: one served, two failed", n)
+	}
+	if backendStatus(t, e, "gpu-0").Healthy {
+		t.Error("unreachable backend still healthy")
+	}
+
+	// With the second one unreachable too, no backend is left.
+	secondary.setDown(true)
+	if _, err := generate(e); !errors.Is(err, ErrNoBackend) {
+		t.Errorf("err = %v, want ErrNoBackend", err)
+	}
+}
+
+func TestNoFailoverOnServerError(t *testing.T) {
+	primary := newFakeOllama(t, "gpu-0", "llama3:latest")
+	secondary := newFakeOllama(t, "gpu-1", "llama3:latest")
+	e := newTestEngine(t, time.Minute, primary, secondary)
+	e.probeBackends(context.Background())
+
+	// A backend that answers with an error is reachable: the error goes
+	// back to the caller and does not count against the backend.
+	primary.setFailing(true)
+	for i := 0; i < 3; i++ {
+		if _, err := generate(e); err == nil || errors.Is(err, ErrUnreachable) {
+			t.Fatalf("err = %v, want the backend's error", err)
+		}
+	}
+	if n := secondary.count("/api/generate"); n != 0 {
+		t.Errorf("gpu-1 got %d generate requests, want none", n)
+	}
+	if st := backendStatus(t, e, "gpu-0"); !st.Healthy || st.Failures != 0 {
+		t.Errorf("healthy %v with %d failures, want healthy", st.Healthy, st.Failures)
+	}
+}
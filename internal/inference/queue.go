@@ -0,0 +1,341 @@
+package inference
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrQueueFull is returned when a request cannot be admitted because the
+// queue is at capacity. The error is a *QueueFullError carrying a retry
+// hint.
+var ErrQueueFull = errors.New("server busy")
+
+type QueueFullError struct {
+	RetryAfter time.Duration
+}
+
+func (e *QueueFullError) Error() string {
+	return fmt.Sprintf("%v: request queue is full, retry in %s", ErrQueueFull, e.RetryAfter)
+}
+
+func (e *QueueFullError) Unwrap() error { return ErrQueueFull }
+
+// QueueConfig controls admission of generate, chat and embed requests.
+// Zero limits mean unlimited.
+type QueueConfig struct {
+	// MaxConcurrent caps the requests running against Ollama at once.
+	MaxConcurrent int `yaml:"max_concurrent"`
+	// MaxPerModel caps the requests running per model; ModelLimits
+	// overrides it for individual models keyed by "name" or "name:tag".
+	MaxPerModel int            `yaml:"max_per_model"`
+	ModelLimits map[string]int `yaml:"model_limits"`
+	// MaxQueued caps the requests waiting for a slot. Requests beyond it
+	// are rejected with ErrQueueFull.
+	MaxQueued int `yaml:"max_queued"`
+	// RetryAfter is the hint given to rejected clients.
+	RetryAfter time.Duration `yaml:"retry_after"`
+}
+
+// Priority classes. Waiting requests of a higher class are always admitted
+// before those of a lower one.
+type Priority int
+
+const (
+	PriorityLow Priority = iota
+	PriorityNormal
+	PriorityHigh
+)
+
+var priorityNames = []string{"low", "normal", "high"}
+
+func (p Priority) String() string {
+	if p < PriorityLow || p > PriorityHigh {
+		return "normal"
+	}
+	return priorityNames[p]
+}
+
+// ParsePriority accepts "low", "normal" or "high"; an empty string is
+// normal.
+func ParsePriority(s string) (Priority, error) {
+	if s == "" {
+		return PriorityNormal, nil
+	}
+	for i, name := range priorityNames {
+		if strings.EqualFold(s, name) {
+			return Priority(i), nil
+		}
+	}
+	return PriorityNormal, fmt.Errorf("%w: unknown priority %q", ErrInvalidRequest, s)
+}
+
+type priorityKey struct{}
+type clientKey struct{}
+
+// WithPriority sets the priority class of requests made with ctx.
+func WithPriority(ctx context.Context, p Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, p)
+}
+
+// WithClient identifies the caller for fair scheduling: waiting requests of
+// the same priority are admitted round-robin across clients.
+func WithClient(ctx context.Context, client string) context.Context {
+	return context.WithValue(ctx, clientKey{}, client)
+}
+
+// PriorityFromContext returns the priority class set with WithPriority,
+// defaulting to normal.
+func PriorityFromContext(ctx context.Context) Priority {
+	if p, ok := ctx.Value(priorityKey{}).(Priority); ok && p >= PriorityLow && p <= PriorityHigh {
+		return p
+	}
+	return PriorityNormal
+}
+
+func clientFromContext(ctx context.Context) string {
+	client, _ := ctx.Value(clientKey{}).(string)
+	return client
+}
+
+// scheduler admits requests under the configured concurrency limits.
+// Waiting requests are kept per priority class and, within a class, per
+// client; each class is served round-robin across its clients so that one
+// busy client cannot starve the others.
+type scheduler struct {
+	mu      sync.Mutex
+	cfg     QueueConfig
+	running int
+	byModel map[string]int
+	classes [PriorityHigh + 1]*class
+	queued  int
+	metrics Metrics
+}
+
+type class struct {
+	clients []*clientQueue
+	next    int
+}
+
+type clientQueue struct {
+	id      string
+	waiting []*waiter
+}
+
+type waiter struct {
+	model    string
+	priority Priority
+	enqueued time.Time
+	ready    chan struct{}
+	admitted bool
+}
+
+func newScheduler(cfg QueueConfig) *scheduler {
+	s := &scheduler{cfg: cfg, byModel: make(map[string]int)}
+	for i := range s.classes {
+		s.classes[i] = &class{}
+	}
+	return s
+}
+
+func (s *scheduler) configure(cfg QueueConfig) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cfg = cfg
+	s.dispatch()
+}
+
+func (s *scheduler) setMetrics(m Metrics) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.metrics = m
+}
+
+// acquire waits for a slot to run a request for model. The returned
+// function releases the slot and must be called exactly once.
+func (s *scheduler) acquire(ctx context.Context, model string) (func(), error) {
+	model = NormalizeModelName(model)
+	w := &waiter{
+		model:    model,
+		priority: PriorityFromContext(ctx),
+		enqueued: time.Now(),
+		ready:    make(chan struct{}),
+	}
+
+	s.mu.Lock()
+	if s.cfg.MaxQueued > 0 && s.queued >= s.cfg.MaxQueued && !s.canRun(model) {
+		retry := s.cfg.RetryAfter
+		s.mu.Unlock()
+		return nil, &QueueFullError{RetryAfter: retry}
+	}
+	s.enqueue(w, clientFromContext(ctx))
+	s.dispatch()
+	s.mu.Unlock()
+
+	release := func() { s.release(model) }
+
+	select {
+	case <-w.ready:
+		return release, nil
+	case <-ctx.Done():
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if w.admitted {
+		// Admitted while giving up: hand the slot back.
+		s.releaseLocked(model)
+		return nil, ctx.Err()
+	}
+	s.remove(w)
+	s.reportDepth(w.priority)
+	return nil, ctx.Err()
+}
+
+func (s *scheduler) release(model string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.releaseLocked(model)
+}
+
+func (s *scheduler) releaseLocked(model string) {
+	s.running--
+	if s.byModel[model]--; s.byModel[model] <= 0 {
+		delete(s.byModel, model)
+	}
+	s.dispatch()
+}
+
+// canRun reports whether a request for model fits the limits right now.
+// Callers hold s.mu.
+func (s *scheduler) canRun(model string) bool {
+	if s.cfg.MaxConcurrent > 0 && s.running >= s.cfg.MaxConcurrent {
+		return false
+	}
+	limit := s.modelLimit(model)
+	return limit <= 0 || s.byModel[model] < limit
+}
+
+func (s *scheduler) modelLimit(model string) int {
+	if limit, ok := s.cfg.ModelLimits[model]; ok {
+		return limit
+	}
+	if name, _, ok := strings.Cut(model, ":"); ok {
+		if limit, ok := s.cfg.ModelLimits[name]; ok {
+			return limit
+		}
+	}
+	return s.cfg.MaxPerModel
+}
+
+func (s *scheduler) enqueue(w *waiter, client string) {
+	c := s.classes[w.priority]
+	var q *clientQueue
+	for _, cq := range c.clients {
+		if cq.id == client {
+			q = cq
+			break
+		}
+	}
+	if q == nil {
+		q = &clientQueue{id: client}
+		c.clients = append(c.clients, q)
+	}
+	q.waiting = append(q.waiting, w)
+	s.queued++
+	s.reportDepth(w.priority)
+}
+
+func (s *scheduler) remove(w *waiter) {
+	c := s.classes[w.priority]
+	for ci, q := range c.clients {
+		for i, other := range q.waiting {
+			if other != w {
+				continue
+			}
+			q.waiting = append(q.waiting[:i], q.waiting[i+1:]...)
+			s.queued--
+			if len(q.waiting) == 0 {
+				c.dropClient(ci)
+			}
+			return
+		}
+	}
+}
+
+func (c *class) dropClient(i int) {
+	c.clients = append(c.clients[:i], c.clients[i+1:]...)
+	if c.next > i {
+		c.next--
+	}
+	if c.next >= len(c.clients) {
+		c.next = 0
+	}
+}
+
+// dispatch admits waiting requests while slots are free, highest priority
+// first and round-robin across clients within a priority. Within a client,
+// the oldest request whose model has a free slot goes first. Callers hold
+// s.mu.
+func (s *scheduler) dispatch() {
+	for p := PriorityHigh; p >= PriorityLow; p-- {
+		c := s.classes[p]
+		for admitted := true; admitted && len(c.clients) > 0; {
+			if s.cfg.MaxConcurrent > 0 && s.running >= s.cfg.MaxConcurrent {
+				return
+			}
+			admitted = false
+			for n := 0; n < len(c.clients); n++ {
+				ci := (c.next + n) % len(c.clients)
+				if s.admitFrom(c, ci) {
+					admitted = true
+					break
+				}
+			}
+		}
+		s.reportDepth(p)
+	}
+}
+
+// admitFrom admits the first runnable request of client ci, if any, and
+// moves the round-robin cursor past that client.
+func (s *scheduler) admitFrom(c *class, ci int) bool {
+	q := c.clients[ci]
+	for i, w := range q.waiting {
+		if !s.canRun(w.model) {
+			continue
+		}
+		q.waiting = append(q.waiting[:i], q.waiting[i+1:]...)
+		s.queued--
+		s.running++
+		s.byModel[w.model]++
+		w.admitted = true
+		close(w.ready)
+		if s.metrics != nil {
+			s.metrics.RecordQueueWait(w.priority.String(), time.Since(w.enqueued))
+		}
+
+		c.next = ci + 1
+		if len(q.waiting) == 0 {
+			c.dropClient(ci)
+		} else if c.next >= len(c.clients) {
+			c.next = 0
+		}
+		return true
+	}
+	return false
+}
+
+func (s *scheduler) reportDepth(p Priority) {
+	if s.metrics == nil {
+		return
+	}
+	depth := 0
+	for _, q := range s.classes[p].clients {
+		depth += len(q.waiting)
+	}
+	s.metrics.SetQueueDepth(p.String(), depth)
+}
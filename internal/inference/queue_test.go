@@ -0,0 +1,209 @@
+package inference
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// queuedRequest is a request waiting in the scheduler for a test.
+type queuedRequest struct {
+	label    string
+	client   string
+	priority Priority
+	model    string
+}
+
+// admissionOrder holds the only slot of a scheduler limited to one request
+// at a time, queues reqs in order and returns the order in which they are
+// admitted once the slot is released.
+func admissionOrder(t *testing.T, cfg QueueConfig, reqs []queuedRequest) []string {
+	t.Helper()
+	cfg.MaxConcurrent = 1
+	s := newScheduler(cfg)
+	release, err := s.acquire(context.Background(), "holder")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var order []string
+	var wg sync.WaitGroup
+	for i, r := range reqs {
+		ctx := WithPriority(WithClient(context.Background(), r.client), r.priority)
+		model := r.model
+		if model == "" {
+			model = "llama3"
+		}
+		wg.Add(1)
+		go func(label string) {
+			defer wg.Done()
+			done, err := s.acquire(ctx, model)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			mu.Lock()
+			order = append(order, label)
+			mu.Unlock()
+			done()
+		}(r.label)
+		// Queue the requests one after the other.
+		waitQueued(t, s, i+1)
+	}
+
+	release()
+	wg.Wait()
+	return order
+}
+
+func waitQueued(t *testing.T, s *scheduler, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		s.mu.Lock()
+		queued := s.queued
+		s.mu.Unlock()
+		if queued == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d requests queued, want %d", queued, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSchedulerOrder(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  QueueConfig
+		reqs []queuedRequest
+		want []string
+	}{
+		{
+			name: "priority classes",
+			reqs: []queuedRequest{
+				{label: "low", client: "a", priority: PriorityLow},
+				{label: "normal", client: "a", priority: PriorityNormal},
+				{label: "high", client: "a", priority: PriorityHigh},
+				{label: "normal-2", client: "b", priority: PriorityNormal},
+			},
+			want: []string{"high", "normal", "normal-2", "low"},
+		},
+		{
+			name: "round-robin across clients",
+			reqs: []queuedRequest{
+				{label: "a1", client: "a", priority: PriorityNormal},
+				{label: "a2", client: "a", priority: PriorityNormal},
+				{label: "a3", client: "a", priority: PriorityNormal},
+				{label: "b1", client: "b", priority: PriorityNormal},
+				{label: "b2", client: "b", priority: PriorityNormal},
+				{label: "c1", client: "c", priority: PriorityNormal},
+			},
+			want: []string{"a1", "b1", "c1", "a2", "b2", "a3"},
+		},
+		{
+			name: "a busy client does not delay a higher class",
+			reqs: []queuedRequest{
+				{label: "a1", client: "a", priority: PriorityLow},
+				{label: "a2", client: "a", priority: PriorityLow},
+				{label: "b1", client: "b", priority: PriorityHigh},
+			},
+			want: []string{"b1", "a1", "a2"},
+		},
+		{
+			name: "same client keeps its order",
+			reqs: []queuedRequest{
+				{label: "first", client: "a", priority: PriorityNormal},
+				{label: "second", client: "a", priority: PriorityNormal},
+				{label: "third", client: "a", priority: PriorityNormal},
+			},
+			want: []string{"first", "second", "third"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := admissionOrder(t, tt.cfg, tt.reqs); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("admitted %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSchedulerModelLimits(t *testing.T) {
+	s := newScheduler(QueueConfig{MaxConcurrent: 2, ModelLimits: map[string]int{"llama3": 1}})
+	ctx := context.Background()
+
+	release, err := s.acquire(ctx, "llama3")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A second llama3 request waits, but does not hold up another model.
+	waiting := make(chan func())
+	go func() {
+		done, err := s.acquire(ctx, "llama3:latest")
+		if err != nil {
+			t.Error(err)
+		}
+		waiting <- done
+	}()
+	waitQueued(t, s, 1)
+
+	other, err := s.acquire(ctx, "mistral")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other()
+
+	select {
+	case <-waiting:
+		t.Fatal("second llama3 request admitted beyond the model limit")
+	case <-time.After(20 * time.Millisecond):
+	}
+	release()
+	(<-waiting)()
+}
+
+func TestSchedulerQueueFull(t *testing.T) {
+	s := newScheduler(QueueConfig{MaxConcurrent: 1, MaxQueued: 1, RetryAfter: 7 * time.Second})
+	release, err := s.acquire(context.Background(), "llama3")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	queued := make(chan error)
+	go func() {
+		_, err := s.acquire(ctx, "llama3")
+		queued <- err
+	}()
+	waitQueued(t, s, 1)
+
+	_, err = s.acquire(context.Background(), "llama3")
+	var full *QueueFullError
+	if !errors.As(err, &full) || !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("err = %v, want a QueueFullError", err)
+	}
+	if full.RetryAfter != 7*time.Second {
+		t.Errorf("retry after %s, want 7s", full.RetryAfter)
+	}
+
+	// A waiter that gives up frees its place in the queue.
+	cancel()
+	if err := <-queued; !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+	waitQueued(t, s, 0)
+	release()
+
+	done, err := s.acquire(context.Background(), "llama3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done()
+}
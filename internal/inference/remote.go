@@ -0,0 +1,61 @@
+package inference
+
+import (
+	"context"
+	"fmt"
+)
+
+// RemoteExecutor runs requests on another node. It is implemented by
+// p2p.InferenceClient.
+type RemoteExecutor interface {
+	Process(ctx context.Context, peerID string, req *Request) (*Response, error)
+	ProcessStream(ctx context.Context, peerID string, req *Request, fn func(*Response) error) error
+	Chat(ctx context.Context, peerID string, req *ChatRequest) (*ChatResponse, error)
+	ChatStream(ctx context.Context, peerID string, req *ChatRequest, fn func(*ChatResponse) error) error
+	Embed(ctx context.Context, peerID string, req *EmbedRequest) (*EmbedResponse, error)
+}
+
+type peerKey struct{}
+
+// WithPeer returns a context that makes Engine.Process, Engine.Chat,
+// Engine.Embed and their streaming variants execute the request on the
+// given peer instead of the local backend.
+func WithPeer(ctx context.Context, peerID string) context.Context {
+	return context.WithValue(ctx, peerKey{}, peerID)
+}
+
+// PeerFromContext returns the peer selected with WithPeer, if any.
+func PeerFromContext(ctx context.Context) (string, bool) {
+	peerID, ok := ctx.Value(peerKey{}).(string)
+	return peerID, ok && peerID != ""
+}
+
+type connectedKey struct{}
+
+// WithConnected returns a context that makes the remote executor call fn
+// once the request has been handed to the peer.
+func WithConnected(ctx context.Context, fn func()) context.Context {
+	return context.WithValue(ctx, connectedKey{}, fn)
+}
+
+// Connected calls the function set with WithConnected, if any. Remote
+// executors call it after sending the request.
+func Connected(ctx context.Context) {
+	if fn, ok := ctx.Value(connectedKey{}).(func()); ok {
+		fn()
+	}
+}
+
+// SetRemote installs the executor used for requests bound to a peer. It
+// must be called before the engine starts serving requests.
+func (e *Engine) SetRemote(r RemoteExecutor) {
+	e.remote = r
+}
+
+// remoteExecutor returns the executor for requests bound to peerID.
+func (e *Engine) remoteExecutor(peerID string) (RemoteExecutor, error) {
+	if e.remote == nil {
+		return nil, fmt.Errorf("cannot run request on peer %s: no remote executor configured", peerID)
+	}
+	return e.remote, nil
+}
@@ -0,0 +1,48 @@
+package monitoring
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+)
+
+// logLevel is the minimum level of the handler installed by SetupLogging.
+var logLevel = new(slog.LevelVar)
+
+// SetupLogging makes the default slog logger, and with it the standard log
+// package, write to stderr at the given level. Messages written with the
+// log package are logged at info; failures are logged with slog.Warn or
+// slog.Error so that they survive a "warn" or "error" level.
+func SetupLogging(level string) error {
+	if err := SetLogLevel(level); err != nil {
+		return err
+	}
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: logLevel})))
+	return nil
+}
+
+// SetLogLevel changes the level of the logger installed by SetupLogging. It
+// is safe to call while other goroutines log.
+func SetLogLevel(level string) error {
+	l, err := ParseLogLevel(level)
+	if err != nil {
+		return err
+	}
+	logLevel.Set(l)
+	return nil
+}
+
+// ParseLogLevel parses one of debug, info, warn and error.
+func ParseLogLevel(level string) (slog.Level, error) {
+	switch level {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info":
+		return slog.LevelInfo, nil
+	case "warn":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return 0, fmt.Errorf("must be one of debug, info, warn, error, got %q", level)
+}
@@ -0,0 +1,41 @@
+package monitoring
+
+import (
+	"context"
+	"log/slog"
+	"testing"
+)
+
+func TestSetLogLevel(t *testing.T) {
+	t.Cleanup(func() { logLevel.Set(slog.LevelInfo) })
+	handler := slog.NewTextHandler(nil, &slog.HandlerOptions{Level: logLevel})
+
+	tests := []struct {
+		level   string
+		enabled []slog.Level
+		wantErr bool
+	}{
+		{level: "debug", enabled: []slog.Level{slog.LevelDebug, slog.LevelInfo, slog.LevelWarn, slog.LevelError}},
+		{level: "info", enabled: []slog.Level{slog.LevelInfo, slog.LevelWarn, slog.LevelError}},
+		{level: "warn", enabled: []slog.Level{slog.LevelWarn, slog.LevelError}},
+		{level: "error", enabled: []slog.Level{slog.LevelError}},
+		// An invalid level leaves the previous one in place.
+		{level: "verbose", enabled: []slog.Level{slog.LevelError}, wantErr: true},
+		{level: "INFO", enabled: []slog.Level{slog.LevelError}, wantErr: true},
+	}
+	for _, tt := range tests {
+		err := SetLogLevel(tt.level)
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("SetLogLevel(%q) err = %v, want error %v", tt.level, err, tt.wantErr)
+		}
+		for _, l := range []slog.Level{slog.LevelDebug, slog.LevelInfo, slog.LevelWarn, slog.LevelError} {
+			want := false
+			for _, e := range tt.enabled {
+				want = want || e == l
+			}
+			if got := handler.Enabled(context.Background(), l); got != want {
+				t.Errorf("after %q: %s enabled = %v, want %v", tt.level, l, got, want)
+			}
+		}
+	}
+}
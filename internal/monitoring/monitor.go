@@ -1,530 +1,444 @@
 package monitoring
 
 import (
-"context"
-"fmt"
-"log"
-"net/http"
-"runtime"
-"time"
-
-"github.com/prometheus/client_golang/prometheus"
-"github.com/prometheus/client_golang/prometheus/promhttp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 type Monitor struct {
-// Counters
-requestsTotal    *prometheus.CounterVec
-inferenceTotal   *prometheus.CounterVec
-peerConnections  prometheus.Counter
-modelLoads       prometheus.Counter
-errorsTotal      *prometheus.CounterVec
-
-// Histograms
-requestDuration  *prometheus.HistogramVec
-inferenceLatency *prometheus.HistogramVec
-modelLoadTime    prometheus.Histogram
-p2pLatency       prometheus.Histogram
-
-// Gauges
-activePeers      prometheus.Gauge
-activeModels     prometheus.Gauge
-memoryUsage      prometheus.Gauge
-cpuUsage         prometheus.Gauge
-goroutines       prometheus.Gauge
-
-// Health checks
-healthChecks map[string]HealthCheck
+	// Counters
+	requestsTotal   *prometheus.CounterVec
+	inferenceTotal  *prometheus.CounterVec
+	peerConnections prometheus.Counter
+	modelLoads      prometheus.Counter
+	errorsTotal     *prometheus.CounterVec
+
+	// Histograms
+	requestDuration  *prometheus.HistogramVec
+	inferenceLatency *prometheus.HistogramVec
+	modelLoadTime    prometheus.Histogram
+	p2pLatency       prometheus.Histogram
+
+	// Gauges
+	activePeers  prometheus.Gauge
+	activeModels prometheus.Gauge
+	memoryUsage  prometheus.Gauge
+	cpuUsage     prometheus.Gauge
+	goroutines   prometheus.Gauge
+	queueDepth   *prometheus.GaugeVec
+	queueWait    *prometheus.GaugeVec
+	certExpiry   prometheus.Gauge
+
+	// Health checks
+	healthChecks map[string]*HealthCheck
+	mu           sync.RWMutex
+
+	server   *http.Server
+	stop     chan struct{}
+	stopOnce sync.Once
+	draining atomic.Bool
 }
 
 type HealthCheck struct {
-Name     string
-Check    func() error
-Interval time.Duration
-Timeout  time.Duration
-Status   bool
-LastRun  time.Time
+	Name     string
+	Check    func() error
+	Interval time.Duration
+	Timeout  time.Duration
+	Status   bool
+	LastRun  time.Time
+	LastErr  error
 }
 
 type Metrics struct {
-RequestsTotal    int64
-InferenceTotal   int64
-ActivePeers      int64
-ActiveModels     int64
-AverageLatency   float64
-MemoryUsage      float64
-CPUUsage         float64
-Goroutines       int64
+	RequestsTotal  int64
+	InferenceTotal int64
+	ActivePeers    int64
+	ActiveModels   int64
+	AverageLatency float64
+	MemoryUsage    float64
+	CPUUsage       float64
+	Goroutines     int64
+	HealthStatus   map[string]bool
 }
 
 func NewMonitor() *Monitor {
-m := &Monitor{
-requestsTotal: prometheus.NewCounterVec(
-prometheus.CounterOpts{
-Name: "ollama_nova_requests_total",
-Help: "Total number of API requests",
-},
-[]string{"method", "endpoint", "status"},
-),
-inferenceTotal: prometheus.NewCounterVec(
-prometheus.CounterOpts{
-Name: "ollama_nova_inference_total",
-Help: "Total number of inference requests",
-},
-[]string{"model", "status"}
-cd /root/ollama-nova
-
-# Complete monitoring system with health checks
-cat > internal/monitoring/monitor.go << 'EOL'
-package monitoring
-
-import (
-"context"
-"fmt"
-"log"
-"net/http"
-"runtime"
-"sync"
-"time"
-
-"github.com/prometheus/client_golang/prometheus"
-"github.com/prometheus/client_golang/prometheus/promhttp"
-)
-
-type Monitor struct {
-// Counters
-requestsTotal    *prometheus.CounterVec
-inferenceTotal   *prometheus.CounterVec
-peerConnections  prometheus.Counter
-modelLoads       prometheus.Counter
-errorsTotal      *prometheus.CounterVec
-
-// Histograms
-requestDuration  *prometheus.HistogramVec
-inferenceLatency *prometheus.HistogramVec
-modelLoadTime    prometheus.Histogram
-p2pLatency       prometheus.Histogram
-
-// Gauges
-activePeers      prometheus.Gauge
-activeModels     prometheus.Gauge
-memoryUsage      prometheus.Gauge
-cpuUsage         prometheus.Gauge
-goroutines       prometheus.Gauge
-
-// Health checks
-healthChecks map[string]*HealthCheck
-mu           sync.RWMutex
+	m := &Monitor{
+		requestsTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "ollama_nova_requests_total",
+				Help: "Total number of API requests",
+			},
+			[]string{"method", "endpoint", "status"},
+		),
+		inferenceTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "ollama_nova_inference_total",
+				Help: "Total number of inference requests",
+			},
+			[]string{"model", "status"},
+		),
+		peerConnections: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "ollama_nova_peer_connections_total",
+				Help: "Total number of peer connections",
+			},
+		),
+		modelLoads: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "ollama_nova_model_loads_total",
+				Help: "Total number of model loads",
+			},
+		),
+		errorsTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "ollama_nova_errors_total",
+				Help: "Total number of errors",
+			},
+			[]string{"type", "component"},
+		),
+		requestDuration: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "ollama_nova_request_duration_seconds",
+				Help:    "Request duration in seconds",
+				Buckets: prometheus.DefBuckets,
+			},
+			[]string{"method", "endpoint"},
+		),
+		inferenceLatency: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "ollama_nova_inference_latency_seconds",
+				Help:    "Inference latency in seconds",
+				Buckets: prometheus.DefBuckets,
+			},
+			[]string{"model"},
+		),
+		modelLoadTime: prometheus.NewHistogram(
+			prometheus.HistogramOpts{
+				Name:    "ollama_nova_model_load_duration_seconds",
+				Help:    "Model load duration in seconds",
+				Buckets: prometheus.DefBuckets,
+			},
+		),
+		p2pLatency: prometheus.NewHistogram(
+			prometheus.HistogramOpts{
+				Name:    "ollama_nova_p2p_latency_seconds",
+				Help:    "P2P communication latency in seconds",
+				Buckets: prometheus.DefBuckets,
+			},
+		),
+		activePeers: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "ollama_nova_active_peers",
+				Help: "Number of active peers",
+			},
+		),
+		activeModels: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "ollama_nova_active_models",
+				Help: "Number of active models",
+			},
+		),
+		memoryUsage: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "ollama_nova_memory_usage_bytes",
+				Help: "Memory usage in bytes",
+			},
+		),
+		cpuUsage: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "ollama_nova_cpu_usage_percent",
+				Help: "CPU usage percentage",
+			},
+		),
+		goroutines: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "ollama_nova_goroutines",
+				Help: "Number of goroutines",
+			},
+		),
+		queueDepth: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "ollama_nova_queue_depth",
+				Help: "Number of inference requests waiting for a slot",
+			},
+			[]string{"priority"},
+		),
+		queueWait: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "ollama_nova_queue_wait_seconds",
+				Help: "Time the most recently admitted inference request spent queued",
+			},
+			[]string{"priority"},
+		),
+		certExpiry: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "ollama_nova_cert_expiry_seconds",
+				Help: "Time until the API's TLS certificate expires",
+			},
+		),
+		healthChecks: make(map[string]*HealthCheck),
+		stop:         make(chan struct{}),
+	}
+
+	// Register all metrics
+	prometheus.MustRegister(
+		m.requestsTotal, m.inferenceTotal, m.peerConnections, m.modelLoads, m.errorsTotal,
+		m.requestDuration, m.inferenceLatency, m.modelLoadTime, m.p2pLatency,
+		m.activePeers, m.activeModels, m.memoryUsage, m.cpuUsage, m.goroutines,
+		m.queueDepth, m.queueWait, m.certExpiry,
+	)
+
+	// Add default health checks; the Ollama check is registered by the
+	// caller, which knows the configured backends.
+	m.AddHealthCheck("memory", func() error {
+		var m runtime.MemStats
+		runtime.ReadMemStats(&m)
+		if m.Alloc > 1024*1024*1024 { // 1GB threshold
+			return fmt.Errorf("memory usage too high: %d bytes", m.Alloc)
+		}
+		return nil
+	}, 10*time.Second, 2*time.Second)
+
+	return m
 }
 
-type HealthCheck struct {
-Name     string
-Check    func() error
-Interval time.Duration
-Timeout  time.Duration
-Status   bool
-LastRun  time.Time
-LastErr  error
-}
-
-type Metrics struct {
-RequestsTotal    int64
-InferenceTotal   int64
-ActivePeers      int64
-ActiveModels     int64
-AverageLatency   float64
-MemoryUsage      float64
-CPUUsage         float64
-Goroutines       int64
-HealthStatus     map[string]bool
-}
+func (m *Monitor) AddHealthCheck(name string, check func() error, interval, timeout time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 
-func NewMonitor() *Monitor {
-m := &Monitor{
-requestsTotal: prometheus.NewCounterVec(
-prometheus.CounterOpts{
-Name: "ollama_nova_requests_total",
-Help: "Total number of API requests",
-},
-[]string{"method", "endpoint", "status"},
-),
-inferenceTotal: prometheus.NewCounterVec(
-prometheus.CounterOpts{
-Name: "ollama_nova_inference_total",
-Help: "Total number of inference requests",
-},
-[]string{"model", "status"},
-),
-peerConnections: prometheus.NewCounter(
-prometheus.CounterOpts{
-Name: "ollama_nova_peer_connections_total",
-Help: "Total number of peer connections",
-},
-),
-modelLoads: prometheus.NewCounter(
-prometheus.CounterOpts{
-Name: "ollama_nova_model_loads_total",
-Help: "Total number of model loads",
-},
-),
-errorsTotal: prometheus.NewCounterVec(
-prometheus.CounterOpts{
-Name: "ollama_nova_errors_total",
-Help: "Total number of errors",
-},
-[]string{"type", "component"},
-),
-requestDuration: prometheus.NewHistogramVec(
-prometheus.HistogramOpts{
-Name:    "ollama_nova_request_duration_seconds",
-Help:    "Request duration in seconds",
-Buckets: prometheus.DefBuckets,
-},
-[]string{"method", "endpoint"},
-),
-inferenceLatency: prometheus.NewHistogramVec(
-prometheus.HistogramOpts{
-Name:    "ollama_nova_inference_latency_seconds",
-Help:    "Inference latency in seconds",
-Buckets: prometheus.DefBuckets,
-},
-[]string{"model"},
-),
-modelLoadTime: prometheus.NewHistogram(
-prometheus.HistogramOpts{
-Name:    "ollama_nova_model_load_duration_seconds",
-Help:    "Model load duration in seconds",
-Buckets: prometheus.DefBuckets,
-},
-),
-p2pLatency: prometheus.NewHistogram(
-prometheus.HistogramOpts{
-Name:    "ollama_nova_p2p_latency_seconds",
-Help:    "P2P communication latency in seconds",
-Buckets: prometheus.DefBuckets,
-},
-),
-activePeers: prometheus.NewGauge(
-prometheus.GaugeOpts{
-Name: "ollama_nova_active_peers",
-Help: "Number of active peers",
-},
-),
-activeModels: prometheus.NewGauge(
-prometheus.GaugeOpts{
-Name: "ollama_nova_active_models",
-Help: "Number of active models",
-},
-),
-memoryUsage: prometheus.NewGauge(
-prometheus.GaugeOpts{
-Name: "ollama_nova_memory_usage_bytes",
-Help: "Memory usage in bytes",
-},
-),
-cpuUsage: prometheus.NewGauge(
-prometheus.GaugeOpts{
-Name: "ollama_nova_cpu_usage_percent",
-Help: "CPU usage percentage",
-},
-),
-goroutines: prometheus.NewGauge(
-prometheus.GaugeOpts{
-Name: "ollama_nova_goroutines",
-Help: "Number of goroutines",
-},
-),
-healthChecks: make(map[string]*HealthCheck),
+	m.healthChecks[name] = &HealthCheck{
+		Name:     name,
+		Check:    check,
+		Interval: interval,
+		Timeout:  timeout,
+		Status:   true,
+		LastRun:  time.Now(),
+	}
 }
 
-// Register all metrics
-prometheus.MustRegister(
-m.requestsTotal, m.inferenceTotal, m.peerConnections, m.modelLoads, m.errorsTotal,
-m.requestDuration, m.inferenceLatency, m.modelLoadTime, m.p2pLatency,
-m.activePeers, m.activeModels, m.memoryUsage, m.cpuUsage, m.goroutines,
-)
+func (m *Monitor) StartMetricsServer(port int) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/health", m.healthHandler)
+	mux.HandleFunc("/ready", m.readyHandler)
+	m.server = &http.Server{Addr: fmt.Sprintf(":%d", port), Handler: mux}
 
-// Add default health checks
-m.AddHealthCheck("ollama", func() error {
-// Check if Ollama is running
-client := &http.Client{Timeout: 5 * time.Second}
-_, err := client.Get("http://localhost:11434/api/tags")
-return err
-}, 30*time.Second, 5*time.Second)
-
-m.AddHealthCheck("memory", func() error {
-var m runtime.MemStats
-runtime.ReadMemStats(&m)
-if m.Alloc > 1024*1024*1024 { // 1GB threshold
-return fmt.Errorf("memory usage too high: %d bytes", m.Alloc)
-}
-return nil
-}, 10*time.Second, 2*time.Second)
+	go func() {
+		log.Printf("Starting metrics server on :%d", port)
+		if err := m.server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("Metrics server failed", "err", err)
+		}
+	}()
 
-return m
+	// Start health check loop
+	go m.runHealthChecks()
+	// Start system metrics collection
+	go m.collectSystemMetrics()
 }
 
-func (m *Monitor) AddHealthCheck(name string, check func() error, interval, timeout time.Duration) {
-m.mu.Lock()
-defer m.mu.Unlock()
-
-m.healthChecks[name] = &HealthCheck{
-Name:     name,
-Check:    check,
-Interval: interval,
-Timeout:  timeout,
-Status:   true,
-LastRun:  time.Now(),
-}
+// SetDraining makes /ready report the node as unavailable so load balancers
+// stop sending it new work during shutdown.
+func (m *Monitor) SetDraining() {
+	m.draining.Store(true)
 }
 
-func (m *Monitor) StartMetricsServer(port int) {
-http.Handle("/metrics", promhttp.Handler())
-http.HandleFunc("/health", m.healthHandler)
-http.HandleFunc("/ready", m.readyHandler)
-
-go func() {
-log.Printf("Starting metrics server on :%d", port)
-if err := http.ListenAndServe(fmt.Sprintf(":%d", port), nil); err != nil {
-log.Printf("Metrics server error: %v", err)
-}
-}()
-
-// Start health check loop
-go m.runHealthChecks()
-// Start system metrics collection
-go m.collectSystemMetrics()
+// Shutdown stops the background loops and the metrics server, waiting for
+// in-flight scrapes until ctx expires.
+func (m *Monitor) Shutdown(ctx context.Context) error {
+	m.stopOnce.Do(func() { close(m.stop) })
+	if m.server == nil {
+		return nil
+	}
+	return m.server.Shutdown(ctx)
 }
 
 func (m *Monitor) runHealthChecks() {
-ticker := time.NewTicker(10 * time.Second)
-defer ticker.Stop()
-
-for range ticker.C {
-m.mu.Lock()
-for name, check := range m.healthChecks {
-go func(name string, check *HealthCheck) {
-ctx, cancel := context.WithTimeout(context.Background(), check.Timeout)
-defer cancel()
-
-err := check.Check()
-m.mu.Lock()
-check.LastRun = time.Now()
-check.LastErr = err
-check.Status = (err == nil)
-m.mu.Unlock()
-
-if err != nil {
-m.errorsTotal.WithLabelValues("health_check", name).Inc()
-}
-}(name, check)
-}
-m.mu.Unlock()
-}
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-ticker.C:
+		}
+
+		m.mu.Lock()
+		for name, check := range m.healthChecks {
+			go func(name string, check *HealthCheck) {
+				ctx, cancel := context.WithTimeout(context.Background(), check.Timeout)
+				defer cancel()
+
+				done := make(chan error, 1)
+				go func() { done <- check.Check() }()
+
+				var err error
+				select {
+				case err = <-done:
+				case <-ctx.Done():
+					err = fmt.Errorf("health check %s timed out after %s", name, check.Timeout)
+				}
+
+				m.mu.Lock()
+				check.LastRun = time.Now()
+				check.LastErr = err
+				check.Status = (err == nil)
+				m.mu.Unlock()
+
+				if err != nil {
+					m.errorsTotal.WithLabelValues("health_check", name).Inc()
+				}
+			}(name, check)
+		}
+		m.mu.Unlock()
+	}
 }
 
 func (m *Monitor) collectSystemMetrics() {
-ticker := time.NewTicker(5 * time.Second)
-defer ticker.Stop()
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
 
-for range ticker.C {
-var memStats runtime.MemStats
-runtime.ReadMemStats(&memStats)
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-ticker.C:
+		}
 
-m.memoryUsage.Set(float64(memStats.Alloc))
-m.cpuUsage.Set(getCPUUsage())
-m.goroutines.Set(float64(runtime.NumGoroutine()))
-}
-}
+		var memStats runtime.MemStats
+		runtime.ReadMemStats(&memStats)
 
-func (m *Monitor) healthHandler(w http.ResponseWriter, r *http.Request) {
-m.mu.RLock()
-defer m.mu.RUnlock()
-
-allHealthy := true
-status := make(map[string]interface{})
-
-for name, check := range m.healthChecks {
-isHealthy := check.Status
-status[name] = map[string]interface{}{
-"status":  isHealthy,
-"lastRun": check.LastRun,
-"error":   nil,
-}
-if check.LastErr != nil {
-status[name].(map[string]interface{})["error"] = check.LastErr.Error()
-}
-if !isHealthy {
-allHealthy = false
-}
+		m.memoryUsage.Set(float64(memStats.Alloc))
+		m.cpuUsage.Set(getCPUUsage())
+		m.goroutines.Set(float64(runtime.NumGoroutine()))
+	}
 }
 
-if allHealthy {
-w.WriteHeader(http.StatusOK)
-} else {
-w.WriteHeader(http.StatusServiceUnavailable)
-}
-
-json.NewEncoder(w).Encode(map[string]interface{}{
-"status": allHealthy,
-"checks": status,
-})
+func (m *Monitor) healthHandler(w http.ResponseWriter, r *http.Request) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	allHealthy := true
+	status := make(map[string]interface{})
+
+	for name, check := range m.healthChecks {
+		isHealthy := check.Status
+		status[name] = map[string]interface{}{
+			"status":  isHealthy,
+			"lastRun": check.LastRun,
+			"error":   nil,
+		}
+		if check.LastErr != nil {
+			status[name].(map[string]interface{})["error"] = check.LastErr.Error()
+		}
+		if !isHealthy {
+			allHealthy = false
+		}
+	}
+
+	if allHealthy {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": allHealthy,
+		"checks": status,
+	})
 }
 
 func (m *Monitor) readyHandler(w http.ResponseWriter, r *http.Request) {
-// Simple readiness check
-w.WriteHeader(http.StatusOK)
-json.NewEncoder(w).Encode(map[string]interface{}{
-"status": "ready",
-"timestamp": time.Now(),
-})
+	if m.draining.Load() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":    "draining",
+			"timestamp": time.Now(),
+		})
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":    "ready",
+		"timestamp": time.Now(),
+	})
 }
 
 func (m *Monitor) GetMetrics() *Metrics {
-m.mu.RLock()
-defer m.mu.RUnlock()
-
-return &Metrics{
-RequestsTotal:  0, // Would need to read from counters
-InferenceTotal: 0,
-ActivePeers:    0,
-ActiveModels:   0,
-MemoryUsage:    0,
-CPUUsage:       0,
-Goroutines:     int64(runtime.NumGoroutine()),
-HealthStatus:   make(map[string]bool),
-}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return &Metrics{
+		RequestsTotal:  0, // Would need to read from counters
+		InferenceTotal: 0,
+		ActivePeers:    0,
+		ActiveModels:   0,
+		MemoryUsage:    0,
+		CPUUsage:       0,
+		Goroutines:     int64(runtime.NumGoroutine()),
+		HealthStatus:   make(map[string]bool),
+	}
 }
 
 func getCPUUsage() float64 {
-// Simplified CPU usage calculation
-return 0.0
+	// Simplified CPU usage calculation
+	return 0.0
 }
 
 // Helper functions for metrics collection
 func (m *Monitor) RecordRequest(method, endpoint, status string) {
-m.requestsTotal.WithLabelValues(method, endpoint, status).Inc()
+	m.requestsTotal.WithLabelValues(method, endpoint, status).Inc()
 }
 
 func (m *Monitor) RecordInference(model, status string) {
-m.inferenceTotal.WithLabelValues(model, status).Inc()
+	m.inferenceTotal.WithLabelValues(model, status).Inc()
 }
 
 func (m *Monitor) RecordPeerConnection() {
-m.peerConnections.Inc()
-m.activePeers.Inc()
+	m.peerConnections.Inc()
+	m.activePeers.Inc()
 }
 
 func (m *Monitor) RecordModelLoad(duration time.Duration) {
-m.modelLoads.Inc()
-m.modelLoadTime.Observe(duration.Seconds())
+	m.modelLoads.Inc()
+	m.modelLoadTime.Observe(duration.Seconds())
+}
+
+func (m *Monitor) SetQueueDepth(priority string, depth int) {
+	m.queueDepth.WithLabelValues(priority).Set(float64(depth))
+}
+
+func (m *Monitor) RecordQueueWait(priority string, wait time.Duration) {
+	m.queueWait.WithLabelValues(priority).Set(wait.Seconds())
+}
+
+func (m *Monitor) SetCertExpiry(remaining time.Duration) {
+	m.certExpiry.Set(remaining.Seconds())
+}
+
+// RecordP2PLatency observes the time a peer took to start answering a
+// request. Peers are not told apart, which would add a series per peer.
+func (m *Monitor) RecordP2PLatency(duration time.Duration) {
+	m.p2pLatency.Observe(duration.Seconds())
 }
 
 func (m *Monitor) SetActiveModels(count int) {
-m.activeModels.Set(float64(count))
+	m.activeModels.Set(float64(count))
 }
 
 func (m *Monitor) SetActivePeers(count int) {
-m.activePeers.Set(float64(count))
+	m.activePeers.Set(float64(count))
 }
-cd /root/ollama-nova
-
-# Complete Phase 1 documentation
-cat > docs/phase1.md << 'EOL'
-# Phase 1 MVP - Complete Foundation Implementation
-
-## Overview
-Phase 1 delivers a production-ready foundation for the ollama-nova decentralized P2P LLM inference platform, spanning 10 weeks of development.
-
-## Components Delivered
-
-### 1. Core Architecture
-- **Modular Go structure** with proper separation of concerns
-- **Configuration management** via YAML files
-- **Error handling** and logging throughout
-- **Graceful shutdown** handling
-
-### 2. Inference Engine
-- **Ollama-compatible API** integration
-- **Model management** (list, load, process)
-- **Request/response handling** with streaming support
-- **Error handling** and timeout management
-- **HTTP client** with proper configuration
-
-### 3. Security Layer
-- **TLS encryption** support
-- **Certificate generation** and management
-- **Peer validation** system
-- **RSA encryption/decryption** utilities
-- **Self-signed certificate** generation
-
-### 4. Monitoring System
-- **Prometheus metrics** collection
-- **Health check** endpoints (/health, /ready)
-- **System metrics** (memory, CPU, goroutines)
-- **Custom metrics** for inference and P2P
-- **Alerting** capabilities
-
-### 5. Configuration Management
-- **YAML-based** configuration
-- **Environment-specific** settings
-- **Hot-reload** support
-- **Validation** and defaults
-
-### 6. Docker Deployment
-- **Production-ready** Dockerfile
-- **Multi-stage** builds
-- **Security** best practices
-- **Health checks** integration
-
-## API Endpoints
-- `POST /api/generate` - Generate text
-- `GET /api/models` - List available models
-- `GET /health` - Health check
-- `GET /ready` - Readiness check
-- `GET /metrics` - Prometheus metrics
-
-## Configuration
-```yaml
-p2p:
-  port: 8080
-  bootstrap: ["/ip4/127.0.0.1/tcp/8080/p2p/QmPeer"]
-  max_peers: 50
-
-inference:
-  ollama_url: "http://localhost:11434"
-  max_tokens: 512
-  temperature: 0.7
-  top_p: 0.9
-  timeout: 30s
-
-security:
-  tls_enabled: false
-  cert_path: "/certs/server.crt"
-  key_path: "/certs/server.key"
-  ca_path: "/certs/ca.crt"
-
-monitoring:
-  metrics_port: 9090
-  log_level: "info"
-```
-
-## Usage
-```bash
-# Build and run
-go build -o ollama-nova cmd/novacron/main.go
-./ollama-nova
-
-# Docker
-docker build -t ollama-nova .
-docker run -p 8080:8080 -p 9090:9090 ollama-nova
-```
-
-## Testing
-- Unit tests for all components
-- Integration tests for API endpoints
-- Load testing capabilities
-- Security testing
-
-## Next Steps
-Phase 2 will focus on advanced P2P networking features including DHT-based peer discovery, NAT traversal, and reputation systems.
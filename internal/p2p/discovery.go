@@ -0,0 +1,293 @@
+package p2p
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/khryptorgraphics/ollama-nova/internal/inference"
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/multiformats/go-multihash"
+)
+
+// DefaultAdvertiseInterval is how often local models are re-announced.
+const DefaultAdvertiseInterval = 10 * time.Minute
+
+// provideTimeout bounds a single DHT provide operation.
+const provideTimeout = time.Minute
+
+// ModelKey returns the DHT key under which providers of a model are
+// announced. Models are announced both by name ("llama3:latest") and by
+// digest, so a lookup can ask for a specific build or for whatever a peer
+// serves under a name.
+func ModelKey(kind, value string) (cid.Cid, error) {
+	mh, err := multihash.Sum([]byte("/ollama-nova/model/"+kind+"/"+value), multihash.SHA2_256, -1)
+	if err != nil {
+		return cid.Undef, err
+	}
+	return cid.NewCidV1(cid.Raw, mh), nil
+}
+
+// Discovery publishes provider records for the models this node holds and
+// looks up peers that hold a given model, without any central registry.
+//
+// Kademlia has no way to delete a provider record. Withdrawing a model stops
+// re-announcing it and the existing records age out, so lookup results are
+// candidates that callers must be prepared to find stale.
+type Discovery struct {
+	dht      *dht.IpfsDHT
+	models   ModelLister
+	interval time.Duration
+
+	mu         sync.Mutex
+	advertised map[string]inference.Model
+	lookups    map[string]lookupEntry
+}
+
+type lookupEntry struct {
+	peers   []string
+	expires time.Time
+}
+
+const (
+	// lookupTTL is how long PeersWithModel reuses a DHT lookup that found
+	// providers, and negativeLookupTTL one that found none.
+	lookupTTL         = time.Minute
+	negativeLookupTTL = 10 * time.Second
+	// lookupTimeout bounds a single DHT lookup.
+	lookupTimeout = 10 * time.Second
+	// maxLookups bounds the number of cached lookups, since model names
+	// come from clients. Expired entries make room first, then the one
+	// closest to expiry.
+	maxLookups = 1024
+)
+
+func NewDiscovery(d *dht.IpfsDHT, models ModelLister, interval time.Duration) *Discovery {
+	if interval <= 0 {
+		interval = DefaultAdvertiseInterval
+	}
+	return &Discovery{
+		dht:        d,
+		models:     models,
+		interval:   interval,
+		advertised: make(map[string]inference.Model),
+		lookups:    make(map[string]lookupEntry),
+	}
+}
+
+// Run announces the local models immediately and then every interval until
+// ctx is canceled. Each round also drops models that are no longer present
+// locally.
+func (d *Discovery) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+
+	for {
+		d.reconcile(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (d *Discovery) reconcile(ctx context.Context) {
+	models, err := d.models.ListModels(ctx)
+	if err != nil {
+		slog.Warn("Model advertisement skipped", "err", err)
+		return
+	}
+
+	present := make(map[string]bool, len(models))
+	for _, m := range models {
+		present[inference.NormalizeModelName(m.Name)] = true
+		d.Advertise(ctx, m)
+	}
+
+	d.mu.Lock()
+	for name := range d.advertised {
+		if !present[name] {
+			delete(d.advertised, name)
+		}
+	}
+	d.mu.Unlock()
+}
+
+// Advertise publishes provider records for m and keeps re-announcing it on
+// every round until it is withdrawn. The name is announced in its
+// normalized form, which is what PeersWithModel looks up.
+func (d *Discovery) Advertise(ctx context.Context, m inference.Model) {
+	name := inference.NormalizeModelName(m.Name)
+	d.mu.Lock()
+	d.advertised[name] = m
+	d.mu.Unlock()
+
+	keys := map[string]string{"name": name}
+	if m.Digest != "" {
+		keys["digest"] = m.Digest
+	}
+	for kind, value := range keys {
+		key, err := ModelKey(kind, value)
+		if err != nil {
+			slog.Error("Failed to derive DHT key", "model", m.Name, "err", err)
+			continue
+		}
+
+		pctx, cancel := context.WithTimeout(ctx, provideTimeout)
+		err = d.dht.Provide(pctx, key, true)
+		cancel()
+		if err != nil {
+			slog.Warn("Failed to advertise model", "model", m.Name, "key", kind, "err", err)
+		}
+	}
+}
+
+// Withdraw stops advertising the named model.
+func (d *Discovery) Withdraw(name string) {
+	d.mu.Lock()
+	delete(d.advertised, inference.NormalizeModelName(name))
+	d.mu.Unlock()
+}
+
+// WithdrawAll stops advertising every model, e.g. on shutdown.
+func (d *Discovery) WithdrawAll() {
+	d.mu.Lock()
+	d.advertised = make(map[string]inference.Model)
+	d.mu.Unlock()
+}
+
+// ModelChanged keeps the advertisements in step with the engine's model
+// inventory. It is meant to be registered with Engine.OnModelChange and
+// does not block.
+func (d *Discovery) ModelChanged(ev inference.ModelEvent) {
+	if !ev.Loaded {
+		d.Withdraw(ev.Model.Name)
+		return
+	}
+	go d.Advertise(context.Background(), ev.Model)
+}
+
+// Advertised returns the names of the models currently being announced.
+func (d *Discovery) Advertised() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	names := make([]string, 0, len(d.advertised))
+	for name := range d.advertised {
+		names = append(names, name)
+	}
+	return names
+}
+
+// PeersWithModel returns the IDs of peers that announced the named model in
+// the DHT. The lookup is capped so that it does not walk the whole network,
+// and its result is reused for lookupTTL, or negativeLookupTTL if no peer
+// has the model.
+func (d *Discovery) PeersWithModel(ctx context.Context, model string) ([]string, error) {
+	model = inference.NormalizeModelName(model)
+
+	d.mu.Lock()
+	entry, ok := d.lookups[model]
+	d.mu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.peers, nil
+	}
+
+	lctx, cancel := context.WithTimeout(ctx, lookupTimeout)
+	defer cancel()
+
+	infos, err := d.FindProviders(lctx, model, "", 10)
+	// A lookup that ran out of time without finding anyone counts as a
+	// miss, unless it was the caller that gave up.
+	if err != nil && (ctx.Err() != nil || !errors.Is(err, context.DeadlineExceeded)) {
+		return nil, err
+	}
+	peers := make([]string, 0, len(infos))
+	for _, info := range infos {
+		d.dht.Host().Peerstore().AddAddrs(info.ID, info.Addrs, peerstore.TempAddrTTL)
+		peers = append(peers, info.ID.String())
+	}
+
+	ttl := lookupTTL
+	if len(peers) == 0 {
+		ttl = negativeLookupTTL
+	}
+	d.cacheLookup(model, lookupEntry{peers: peers, expires: time.Now().Add(ttl)})
+	return peers, nil
+}
+
+// cacheLookup stores the lookup result for model, evicting entries once
+// maxLookups are cached.
+func (d *Discovery) cacheLookup(model string, entry lookupEntry) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.lookups[model]; !ok && len(d.lookups) >= maxLookups {
+		now := time.Now()
+		var soonest string
+		for name, e := range d.lookups {
+			if !now.Before(e.expires) {
+				delete(d.lookups, name)
+				continue
+			}
+			if soonest == "" || e.expires.Before(d.lookups[soonest].expires) {
+				soonest = name
+			}
+		}
+		if len(d.lookups) >= maxLookups {
+			delete(d.lookups, soonest)
+		}
+	}
+	d.lookups[model] = entry
+}
+
+// FindProviders returns up to limit peers (all if limit is zero) that
+// announced the model, looked up by digest when one is given and by name
+// otherwise. The local node is never included.
+func (d *Discovery) FindProviders(ctx context.Context, name, digest string, limit int) ([]peer.AddrInfo, error) {
+	kind, value := "name", name
+	if digest != "" {
+		kind, value = "digest", digest
+	}
+	if value == "" {
+		return nil, fmt.Errorf("%w: model name or digest is required", inference.ErrInvalidRequest)
+	}
+
+	key, err := ModelKey(kind, value)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive DHT key: %w", err)
+	}
+
+	// Ask for one extra record since our own may be among the results.
+	count := 0
+	if limit > 0 {
+		count = limit + 1
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	self := d.dht.Host().ID()
+	var providers []peer.AddrInfo
+	for info := range d.dht.FindProvidersAsync(ctx, key, count) {
+		if info.ID == self {
+			continue
+		}
+		providers = append(providers, info)
+		if limit > 0 && len(providers) >= limit {
+			break
+		}
+	}
+
+	if len(providers) == 0 && ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	return providers, nil
+}
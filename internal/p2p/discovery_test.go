@@ -0,0 +1,160 @@
+package p2p
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/khryptorgraphics/ollama-nova/internal/inference"
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+)
+
+// testDiscoveries starts n connected DHT nodes on a mock network and
+// returns a Discovery for each.
+func testDiscoveries(t *testing.T, n int) []*Discovery {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	mn := mocknet.New()
+	t.Cleanup(func() { mn.Close() })
+
+	var nodes []*dht.IpfsDHT
+	for i := 0; i < n; i++ {
+		d, err := dht.New(ctx, genHost(t, mn), dht.Mode(dht.ModeServer), dht.DisableAutoRefresh())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { d.Close() })
+		nodes = append(nodes, d)
+	}
+	if err := mn.LinkAll(); err != nil {
+		t.Fatal(err)
+	}
+	if err := mn.ConnectAllButSelf(); err != nil {
+		t.Fatal(err)
+	}
+
+	discoveries := make([]*Discovery, n)
+	for i, d := range nodes {
+		d := d
+		eventually(t, "the DHT routing tables", func() bool { return d.RoutingTable().Size() == n-1 })
+		discoveries[i] = NewDiscovery(d, nil, time.Hour)
+	}
+	return discoveries
+}
+
+// eventually waits for cond to hold.
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestDiscoveryNormalizesNames(t *testing.T) {
+	nodes := testDiscoveries(t, 2)
+	provider, seeker := nodes[0], nodes[1]
+	ctx := context.Background()
+
+	provider.Advertise(ctx, inference.Model{Name: "llama3"})
+	if got := provider.Advertised(); !reflect.DeepEqual(got, []string{"llama3:latest"}) {
+		t.Errorf("advertised %v, want [llama3:latest]", got)
+	}
+
+	want := []string{provider.dht.Host().ID().String()}
+	for _, name := range []string{"llama3", "llama3:latest"} {
+		peers, err := seeker.PeersWithModel(ctx, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(peers, want) {
+			t.Errorf("PeersWithModel(%q) = %v, want %v", name, peers, want)
+		}
+	}
+
+	provider.Withdraw("llama3")
+	if got := provider.Advertised(); len(got) != 0 {
+		t.Errorf("advertised %v after withdrawing llama3", got)
+	}
+}
+
+func TestDiscoveryCachesMisses(t *testing.T) {
+	nodes := testDiscoveries(t, 2)
+	seeker := nodes[1]
+
+	peers, err := seeker.PeersWithModel(context.Background(), "unknown")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(peers) != 0 {
+		t.Fatalf("found %v for a model nobody has", peers)
+	}
+	seeker.mu.Lock()
+	entry, ok := seeker.lookups["unknown:latest"]
+	seeker.mu.Unlock()
+	if !ok {
+		t.Fatal("miss was not cached")
+	}
+	if ttl := time.Until(entry.expires); ttl > negativeLookupTTL {
+		t.Errorf("miss cached for %s, want at most %s", ttl, negativeLookupTTL)
+	}
+
+	// A caller that gives up does not leave a miss behind.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := seeker.PeersWithModel(ctx, "other"); err == nil {
+		t.Error("lookup with a canceled context succeeded")
+	}
+	seeker.mu.Lock()
+	_, ok = seeker.lookups["other:latest"]
+	seeker.mu.Unlock()
+	if ok {
+		t.Error("canceled lookup was cached")
+	}
+}
+
+func TestDiscoveryLookupCacheBounded(t *testing.T) {
+	d := &Discovery{lookups: make(map[string]lookupEntry)}
+	now := time.Now()
+
+	// Expired entries make room first.
+	for i := 0; i < maxLookups; i++ {
+		expires := now.Add(time.Minute)
+		if i%2 == 0 {
+			expires = now.Add(-time.Second)
+		}
+		d.cacheLookup(fmt.Sprintf("m%d", i), lookupEntry{expires: expires})
+	}
+	d.cacheLookup("new", lookupEntry{expires: now.Add(time.Minute)})
+	if len(d.lookups) != maxLookups/2+1 {
+		t.Errorf("%d entries cached, want %d", len(d.lookups), maxLookups/2+1)
+	}
+	for name, e := range d.lookups {
+		if !now.Before(e.expires) {
+			t.Fatalf("expired entry %s kept", name)
+		}
+	}
+
+	// Without expired entries, the one closest to expiry goes.
+	for i := 0; len(d.lookups) < maxLookups; i++ {
+		d.cacheLookup(fmt.Sprintf("n%d", i), lookupEntry{expires: now.Add(time.Hour)})
+	}
+	d.cacheLookup("soon", lookupEntry{expires: now.Add(time.Second)})
+	d.cacheLookup("last", lookupEntry{expires: now.Add(time.Hour)})
+	if len(d.lookups) != maxLookups {
+		t.Errorf("%d entries cached, want %d", len(d.lookups), maxLookups)
+	}
+	if _, ok := d.lookups["soon"]; ok {
+		t.Error("entry closest to expiry was kept")
+	}
+	if _, ok := d.lookups["last"]; !ok {
+		t.Error("new entry was not cached")
+	}
+}
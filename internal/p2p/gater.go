@@ -0,0 +1,413 @@
+package p2p
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/control"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/pnet"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// AttestProtocol lets a node ask a peer for its node certificate. The
+// requester opens a stream and reads the PEM certificate chain, leaf
+// first, that the peer serves its API with.
+const AttestProtocol = protocol.ID("/ollama-nova/attest/1.0.0")
+
+const (
+	// attestTimeout bounds fetching and checking a peer's certificate.
+	attestTimeout = 10 * time.Second
+	// attestTTL is how long a successful attestation is trusted before the
+	// certificate is checked again, so that revocations take effect on
+	// open connections.
+	attestTTL = 15 * time.Minute
+	// rejectBackoff is how long a peer whose certificate was rejected is
+	// refused before it may try again.
+	rejectBackoff = time.Minute
+	// maxChainSize bounds the certificate chain read from a peer.
+	maxChainSize = 64 << 10
+)
+
+// ErrPeerNotAllowed is returned for streams from peers that the gater does
+// not admit.
+var ErrPeerNotAllowed = errors.New("peer not allowed")
+
+// PeerValidator checks a certificate chain presented by a peer. It is
+// implemented by security.Manager.
+type PeerValidator interface {
+	ValidatePeer(peerID string, chain []*x509.Certificate) error
+}
+
+// CertificateSource provides the certificate the node attests with. It is
+// implemented by security.Manager.
+type CertificateSource interface {
+	GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error)
+}
+
+// Gater decides which peers the node connects to. It applies the peer
+// allowlist and denylist of the configuration and, with
+// require_certificates, admits peers only once their node certificate has
+// been validated. Its settings can be replaced while the node runs.
+type Gater struct {
+	mu        sync.Mutex
+	allow     map[peer.ID]bool
+	deny      map[peer.ID]bool
+	attest    bool
+	validator PeerValidator
+	source    CertificateSource
+	host      host.Host
+	results   map[peer.ID]*attestation
+}
+
+// attestation is the outcome of checking a peer's certificate; done is
+// closed once err is set. rejected tells a certificate that failed
+// validation from one that could not be fetched.
+type attestation struct {
+	done     chan struct{}
+	err      error
+	rejected bool
+	at       time.Time
+}
+
+// NewGater returns a gater applying cfg. Certificates are checked with
+// validator, and the node's own is taken from source; both may be nil
+// unless cfg.RequireCertificates is set.
+func NewGater(cfg Config, validator PeerValidator, source CertificateSource) (*Gater, error) {
+	g := &Gater{
+		validator: validator,
+		source:    source,
+		results:   make(map[peer.ID]*attestation),
+	}
+	if err := g.SetConfig(cfg); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// SetConfig replaces the peer lists and the attestation setting. Connected
+// peers that the new settings refuse are disconnected. While attestation
+// is on, the certificates of connected peers are checked again, which
+// applies a changed CA bundle or CRL to them.
+func (g *Gater) SetConfig(cfg Config) error {
+	allow, err := peerSet(cfg.AllowPeers)
+	if err != nil {
+		return fmt.Errorf("allow_peers: %w", err)
+	}
+	deny, err := peerSet(cfg.DenyPeers)
+	if err != nil {
+		return fmt.Errorf("deny_peers: %w", err)
+	}
+	if cfg.RequireCertificates && (g.validator == nil || g.source == nil) {
+		return errors.New("require_certificates needs a certificate validator")
+	}
+
+	g.mu.Lock()
+	g.allow, g.deny = allow, deny
+	g.attest = cfg.RequireCertificates
+	for p, a := range g.results {
+		if isClosed(a.done) && !a.rejected {
+			delete(g.results, p)
+		}
+	}
+	h := g.host
+	g.mu.Unlock()
+
+	if h == nil {
+		return nil
+	}
+	for _, p := range h.Network().Peers() {
+		if !g.permitted(p) {
+			log.Printf("Disconnecting peer %s: no longer allowed", p)
+			h.Network().ClosePeer(p)
+		} else if cfg.RequireCertificates {
+			go g.result(p)
+		}
+	}
+	return nil
+}
+
+// attach starts serving the node's certificate to peers and checking theirs
+// as they connect.
+func (g *Gater) attach(h host.Host) {
+	g.mu.Lock()
+	g.host = h
+	g.mu.Unlock()
+
+	h.SetStreamHandler(AttestProtocol, g.serveCertificate)
+	h.Network().Notify(&network.NotifyBundle{
+		ConnectedF: func(_ network.Network, c network.Conn) {
+			if g.attesting() {
+				go g.result(c.RemotePeer())
+			}
+		},
+		DisconnectedF: func(n network.Network, c network.Conn) {
+			p := c.RemotePeer()
+			if n.Connectedness(p) == network.Connected {
+				return
+			}
+			g.mu.Lock()
+			if a, ok := g.results[p]; ok && isClosed(a.done) && !a.rejected {
+				delete(g.results, p)
+			}
+			g.mu.Unlock()
+		},
+	})
+}
+
+func (g *Gater) attesting() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.attest
+}
+
+// permitted applies the peer lists and the rejection backoff.
+func (g *Gater) permitted(p peer.ID) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.deny[p] || (g.allow != nil && !g.allow[p]) {
+		return false
+	}
+	if a, ok := g.results[p]; ok && g.attest && isClosed(a.done) && a.rejected {
+		return time.Since(a.at) >= rejectBackoff
+	}
+	return true
+}
+
+// Authorize returns nil once p may use the node's protocols: it is
+// permitted and, if certificates are required, attested.
+func (g *Gater) Authorize(p peer.ID) error {
+	if !g.permitted(p) {
+		return fmt.Errorf("%w: %s", ErrPeerNotAllowed, p)
+	}
+	if !g.attesting() {
+		return nil
+	}
+	if err := g.result(p); err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrPeerNotAllowed, p, err)
+	}
+	return nil
+}
+
+// result waits for the attestation of p, starting one if there is none or
+// the last one is stale or failed.
+func (g *Gater) result(p peer.ID) error {
+	g.mu.Lock()
+	a, ok := g.results[p]
+	if !ok || (isClosed(a.done) && staleAttestation(a)) {
+		a = &attestation{done: make(chan struct{})}
+		g.results[p] = a
+		go g.check(p, a)
+	}
+	g.mu.Unlock()
+
+	<-a.done
+	return a.err
+}
+
+// staleAttestation reports whether a finished attestation needs to be done
+// again.
+func staleAttestation(a *attestation) bool {
+	switch {
+	case a.rejected:
+		return time.Since(a.at) >= rejectBackoff
+	case a.err != nil:
+		return true
+	default:
+		return time.Since(a.at) >= attestTTL
+	}
+}
+
+// check fetches and validates the certificate of p, disconnecting it on
+// failure.
+func (g *Gater) check(p peer.ID, a *attestation) {
+	g.mu.Lock()
+	validator := g.validator
+	g.mu.Unlock()
+
+	chain, err := g.fetchCertificate(p)
+	rejected := false
+	if err == nil {
+		err = validator.ValidatePeer(p.String(), chain)
+		rejected = err != nil
+	}
+
+	g.mu.Lock()
+	a.err, a.rejected, a.at = err, rejected, time.Now()
+	h := g.host
+	g.mu.Unlock()
+	close(a.done)
+
+	if err != nil {
+		slog.Warn("Peer failed attestation", "peer", p, "err", err)
+		if h != nil {
+			h.Network().ClosePeer(p)
+		}
+	}
+}
+
+// fetchCertificate asks p for its certificate chain.
+func (g *Gater) fetchCertificate(p peer.ID) ([]*x509.Certificate, error) {
+	g.mu.Lock()
+	h := g.host
+	g.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), attestTimeout)
+	defer cancel()
+	s, err := h.NewStream(network.WithAllowLimitedConn(ctx, "attest"), p, AttestProtocol)
+	if err != nil {
+		return nil, fmt.Errorf("failed to request certificate: %w", err)
+	}
+	defer s.Close()
+	s.SetDeadline(time.Now().Add(attestTimeout))
+
+	data, err := io.ReadAll(io.LimitReader(s, maxChainSize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read certificate: %w", err)
+	}
+	var chain []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid certificate: %w", err)
+		}
+		chain = append(chain, cert)
+	}
+	return chain, nil
+}
+
+// serveCertificate answers AttestProtocol requests with the node's
+// certificate chain.
+func (g *Gater) serveCertificate(s network.Stream) {
+	defer s.Close()
+	s.SetDeadline(time.Now().Add(attestTimeout))
+
+	g.mu.Lock()
+	source := g.source
+	g.mu.Unlock()
+	if source == nil {
+		s.Reset()
+		return
+	}
+	cert, err := source.GetCertificate(nil)
+	if err != nil {
+		slog.Error("Cannot attest to peer", "peer", s.Conn().RemotePeer(), "err", err)
+		s.Reset()
+		return
+	}
+	for _, der := range cert.Certificate {
+		if err := pem.Encode(s, &pem.Block{Type: "CERTIFICATE", Bytes: der}); err != nil {
+			return
+		}
+	}
+}
+
+// guard wraps a stream handler so that it only serves authorized peers.
+func (g *Gater) guard(next network.StreamHandler) network.StreamHandler {
+	return func(s network.Stream) {
+		if err := g.Authorize(s.Conn().RemotePeer()); err != nil {
+			slog.Warn("Refusing stream", "protocol", s.Protocol(), "err", err)
+			s.Reset()
+			return
+		}
+		next(s)
+	}
+}
+
+// InterceptPeerDial implements connmgr.ConnectionGater.
+func (g *Gater) InterceptPeerDial(p peer.ID) bool {
+	return g.permitted(p)
+}
+
+// InterceptAddrDial implements connmgr.ConnectionGater.
+func (g *Gater) InterceptAddrDial(p peer.ID, _ ma.Multiaddr) bool {
+	return g.permitted(p)
+}
+
+// InterceptAccept implements connmgr.ConnectionGater. The peer is not
+// known until the connection is secured.
+func (g *Gater) InterceptAccept(network.ConnMultiaddrs) bool {
+	return true
+}
+
+// InterceptSecured implements connmgr.ConnectionGater.
+func (g *Gater) InterceptSecured(_ network.Direction, p peer.ID, _ network.ConnMultiaddrs) bool {
+	return g.permitted(p)
+}
+
+// InterceptUpgraded implements connmgr.ConnectionGater.
+func (g *Gater) InterceptUpgraded(network.Conn) (bool, control.DisconnectReason) {
+	return true, 0
+}
+
+// guardedHost makes the stream handlers registered on it serve only peers
+// the gater authorizes.
+type guardedHost struct {
+	host.Host
+	gater *Gater
+}
+
+func (h *guardedHost) SetStreamHandler(pid protocol.ID, handler network.StreamHandler) {
+	h.Host.SetStreamHandler(pid, h.gater.guard(handler))
+}
+
+func (h *guardedHost) SetStreamHandlerMatch(pid protocol.ID, match func(protocol.ID) bool, handler network.StreamHandler) {
+	h.Host.SetStreamHandlerMatch(pid, match, h.gater.guard(handler))
+}
+
+// LoadSwarmKey reads the pre-shared key of a private network from a file in
+// the swarm.key format used by IPFS.
+func LoadSwarmKey(path string) (pnet.PSK, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read swarm key: %w", err)
+	}
+	defer f.Close()
+	psk, err := pnet.DecodeV1PSK(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse swarm key %s: %w", path, err)
+	}
+	return psk, nil
+}
+
+func peerSet(ids []string) (map[peer.ID]bool, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	set := make(map[peer.ID]bool, len(ids))
+	for _, s := range ids {
+		id, err := peer.Decode(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid peer ID %q: %w", s, err)
+		}
+		set[id] = true
+	}
+	return set, nil
+}
+
+func isClosed(ch chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,379 @@
+package p2p
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+)
+
+func testPeer(t *testing.T) peer.ID {
+	t.Helper()
+	key, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := peer.IDFromPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+// fakeValidator records the chains it is asked about and answers with err.
+type fakeValidator struct {
+	mu     sync.Mutex
+	err    error
+	peers  []string
+	chains [][]*x509.Certificate
+}
+
+func (v *fakeValidator) ValidatePeer(peerID string, chain []*x509.Certificate) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.peers = append(v.peers, peerID)
+	v.chains = append(v.chains, chain)
+	return v.err
+}
+
+func (v *fakeValidator) setErr(err error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.err = err
+}
+
+func (v *fakeValidator) calls() int {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return len(v.peers)
+}
+
+// fakeSource serves a fixed certificate, or fails when cert is nil.
+type fakeSource struct {
+	cert *tls.Certificate
+}
+
+func (s *fakeSource) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if s.cert == nil {
+		return nil, errors.New("no certificate")
+	}
+	return s.cert, nil
+}
+
+func testCertificate(t *testing.T) *tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "node"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// interceptors runs every connection gater check that names the peer.
+func interceptors(g *Gater, p peer.ID) map[string]bool {
+	return map[string]bool{
+		"InterceptPeerDial": g.InterceptPeerDial(p),
+		"InterceptAddrDial": g.InterceptAddrDial(p, nil),
+		"InterceptSecured":  g.InterceptSecured(network.DirInbound, p, nil),
+	}
+}
+
+func expectAdmitted(t *testing.T, g *Gater, p peer.ID, want bool) {
+	t.Helper()
+	for name, got := range interceptors(g, p) {
+		if got != want {
+			t.Errorf("%s(%s) = %v, want %v", name, p, got, want)
+		}
+	}
+}
+
+func TestGaterPeerLists(t *testing.T) {
+	a, b, c := testPeer(t), testPeer(t), testPeer(t)
+
+	tests := []struct {
+		name  string
+		allow []peer.ID
+		deny  []peer.ID
+		want  map[peer.ID]bool
+	}{
+		{"open", nil, nil, map[peer.ID]bool{a: true, b: true, c: true}},
+		{"allowlist", []peer.ID{a, b}, nil, map[peer.ID]bool{a: true, b: true, c: false}},
+		{"denylist", nil, []peer.ID{b}, map[peer.ID]bool{a: true, b: false, c: true}},
+		{"deny wins", []peer.ID{a, b}, []peer.ID{b}, map[peer.ID]bool{a: true, b: false, c: false}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g, err := NewGater(Config{AllowPeers: peerStrings(tt.allow), DenyPeers: peerStrings(tt.deny)}, nil, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for p, want := range tt.want {
+				expectAdmitted(t, g, p, want)
+				if err := g.Authorize(p); (err == nil) != want {
+					t.Errorf("Authorize(%s) = %v, want admitted %v", p, err, want)
+				}
+			}
+			if !g.InterceptAccept(nil) {
+				t.Error("InterceptAccept refused a connection before its peer is known")
+			}
+		})
+	}
+}
+
+func TestGaterConfigErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     Config
+		wantErr string
+	}{
+		{"invalid allowed peer", Config{AllowPeers: []string{"not-a-peer"}}, "allow_peers"},
+		{"invalid denied peer", Config{DenyPeers: []string{"not-a-peer"}}, "deny_peers"},
+		{"certificates without validator", Config{RequireCertificates: true}, "require_certificates"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewGater(tt.cfg, nil, nil)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("err = %v, want one about %s", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestGaterReload(t *testing.T) {
+	mn := mocknet.New()
+	t.Cleanup(func() { mn.Close() })
+	local, remote := genHost(t, mn), genHost(t, mn)
+	if err := mn.LinkAll(); err != nil {
+		t.Fatal(err)
+	}
+	g, err := NewGater(Config{}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.attach(local)
+	if _, err := mn.ConnectPeers(local.ID(), remote.ID()); err != nil {
+		t.Fatal(err)
+	}
+	other := testPeer(t)
+
+	// Narrowing the allowlist refuses, and disconnects, peers left out.
+	if err := g.SetConfig(Config{AllowPeers: peerStrings([]peer.ID{other})}); err != nil {
+		t.Fatal(err)
+	}
+	expectAdmitted(t, g, remote.ID(), false)
+	expectAdmitted(t, g, other, true)
+	if local.Network().Connectedness(remote.ID()) == network.Connected {
+		t.Error("peer no longer allowed is still connected")
+	}
+
+	// A failed reload keeps the lists in effect.
+	if err := g.SetConfig(Config{DenyPeers: []string{"not-a-peer"}}); err == nil {
+		t.Fatal("SetConfig accepted an invalid peer ID")
+	}
+	expectAdmitted(t, g, remote.ID(), false)
+
+	if err := g.SetConfig(Config{DenyPeers: peerStrings([]peer.ID{other})}); err != nil {
+		t.Fatal(err)
+	}
+	expectAdmitted(t, g, remote.ID(), true)
+	expectAdmitted(t, g, other, false)
+}
+
+// attestedPeer connects a node whose gater requires certificates to a
+// peer serving cert, and returns the node's gater and the peer's ID.
+func attestedPeer(t *testing.T, validator *fakeValidator, cert *tls.Certificate) (*Gater, peer.ID) {
+	t.Helper()
+	mn := mocknet.New()
+	t.Cleanup(func() { mn.Close() })
+	local, remote := genHost(t, mn), genHost(t, mn)
+	if err := mn.LinkAll(); err != nil {
+		t.Fatal(err)
+	}
+
+	g, err := NewGater(Config{RequireCertificates: true}, validator, &fakeSource{cert: testCertificate(t)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.attach(local)
+	remoteGater, err := NewGater(Config{}, nil, &fakeSource{cert: cert})
+	if err != nil {
+		t.Fatal(err)
+	}
+	remoteGater.attach(remote)
+
+	if _, err := mn.ConnectPeers(local.ID(), remote.ID()); err != nil {
+		t.Fatal(err)
+	}
+	return g, remote.ID()
+}
+
+func genHost(t *testing.T, mn mocknet.Mocknet) host.Host {
+	t.Helper()
+	h, err := mn.GenPeer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+func TestGaterAttestation(t *testing.T) {
+	t.Run("accepted", func(t *testing.T) {
+		validator := &fakeValidator{}
+		cert := testCertificate(t)
+		g, p := attestedPeer(t, validator, cert)
+
+		if err := g.Authorize(p); err != nil {
+			t.Fatal(err)
+		}
+		expectAdmitted(t, g, p, true)
+		validator.mu.Lock()
+		defer validator.mu.Unlock()
+		if len(validator.peers) != 1 || validator.peers[0] != p.String() {
+			t.Fatalf("validated peers %v, want [%s] once", validator.peers, p)
+		}
+		if chain := validator.chains[0]; len(chain) != 1 || !bytes.Equal(chain[0].Raw, cert.Certificate[0]) {
+			t.Error("validator did not get the certificate the peer served")
+		}
+	})
+
+	t.Run("rejected", func(t *testing.T) {
+		validator := &fakeValidator{err: errors.New("certificate revoked")}
+		g, p := attestedPeer(t, validator, testCertificate(t))
+
+		err := g.Authorize(p)
+		if !errors.Is(err, ErrPeerNotAllowed) || !strings.Contains(err.Error(), "revoked") {
+			t.Fatalf("err = %v, want ErrPeerNotAllowed with the validator's reason", err)
+		}
+		// A rejected peer may not reconnect until the backoff has passed.
+		expectAdmitted(t, g, p, false)
+		calls := validator.calls()
+		if err := g.Authorize(p); !errors.Is(err, ErrPeerNotAllowed) {
+			t.Errorf("err = %v, want ErrPeerNotAllowed", err)
+		}
+		if validator.calls() != calls {
+			t.Error("peer was attested again during the rejection backoff")
+		}
+	})
+
+	t.Run("no certificate", func(t *testing.T) {
+		validator := &fakeValidator{}
+		g, p := attestedPeer(t, validator, nil)
+
+		if err := g.Authorize(p); !errors.Is(err, ErrPeerNotAllowed) {
+			t.Fatalf("err = %v, want ErrPeerNotAllowed", err)
+		}
+		if validator.calls() != 0 {
+			t.Error("validator called without a certificate")
+		}
+		// A peer that could not be asked is not held to the backoff.
+		expectAdmitted(t, g, p, true)
+	})
+
+	t.Run("reload", func(t *testing.T) {
+		validator := &fakeValidator{}
+		g, p := attestedPeer(t, validator, testCertificate(t))
+		if err := g.Authorize(p); err != nil {
+			t.Fatal(err)
+		}
+
+		// With attestation off, peers are not checked.
+		validator.setErr(errors.New("untrusted"))
+		if err := g.SetConfig(Config{}); err != nil {
+			t.Fatal(err)
+		}
+		calls := validator.calls()
+		if err := g.Authorize(p); err != nil {
+			t.Errorf("err = %v with attestation off", err)
+		}
+		if validator.calls() != calls {
+			t.Error("peer attested with attestation off")
+		}
+
+		// Turning it back on checks the peer again, e.g. against a new CRL.
+		if err := g.SetConfig(Config{RequireCertificates: true}); err != nil {
+			t.Fatal(err)
+		}
+		if err := g.Authorize(p); !errors.Is(err, ErrPeerNotAllowed) {
+			t.Errorf("err = %v, want ErrPeerNotAllowed", err)
+		}
+		expectAdmitted(t, g, p, false)
+	})
+}
+
+func TestLoadSwarmKey(t *testing.T) {
+	hexKey := strings.Repeat("0f", 32)
+	tests := []struct {
+		name    string
+		content string
+		want    []byte
+		wantErr string
+	}{
+		{"base16", "/key/swarm/psk/1.0.0/\n/base16/\n" + hexKey + "\n", bytes.Repeat([]byte{0x0f}, 32), ""},
+		{"base64", "/key/swarm/psk/1.0.0/\n/base64/\n" + strings.Repeat("AAAA", 11) + "\n", make([]byte, 32), ""},
+		{"wrong header", "/key/swarm/psk/2.0.0/\n/base16/\n" + hexKey + "\n", nil, "failed to parse"},
+		{"unknown encoding", "/key/swarm/psk/1.0.0/\n/base32/\n" + hexKey + "\n", nil, "unknown encoding"},
+		{"short key", "/key/swarm/psk/1.0.0/\n/base16/\n0f0f\n", nil, "failed to parse"},
+		{"not hex", "/key/swarm/psk/1.0.0/\n/base16/\n" + strings.Repeat("zz", 32) + "\n", nil, "failed to parse"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "swarm.key")
+			if err := os.WriteFile(path, []byte(tt.content), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			psk, err := LoadSwarmKey(path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("err = %v, want one about %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(psk, tt.want) {
+				t.Errorf("key = %x, want %x", []byte(psk), tt.want)
+			}
+		})
+	}
+
+	if _, err := LoadSwarmKey(filepath.Join(t.TempDir(), "missing")); err == nil || !strings.Contains(err.Error(), "failed to read") {
+		t.Errorf("err = %v for a missing file, want a read error", err)
+	}
+}
+
+func peerStrings(ids []peer.ID) []string {
+	s := make([]string, len(ids))
+	for i, id := range ids {
+		s[i] = id.String()
+	}
+	return s
+}
@@ -0,0 +1,419 @@
+package p2p
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/khryptorgraphics/ollama-nova/internal/inference"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
+
+// InferenceProtocol carries generate, chat and embed requests between
+// nodes. The requester writes a single request frame and closes its write
+// side; the executor answers with either one response frame, or a sequence
+// of chunk frames ending with a done chunk, or an error frame. Frames are
+// newline-delimited JSON.
+const InferenceProtocol = protocol.ID("/ollama-nova/infer/1.0.0")
+
+const (
+	frameRequest  = "request"
+	frameResponse = "response"
+	frameChunk    = "chunk"
+	frameError    = "error"
+)
+
+// Error codes let the requester restore the engine's sentinel errors.
+const (
+	codeInvalidRequest = "invalid_request"
+	codeNotFound       = "not_found"
+	codeUnavailable    = "unavailable"
+	codeNoBackend      = "no_backend"
+	codeUnsupported    = "unsupported"
+)
+
+// maxRequestFrame bounds the size of an incoming request frame.
+const maxRequestFrame = 16 << 20
+
+// remoteRequestTimeout bounds how long a peer may occupy the local engine
+// with a single request.
+const remoteRequestTimeout = 10 * time.Minute
+
+type frame struct {
+	Type     string              `json:"type"`
+	Request  *inference.Request  `json:"request,omitempty"`
+	Response *inference.Response `json:"response,omitempty"`
+	// Chat and Embed take the place of Request for chat and embed
+	// requests, and are answered with ChatResponse and EmbedResponse.
+	Chat          *inference.ChatRequest   `json:"chat,omitempty"`
+	ChatResponse  *inference.ChatResponse  `json:"chat_response,omitempty"`
+	Embed         *inference.EmbedRequest  `json:"embed,omitempty"`
+	EmbedResponse *inference.EmbedResponse `json:"embed_response,omitempty"`
+	Error         string                   `json:"error,omitempty"`
+	Code          string                   `json:"code,omitempty"`
+	// Priority carries the requester's priority class into the executor's
+	// queue, which caps it at normal.
+	Priority string `json:"priority,omitempty"`
+}
+
+// Processor is implemented by inference.Engine.
+type Processor interface {
+	Process(ctx context.Context, req *inference.Request) (*inference.Response, error)
+	ProcessStream(ctx context.Context, req *inference.Request, fn func(*inference.Response) error) error
+	Chat(ctx context.Context, req *inference.ChatRequest) (*inference.ChatResponse, error)
+	ChatStream(ctx context.Context, req *inference.ChatRequest, fn func(*inference.ChatResponse) error) error
+	Embed(ctx context.Context, req *inference.EmbedRequest) (*inference.EmbedResponse, error)
+}
+
+// InferenceService executes InferenceProtocol requests from peers on the
+// local engine until it is shut down.
+type InferenceService struct {
+	host   host.Host
+	engine Processor
+
+	// ctx is the parent of every request context; cancel aborts requests
+	// still running when a shutdown deadline passes.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu      sync.Mutex
+	closing bool
+	active  sync.WaitGroup
+}
+
+// ServeInference executes InferenceProtocol requests from peers on the
+// local engine.
+func ServeInference(h host.Host, engine Processor) *InferenceService {
+	ctx, cancel := context.WithCancel(context.Background())
+	svc := &InferenceService{host: h, engine: engine, ctx: ctx, cancel: cancel}
+	h.SetStreamHandler(InferenceProtocol, svc.handle)
+	return svc
+}
+
+// Shutdown stops accepting requests and waits for the running ones to
+// finish. When ctx expires first, the running requests are canceled.
+func (svc *InferenceService) Shutdown(ctx context.Context) error {
+	svc.host.RemoveStreamHandler(InferenceProtocol)
+	svc.mu.Lock()
+	svc.closing = true
+	svc.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		svc.active.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		svc.cancel()
+		return nil
+	case <-ctx.Done():
+		svc.cancel()
+		return ctx.Err()
+	}
+}
+
+// begin registers a request, unless the service is shutting down.
+func (svc *InferenceService) begin() bool {
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+	if svc.closing {
+		return false
+	}
+	svc.active.Add(1)
+	return true
+}
+
+func (svc *InferenceService) handle(s network.Stream) {
+	if !svc.begin() {
+		s.Reset()
+		return
+	}
+	defer svc.active.Done()
+	defer s.Close()
+
+	remote := s.Conn().RemotePeer()
+	enc := json.NewEncoder(s)
+
+	var req frame
+	if err := json.NewDecoder(io.LimitReader(s, maxRequestFrame)).Decode(&req); err != nil || req.Type != frameRequest || requestCount(&req) != 1 {
+		slog.Warn("Invalid inference request", "peer", remote, "err", err)
+		enc.Encode(frame{Type: frameError, Error: "malformed request frame", Code: codeInvalidRequest})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(svc.ctx, remoteRequestTimeout)
+	defer cancel()
+	// Peers share the local queue fairly with API clients. They may lower
+	// the priority of their requests, but not raise it above normal.
+	ctx = inference.WithClient(ctx, "peer:"+remote.String())
+	if priority, err := inference.ParsePriority(req.Priority); err == nil && priority < inference.PriorityNormal {
+		ctx = inference.WithPriority(ctx, priority)
+	}
+
+	if err := svc.execute(ctx, &req, enc); err != nil {
+		slog.Warn("Inference request from peer failed", "peer", remote, "err", err)
+		enc.Encode(frame{Type: frameError, Error: err.Error(), Code: errorCode(err)})
+	}
+}
+
+// requestCount returns the number of requests a request frame carries,
+// which must be exactly one.
+func requestCount(f *frame) int {
+	n := 0
+	for _, set := range []bool{f.Request != nil, f.Chat != nil, f.Embed != nil} {
+		if set {
+			n++
+		}
+	}
+	return n
+}
+
+// execute runs the request of f on the local engine and writes the reply
+// frames to enc.
+func (svc *InferenceService) execute(ctx context.Context, f *frame, enc *json.Encoder) error {
+	switch {
+	case f.Chat != nil && f.Chat.Stream:
+		return svc.engine.ChatStream(ctx, f.Chat, func(resp *inference.ChatResponse) error {
+			return enc.Encode(frame{Type: frameChunk, ChatResponse: resp})
+		})
+	case f.Chat != nil:
+		resp, err := svc.engine.Chat(ctx, f.Chat)
+		if err != nil {
+			return err
+		}
+		return enc.Encode(frame{Type: frameResponse, ChatResponse: resp})
+	case f.Embed != nil:
+		resp, err := svc.engine.Embed(ctx, f.Embed)
+		if err != nil {
+			return err
+		}
+		return enc.Encode(frame{Type: frameResponse, EmbedResponse: resp})
+	case f.Request.Stream:
+		return svc.engine.ProcessStream(ctx, f.Request, func(resp *inference.Response) error {
+			return enc.Encode(frame{Type: frameChunk, Response: resp})
+		})
+	default:
+		resp, err := svc.engine.Process(ctx, f.Request)
+		if err != nil {
+			return err
+		}
+		return enc.Encode(frame{Type: frameResponse, Response: resp})
+	}
+}
+
+func errorCode(err error) string {
+	switch {
+	case errors.Is(err, inference.ErrInvalidRequest), errors.Is(err, inference.ErrInvalidOptions):
+		return codeInvalidRequest
+	case errors.Is(err, inference.ErrModelNotFound):
+		return codeNotFound
+	case errors.Is(err, inference.ErrQueueFull):
+		return codeUnavailable
+	case errors.Is(err, inference.ErrNoBackend):
+		return codeNoBackend
+	case errors.Is(err, inference.ErrUnsupported):
+		return codeUnsupported
+	}
+	return ""
+}
+
+// LatencyRecorder receives round-trip latencies to peers. It is
+// implemented by monitoring.Monitor.
+type LatencyRecorder interface {
+	RecordP2PLatency(duration time.Duration)
+}
+
+// InferenceClient runs requests on remote peers over InferenceProtocol. It
+// implements inference.RemoteExecutor.
+type InferenceClient struct {
+	host    host.Host
+	metrics LatencyRecorder
+}
+
+func NewInferenceClient(h host.Host) *InferenceClient {
+	return &InferenceClient{host: h}
+}
+
+// SetMetrics makes the client report the time to the first frame of every
+// remote request.
+func (c *InferenceClient) SetMetrics(m LatencyRecorder) {
+	c.metrics = m
+}
+
+func (c *InferenceClient) Process(ctx context.Context, peerID string, req *inference.Request) (*inference.Response, error) {
+	r := *req
+	r.Stream = false
+
+	var resp *inference.Response
+	err := c.roundTrip(ctx, peerID, &frame{Request: &r}, func(f *frame) (bool, error) {
+		if f.Type != frameResponse || f.Response == nil {
+			return true, fmt.Errorf("peer %s: unexpected %q frame", peerID, f.Type)
+		}
+		resp = f.Response
+		return true, nil
+	})
+	return resp, err
+}
+
+func (c *InferenceClient) ProcessStream(ctx context.Context, peerID string, req *inference.Request, fn func(*inference.Response) error) error {
+	r := *req
+	r.Stream = true
+
+	return c.roundTrip(ctx, peerID, &frame{Request: &r}, func(f *frame) (bool, error) {
+		if f.Type != frameChunk || f.Response == nil {
+			return true, fmt.Errorf("peer %s: unexpected %q frame", peerID, f.Type)
+		}
+		if err := fn(f.Response); err != nil {
+			return true, err
+		}
+		return f.Response.Done, nil
+	})
+}
+
+func (c *InferenceClient) Chat(ctx context.Context, peerID string, req *inference.ChatRequest) (*inference.ChatResponse, error) {
+	r := *req
+	r.Stream = false
+
+	var resp *inference.ChatResponse
+	err := c.roundTrip(ctx, peerID, &frame{Chat: &r}, func(f *frame) (bool, error) {
+		if f.Type != frameResponse || f.ChatResponse == nil {
+			return true, fmt.Errorf("peer %s: unexpected %q frame", peerID, f.Type)
+		}
+		resp = f.ChatResponse
+		return true, nil
+	})
+	return resp, err
+}
+
+func (c *InferenceClient) ChatStream(ctx context.Context, peerID string, req *inference.ChatRequest, fn func(*inference.ChatResponse) error) error {
+	r := *req
+	r.Stream = true
+
+	return c.roundTrip(ctx, peerID, &frame{Chat: &r}, func(f *frame) (bool, error) {
+		if f.Type != frameChunk || f.ChatResponse == nil {
+			return true, fmt.Errorf("peer %s: unexpected %q frame", peerID, f.Type)
+		}
+		if err := fn(f.ChatResponse); err != nil {
+			return true, err
+		}
+		return f.ChatResponse.Done, nil
+	})
+}
+
+func (c *InferenceClient) Embed(ctx context.Context, peerID string, req *inference.EmbedRequest) (*inference.EmbedResponse, error) {
+	var resp *inference.EmbedResponse
+	err := c.roundTrip(ctx, peerID, &frame{Embed: req}, func(f *frame) (bool, error) {
+		if f.Type != frameResponse || f.EmbedResponse == nil {
+			return true, fmt.Errorf("peer %s: unexpected %q frame", peerID, f.Type)
+		}
+		if n := len(f.EmbedResponse.Embeddings); n != len(req.Input) {
+			return true, fmt.Errorf("peer %s returned %d embeddings for %d inputs", peerID, n, len(req.Input))
+		}
+		resp = f.EmbedResponse
+		return true, nil
+	})
+	return resp, err
+}
+
+// roundTrip sends the request frame req to peerID and feeds every reply
+// frame to handle until it reports completion. Error frames are converted
+// to errors. Canceling ctx resets the stream, which aborts the request on
+// the remote side.
+func (c *InferenceClient) roundTrip(ctx context.Context, peerID string, req *frame, handle func(*frame) (bool, error)) error {
+	pid, err := peer.Decode(peerID)
+	if err != nil {
+		return fmt.Errorf("invalid peer ID %q: %w", peerID, err)
+	}
+
+	start := time.Now()
+	s, err := c.host.NewStream(ctx, pid, InferenceProtocol)
+	if err != nil {
+		return fmt.Errorf("failed to open inference stream to %s: %w", peerID, err)
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			s.Reset()
+		case <-done:
+		}
+	}()
+
+	req.Type = frameRequest
+	req.Priority = inference.PriorityFromContext(ctx).String()
+	if err := json.NewEncoder(s).Encode(req); err != nil {
+		s.Reset()
+		return fmt.Errorf("failed to send request to %s: %w", peerID, err)
+	}
+	if err := s.CloseWrite(); err != nil {
+		s.Reset()
+		return fmt.Errorf("failed to send request to %s: %w", peerID, err)
+	}
+	inference.Connected(ctx)
+
+	dec := json.NewDecoder(s)
+	first := true
+	for {
+		var f frame
+		if err := dec.Decode(&f); err != nil {
+			s.Reset()
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return ctxErr
+			}
+			if err == io.EOF {
+				return fmt.Errorf("peer %s closed the stream before completing the request", peerID)
+			}
+			return fmt.Errorf("failed to read frame from %s: %w", peerID, err)
+		}
+
+		if first {
+			first = false
+			if c.metrics != nil {
+				c.metrics.RecordP2PLatency(time.Since(start))
+			}
+		}
+
+		if f.Type == frameError {
+			s.Close()
+			return remoteError(peerID, &f)
+		}
+
+		finished, err := handle(&f)
+		if err != nil {
+			s.Reset()
+			return err
+		}
+		if finished {
+			s.Close()
+			return nil
+		}
+	}
+}
+
+func remoteError(peerID string, f *frame) error {
+	switch f.Code {
+	case codeInvalidRequest:
+		return fmt.Errorf("%w: peer %s: %s", inference.ErrInvalidRequest, peerID, f.Error)
+	case codeNotFound:
+		return fmt.Errorf("%w: peer %s: %s", inference.ErrModelNotFound, peerID, f.Error)
+	case codeUnavailable:
+		return fmt.Errorf("%w: peer %s: %s", inference.ErrQueueFull, peerID, f.Error)
+	case codeNoBackend:
+		return fmt.Errorf("%w: peer %s: %s", inference.ErrNoBackend, peerID, f.Error)
+	case codeUnsupported:
+		return fmt.Errorf("%w: peer %s: %s", inference.ErrUnsupported, peerID, f.Error)
+	}
+	return fmt.Errorf("peer %s: %s", peerID, f.Error)
+}
@@ -0,0 +1,184 @@
+package p2p
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/khryptorgraphics/ollama-nova/internal/inference"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
+
+// ModelsProtocol lets a node ask a peer which models it serves. The
+// requester opens a stream and reads a single JSON modelsMessage.
+const ModelsProtocol = protocol.ID("/ollama-nova/models/1.0.0")
+
+type modelsMessage struct {
+	Models []inference.Model `json:"models"`
+	Error  string            `json:"error,omitempty"`
+}
+
+// ModelLister is implemented by inference.Engine.
+type ModelLister interface {
+	ListModels(ctx context.Context) ([]inference.Model, error)
+}
+
+// ServeModels answers ModelsProtocol requests with the local model list.
+func ServeModels(h host.Host, lister ModelLister) {
+	h.SetStreamHandler(ModelsProtocol, func(s network.Stream) {
+		defer s.Close()
+		s.SetDeadline(time.Now().Add(10 * time.Second))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		var msg modelsMessage
+		models, err := lister.ListModels(ctx)
+		if err != nil {
+			msg.Error = err.Error()
+		} else {
+			msg.Models = models
+		}
+		if err := json.NewEncoder(s).Encode(&msg); err != nil {
+			slog.Warn("Failed to send model list", "peer", s.Conn().RemotePeer(), "err", err)
+		}
+	})
+}
+
+// Catalog collects the model lists of connected peers. Answers are cached
+// per peer so listing endpoints do not fan out to the whole swarm on every
+// call.
+type Catalog struct {
+	host    host.Host
+	ttl     time.Duration
+	timeout time.Duration
+
+	mu      sync.Mutex
+	entries map[peer.ID]catalogEntry
+}
+
+type catalogEntry struct {
+	models    []inference.Model
+	fetchedAt time.Time
+}
+
+func NewCatalog(h host.Host) *Catalog {
+	return &Catalog{
+		host:    h,
+		ttl:     30 * time.Second,
+		timeout: 5 * time.Second,
+		entries: make(map[peer.ID]catalogEntry),
+	}
+}
+
+// PeerModels returns the models reported by every connected peer that
+// speaks ModelsProtocol, keyed by peer ID.
+func (c *Catalog) PeerModels(ctx context.Context) map[string][]inference.Model {
+	var (
+		wg     sync.WaitGroup
+		result = make(map[string][]inference.Model)
+		resMu  sync.Mutex
+	)
+
+	for _, p := range c.host.Network().Peers() {
+		if !c.supportsModels(p) {
+			continue
+		}
+
+		c.mu.Lock()
+		entry, ok := c.entries[p]
+		c.mu.Unlock()
+		if ok && time.Since(entry.fetchedAt) < c.ttl {
+			if len(entry.models) > 0 {
+				result[p.String()] = entry.models
+			}
+			continue
+		}
+
+		wg.Add(1)
+		go func(p peer.ID) {
+			defer wg.Done()
+			models, err := c.fetch(ctx, p)
+			if err != nil {
+				slog.Warn("Failed to fetch models from peer", "peer", p, "err", err)
+			}
+
+			// Failures are cached too, so an unreachable peer is not
+			// retried on every call.
+			c.mu.Lock()
+			c.entries[p] = catalogEntry{models: models, fetchedAt: time.Now()}
+			c.mu.Unlock()
+
+			if len(models) > 0 {
+				resMu.Lock()
+				result[p.String()] = models
+				resMu.Unlock()
+			}
+		}(p)
+	}
+	wg.Wait()
+
+	c.prune()
+	return result
+}
+
+// PeersWithModel returns the connected peers that report the named model.
+func (c *Catalog) PeersWithModel(ctx context.Context, model string) ([]string, error) {
+	model = inference.NormalizeModelName(model)
+
+	var peers []string
+	for peerID, models := range c.PeerModels(ctx) {
+		for _, m := range models {
+			if inference.NormalizeModelName(m.Name) == model {
+				peers = append(peers, peerID)
+				break
+			}
+		}
+	}
+	return peers, nil
+}
+
+func (c *Catalog) supportsModels(p peer.ID) bool {
+	protos, err := c.host.Peerstore().SupportsProtocols(p, ModelsProtocol)
+	return err == nil && len(protos) > 0
+}
+
+func (c *Catalog) fetch(ctx context.Context, p peer.ID) ([]inference.Model, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	s, err := c.host.NewStream(ctx, p, ModelsProtocol)
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		s.SetDeadline(deadline)
+	}
+
+	var msg modelsMessage
+	if err := json.NewDecoder(s).Decode(&msg); err != nil {
+		return nil, err
+	}
+	if msg.Error != "" {
+		return nil, fmt.Errorf("peer reported error: %s", msg.Error)
+	}
+	return msg.Models, nil
+}
+
+// prune forgets peers that are no longer connected.
+func (c *Catalog) prune() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for p := range c.entries {
+		if c.host.Network().Connectedness(p) != network.Connected {
+			delete(c.entries, p)
+		}
+	}
+}
@@ -1,41 +1,118 @@
 package p2p
 
 import (
-"context"
-"fmt"
-"time"
-
-"github.com/libp2p/go-libp2p"
-"github.com/libp2p/go-libp2p-kad-dht"
-"github.com/libp2p/go-libp2p/core/host"
-"github.com/libp2p/go-libp2p/core/peer"
-"github.com/libp2p/go-libp2p/p2p/net/swarm"
-"github.com/multiformats/go-multiaddr"
-)
+	"context"
+	"fmt"
 
-func NewP2PNode(ctx context.Context, bootstrapPeers []string) (host.Host, *dht.IpfsDHT, error) {
-host, err := libp2p.New(
-libp2p.ListenAddrStrings("/ip4/0.0.0.0/tcp/0"),
-libp2p.EnableAutoRelay(),
-libp2p.EnableNATService(),
-libp2p.EnableHolePunching(),
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/net/connmgr"
 )
-if err != nil {
-return nil, nil, fmt.Errorf("failed to create host: %w", err)
-}
 
-dht, err := dht.New(ctx, host, dht.Mode(dht.ModeServer))
-if err != nil {
-return nil, nil, fmt.Errorf("failed to create DHT: %w", err)
+type Config struct {
+	// Port is the TCP port the node listens on; zero picks a free one.
+	Port int `yaml:"port"`
+	// Bootstrap lists peers to connect to on startup, as multiaddrs ending
+	// in /p2p/<peer ID>.
+	Bootstrap []string `yaml:"bootstrap"`
+	// MaxPeers is the number of connections above which the node starts
+	// pruning; zero leaves the libp2p default in place.
+	MaxPeers int `yaml:"max_peers"`
+	// KeyFile holds the node's private key, which fixes its peer ID across
+	// restarts; it is created on first start. Empty uses a new key, and
+	// peer ID, every time.
+	KeyFile string `yaml:"key_file"`
+	// SwarmKeyFile holds the pre-shared key of a private network, in the
+	// swarm.key format used by IPFS. Only nodes with the same key can
+	// connect. Empty joins the public network. The key is read once, when
+	// the host is created.
+	SwarmKeyFile string `yaml:"swarm_key_file"`
+	// AllowPeers, if not empty, lists the only peer IDs the node connects
+	// to.
+	AllowPeers []string `yaml:"allow_peers"`
+	// DenyPeers lists peer IDs the node never connects to.
+	DenyPeers []string `yaml:"deny_peers"`
+	// RequireCertificates admits peers only once they have presented a
+	// node certificate bound to their peer ID that security.ca_path
+	// trusts and no CRL revokes.
+	RequireCertificates bool `yaml:"require_certificates"`
 }
 
-// Bootstrap DHT
-for _, addr := range bootstrapPeers {
-peerInfo, _ := peer.AddrInfoFromString(addr)
-if peerInfo != nil {
-host.Connect(ctx, *peerInfo)
-}
+// DefaultConfig returns the settings used when the configuration file does
+// not provide any.
+func DefaultConfig() Config {
+	return Config{
+		Port:     4001,
+		MaxPeers: 50,
+	}
 }
 
-return host, dht, nil
+// NewP2PNode starts a libp2p host and its DHT. The gater decides which
+// peers may connect; the returned host only runs the stream handlers set on
+// it for peers the gater authorizes.
+func NewP2PNode(ctx context.Context, cfg Config, gater *Gater) (host.Host, *dht.IpfsDHT, error) {
+	var bootstrap []peer.AddrInfo
+	for _, addr := range cfg.Bootstrap {
+		if info, err := peer.AddrInfoFromString(addr); err == nil {
+			bootstrap = append(bootstrap, *info)
+		}
+	}
+
+	opts := []libp2p.Option{
+		libp2p.ListenAddrStrings(fmt.Sprintf("/ip4/0.0.0.0/tcp/%d", cfg.Port)),
+		libp2p.EnableNATService(),
+		libp2p.EnableHolePunching(),
+	}
+	if cfg.KeyFile != "" {
+		key, err := LoadIdentity(cfg.KeyFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		opts = append(opts, libp2p.Identity(key))
+	}
+	if cfg.SwarmKeyFile != "" {
+		psk, err := LoadSwarmKey(cfg.SwarmKeyFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		opts = append(opts, libp2p.PrivateNetwork(psk))
+	}
+	opts = append(opts, libp2p.ConnectionGater(gater))
+	// AutoRelay needs candidate relays; the bootstrap peers serve as such.
+	if len(bootstrap) > 0 {
+		opts = append(opts, libp2p.EnableAutoRelayWithStaticRelays(bootstrap))
+	}
+	if cfg.MaxPeers > 0 {
+		// Trim back to three quarters of the limit so pruning does not
+		// kick in again on the next connection.
+		mgr, err := connmgr.NewConnManager(cfg.MaxPeers*3/4, cfg.MaxPeers)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create connection manager: %w", err)
+		}
+		opts = append(opts, libp2p.ConnectionManager(mgr))
+	}
+
+	base, err := libp2p.New(opts...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create host: %w", err)
+	}
+	gater.attach(base)
+	host := &guardedHost{Host: base, gater: gater}
+
+	dht, err := dht.New(ctx, host, dht.Mode(dht.ModeServer))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create DHT: %w", err)
+	}
+
+	// Bootstrap DHT
+	for _, info := range bootstrap {
+		host.Connect(ctx, info)
+	}
+	if err := dht.Bootstrap(ctx); err != nil {
+		return nil, nil, fmt.Errorf("failed to bootstrap DHT: %w", err)
+	}
+
+	return host, dht, nil
 }
@@ -0,0 +1,139 @@
+package routing
+
+import (
+	"fmt"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// Candidate is a place a request can run: the local engine or a peer.
+type Candidate struct {
+	// PeerID is empty for the local engine.
+	PeerID string
+	// InFlight is the number of requests this node currently has running
+	// on the candidate.
+	InFlight int
+	// Latency is the moving average of the time the peer took to start
+	// answering this node's requests; zero for the local engine.
+	// HasLatency is false for peers that were never measured.
+	Latency    time.Duration
+	HasLatency bool
+}
+
+func (c Candidate) Local() bool {
+	return c.PeerID == ""
+}
+
+func (c Candidate) String() string {
+	if c.Local() {
+		return "local"
+	}
+	return "peer " + c.PeerID
+}
+
+// Policy orders the candidates for a request, best first. The router tries
+// them in that order until one succeeds.
+type Policy interface {
+	Name() string
+	Order(model string, candidates []Candidate) []Candidate
+}
+
+const (
+	PolicyLeastLoaded   = "least-loaded"
+	PolicyLowestLatency = "lowest-latency"
+	PolicyRoundRobin    = "round-robin"
+	PolicyLocalityFirst = "locality-first"
+)
+
+// DefaultPolicy is used when the configuration does not name one.
+const DefaultPolicy = PolicyLocalityFirst
+
+// NewPolicy returns the policy registered under name.
+func NewPolicy(name string) (Policy, error) {
+	switch name {
+	case PolicyLeastLoaded:
+		return leastLoaded{}, nil
+	case PolicyLowestLatency:
+		return lowestLatency{}, nil
+	case PolicyRoundRobin:
+		return &roundRobin{}, nil
+	case PolicyLocalityFirst, "":
+		return localityFirst{}, nil
+	}
+	return nil, fmt.Errorf("unknown routing policy %q", name)
+}
+
+// leastLoaded prefers the candidate with the fewest requests in flight,
+// keeping the local engine ahead on ties.
+type leastLoaded struct{}
+
+func (leastLoaded) Name() string { return PolicyLeastLoaded }
+
+func (leastLoaded) Order(_ string, candidates []Candidate) []Candidate {
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].InFlight != candidates[j].InFlight {
+			return candidates[i].InFlight < candidates[j].InFlight
+		}
+		return candidates[i].Local() && !candidates[j].Local()
+	})
+	return candidates
+}
+
+// lowestLatency prefers the candidate with the lowest observed latency. The
+// local engine counts as zero latency and unmeasured peers go last.
+type lowestLatency struct{}
+
+func (lowestLatency) Name() string { return PolicyLowestLatency }
+
+func (lowestLatency) Order(_ string, candidates []Candidate) []Candidate {
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return latencyLess(candidates[i], candidates[j])
+	})
+	return candidates
+}
+
+func latencyLess(a, b Candidate) bool {
+	known := func(c Candidate) bool { return c.Local() || c.HasLatency }
+	if known(a) != known(b) {
+		return known(a)
+	}
+	return a.Latency < b.Latency
+}
+
+// roundRobin rotates through the candidates on every request.
+type roundRobin struct {
+	next atomic.Uint64
+}
+
+func (*roundRobin) Name() string { return PolicyRoundRobin }
+
+func (p *roundRobin) Order(_ string, candidates []Candidate) []Candidate {
+	if len(candidates) == 0 {
+		return candidates
+	}
+	// Sort first so the rotation is stable across calls.
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].PeerID < candidates[j].PeerID
+	})
+	start := int((p.next.Add(1) - 1) % uint64(len(candidates)))
+	ordered := make([]Candidate, 0, len(candidates))
+	ordered = append(ordered, candidates[start:]...)
+	return append(ordered, candidates[:start]...)
+}
+
+// localityFirst runs locally whenever the local engine has the model and
+// otherwise falls back to peers by latency.
+type localityFirst struct{}
+
+func (localityFirst) Name() string { return PolicyLocalityFirst }
+
+func (localityFirst) Order(_ string, candidates []Candidate) []Candidate {
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].Local() != candidates[j].Local() {
+			return candidates[i].Local()
+		}
+		return latencyLess(candidates[i], candidates[j])
+	})
+	return candidates
+}
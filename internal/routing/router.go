@@ -0,0 +1,370 @@
+package routing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/khryptorgraphics/ollama-nova/internal/inference"
+)
+
+// Router decides where each generate, chat and embed request runs and
+// executes it there. *inference.Engine satisfies it by always running
+// locally.
+type Router interface {
+	Process(ctx context.Context, req *inference.Request) (*inference.Response, error)
+	ProcessStream(ctx context.Context, req *inference.Request, fn func(*inference.Response) error) error
+	Chat(ctx context.Context, req *inference.ChatRequest) (*inference.ChatResponse, error)
+	ChatStream(ctx context.Context, req *inference.ChatRequest, fn func(*inference.ChatResponse) error) error
+	Embed(ctx context.Context, req *inference.EmbedRequest) (*inference.EmbedResponse, error)
+}
+
+// PeerSource reports peers that can serve a model. It is implemented by
+// p2p.Catalog (connected peers) and p2p.Discovery (DHT providers).
+type PeerSource interface {
+	PeersWithModel(ctx context.Context, model string) ([]string, error)
+}
+
+type Config struct {
+	Policy string `yaml:"policy"`
+	// AttemptTimeout bounds how long a peer may take to produce its first
+	// chunk (or to accept the request, for non-streaming requests) before
+	// the router fails over to the next candidate. It also bounds the peer
+	// lookup for a request.
+	AttemptTimeout time.Duration `yaml:"attempt_timeout"`
+	// MaxAttempts caps the number of candidates tried per request.
+	MaxAttempts int `yaml:"max_attempts"`
+}
+
+const (
+	// latencyWeight is the weight of a new measurement in a peer's latency
+	// average.
+	latencyWeight = 0.2
+	// maxLatencyPeers bounds the number of peers whose latency is
+	// remembered. The peer measured longest ago makes room for a new one.
+	maxLatencyPeers = 1024
+)
+
+// PeerRouter routes requests between the local engine and peers according
+// to a Policy, failing over to the next candidate when one errors or times
+// out.
+type PeerRouter struct {
+	engine  *inference.Engine
+	sources []PeerSource
+
+	mu             sync.Mutex
+	policy         Policy
+	attemptTimeout time.Duration
+	maxAttempts    int
+	inFlight       map[string]int
+	latency        map[string]*peerLatency
+}
+
+// peerLatency is the moving average of the time a peer took to start
+// answering.
+type peerLatency struct {
+	mean    time.Duration
+	updated time.Time
+}
+
+// DefaultConfig returns the settings used for fields a Config leaves unset.
+func DefaultConfig() Config {
+	return Config{
+		Policy:         DefaultPolicy,
+		AttemptTimeout: 30 * time.Second,
+		MaxAttempts:    3,
+	}
+}
+
+func NewPeerRouter(engine *inference.Engine, cfg Config, sources ...PeerSource) (*PeerRouter, error) {
+	r := &PeerRouter{
+		engine:   engine,
+		sources:  sources,
+		inFlight: make(map[string]int),
+		latency:  make(map[string]*peerLatency),
+	}
+	if err := r.Configure(cfg); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Configure switches the policy and failover settings. It is safe to call
+// while requests are being routed.
+func (r *PeerRouter) Configure(cfg Config) error {
+	defaults := DefaultConfig()
+	if cfg.Policy == "" {
+		cfg.Policy = defaults.Policy
+	}
+	policy, err := NewPolicy(cfg.Policy)
+	if err != nil {
+		return err
+	}
+	if cfg.AttemptTimeout <= 0 {
+		cfg.AttemptTimeout = defaults.AttemptTimeout
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = defaults.MaxAttempts
+	}
+
+	r.mu.Lock()
+	r.policy = policy
+	r.attemptTimeout = cfg.AttemptTimeout
+	r.maxAttempts = cfg.MaxAttempts
+	r.mu.Unlock()
+	return nil
+}
+
+func (r *PeerRouter) Process(ctx context.Context, req *inference.Request) (*inference.Response, error) {
+	var resp *inference.Response
+	err := r.route(ctx, req.Model, false, func(ctx context.Context, _ func()) error {
+		var err error
+		resp, err = r.engine.Process(ctx, req)
+		return err
+	})
+	return resp, err
+}
+
+func (r *PeerRouter) ProcessStream(ctx context.Context, req *inference.Request, fn func(*inference.Response) error) error {
+	return r.route(ctx, req.Model, true, func(ctx context.Context, started func()) error {
+		return r.engine.ProcessStream(ctx, req, func(resp *inference.Response) error {
+			started()
+			return fn(resp)
+		})
+	})
+}
+
+func (r *PeerRouter) Chat(ctx context.Context, req *inference.ChatRequest) (*inference.ChatResponse, error) {
+	var resp *inference.ChatResponse
+	err := r.route(ctx, req.Model, false, func(ctx context.Context, _ func()) error {
+		var err error
+		resp, err = r.engine.Chat(ctx, req)
+		return err
+	})
+	return resp, err
+}
+
+func (r *PeerRouter) ChatStream(ctx context.Context, req *inference.ChatRequest, fn func(*inference.ChatResponse) error) error {
+	return r.route(ctx, req.Model, true, func(ctx context.Context, started func()) error {
+		return r.engine.ChatStream(ctx, req, func(resp *inference.ChatResponse) error {
+			started()
+			return fn(resp)
+		})
+	})
+}
+
+func (r *PeerRouter) Embed(ctx context.Context, req *inference.EmbedRequest) (*inference.EmbedResponse, error) {
+	var resp *inference.EmbedResponse
+	err := r.route(ctx, req.Model, false, func(ctx context.Context, _ func()) error {
+		var err error
+		resp, err = r.engine.Embed(ctx, req)
+		return err
+	})
+	return resp, err
+}
+
+// route runs the request on each candidate in policy order until one
+// succeeds. A streaming request is only retried if the failed attempt has
+// not delivered any output yet.
+func (r *PeerRouter) route(ctx context.Context, model string, stream bool, run func(ctx context.Context, started func()) error) error {
+	// A caller that already chose a peer bypasses routing.
+	if _, ok := inference.PeerFromContext(ctx); ok {
+		return run(ctx, func() {})
+	}
+
+	r.mu.Lock()
+	policy, timeout, maxAttempts := r.policy, r.attemptTimeout, r.maxAttempts
+	r.mu.Unlock()
+
+	// Under locality-first a local engine that has the model runs the
+	// request, so peers are only looked up once it has failed.
+	local := r.engine.HasModel(ctx, model)
+	deferPeers := local && policy.Name() == PolicyLocalityFirst
+	var candidates []Candidate
+	if deferPeers {
+		candidates = r.annotate([]Candidate{{}})
+	} else {
+		candidates = policy.Order(model, r.candidates(ctx, model, local, maxAttempts, timeout))
+	}
+
+	var lastErr error
+	tried := 0
+	for i := 0; i < len(candidates) && tried < maxAttempts; i++ {
+		c := candidates[i]
+		tried++
+		var produced bool
+		err := r.attempt(ctx, c, stream, timeout, func(actx context.Context, started func()) error {
+			return run(actx, func() {
+				produced = true
+				started()
+			})
+		})
+		if err == nil {
+			return nil
+		}
+		if produced || !retryable(ctx, err) {
+			return err
+		}
+		slog.Warn("Routing failed, trying next candidate", "model", model, "candidate", c, "err", err)
+		lastErr = err
+
+		if deferPeers {
+			deferPeers = false
+			peers := r.peerCandidates(ctx, model, maxAttempts-tried, timeout)
+			candidates = append(candidates, policy.Order(model, r.annotate(peers))...)
+		}
+	}
+
+	if tried == 1 {
+		return lastErr
+	}
+	return fmt.Errorf("all %d candidates for %s failed: %w", tried, model, lastErr)
+}
+
+// attempt runs the request on a single candidate. Remote attempts are bound
+// by timeout until their first output arrives, or for non-streaming
+// requests until the request reached the peer, and the time that took is
+// added to the peer's latency.
+func (r *PeerRouter) attempt(ctx context.Context, c Candidate, stream bool, timeout time.Duration, run func(context.Context, func()) error) error {
+	key := c.PeerID
+	r.mu.Lock()
+	r.inFlight[key]++
+	r.mu.Unlock()
+	defer func() {
+		r.mu.Lock()
+		r.inFlight[key]--
+		if r.inFlight[key] <= 0 {
+			delete(r.inFlight, key)
+		}
+		r.mu.Unlock()
+	}()
+
+	if c.Local() {
+		return run(ctx, func() {})
+	}
+
+	start := time.Now()
+	ctx, cancel := context.WithCancel(inference.WithPeer(ctx, c.PeerID))
+	defer cancel()
+	timer := time.AfterFunc(timeout, cancel)
+	defer timer.Stop()
+
+	if !stream {
+		// Once the peer has the request, only the caller's context limits
+		// how long it may take to answer.
+		ctx = inference.WithConnected(ctx, func() { timer.Stop() })
+		err := run(ctx, func() {})
+		if err == nil {
+			r.observeLatency(c.PeerID, time.Since(start))
+		}
+		return err
+	}
+
+	first := true
+	return run(ctx, func() {
+		if first {
+			first = false
+			timer.Stop()
+			r.observeLatency(c.PeerID, time.Since(start))
+		}
+	})
+}
+
+// observeLatency adds a measurement to the latency average of peerID.
+func (r *PeerRouter) observeLatency(peerID string, d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	if l, ok := r.latency[peerID]; ok {
+		l.mean += time.Duration(latencyWeight * float64(d-l.mean))
+		l.updated = now
+		return
+	}
+	if len(r.latency) >= maxLatencyPeers {
+		var oldest string
+		for p, l := range r.latency {
+			if oldest == "" || l.updated.Before(r.latency[oldest].updated) {
+				oldest = p
+			}
+		}
+		delete(r.latency, oldest)
+	}
+	r.latency[peerID] = &peerLatency{mean: d, updated: now}
+}
+
+// candidates lists the local engine (if local is set) and the peers
+// reported by the sources, annotated with load and latency.
+func (r *PeerRouter) candidates(ctx context.Context, model string, local bool, want int, timeout time.Duration) []Candidate {
+	var candidates []Candidate
+	if local {
+		candidates = append(candidates, Candidate{})
+	}
+	candidates = append(candidates, r.peerCandidates(ctx, model, want-len(candidates), timeout)...)
+
+	// Nobody claims the model: let the local backend answer, which yields
+	// a proper "model not found".
+	if len(candidates) == 0 {
+		candidates = append(candidates, Candidate{})
+	}
+	return r.annotate(candidates)
+}
+
+// peerCandidates asks the sources for peers serving model. Sources are
+// asked in order and later, more expensive ones are skipped once want
+// peers are known. The lookups together may take no longer than timeout
+// or what is left of the request's deadline.
+func (r *PeerRouter) peerCandidates(ctx context.Context, model string, want int, timeout time.Duration) []Candidate {
+	if want <= 0 || len(r.sources) == 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var candidates []Candidate
+	seen := make(map[string]bool)
+	for _, src := range r.sources {
+		if len(candidates) >= want || ctx.Err() != nil {
+			break
+		}
+		peers, err := src.PeersWithModel(ctx, model)
+		if err != nil {
+			slog.Warn("Peer lookup failed", "model", model, "err", err)
+			continue
+		}
+		for _, p := range peers {
+			if seen[p] {
+				continue
+			}
+			seen[p] = true
+			candidates = append(candidates, Candidate{PeerID: p})
+		}
+	}
+	return candidates
+}
+
+// annotate fills in the load and latency of candidates.
+func (r *PeerRouter) annotate(candidates []Candidate) []Candidate {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range candidates {
+		c := &candidates[i]
+		c.InFlight = r.inFlight[c.PeerID]
+		if l, ok := r.latency[c.PeerID]; ok && !c.Local() {
+			c.Latency, c.HasLatency = l.mean, true
+		}
+	}
+	return candidates
+}
+
+// retryable reports whether a failed attempt should move on to the next
+// candidate. Requests the client canceled and requests that are invalid
+// anywhere are not retried.
+func retryable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	return !errors.Is(err, inference.ErrInvalidRequest) && !errors.Is(err, inference.ErrInvalidOptions)
+}
@@ -0,0 +1,363 @@
+package routing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/khryptorgraphics/ollama-nova/internal/inference"
+)
+
+// fakePeer scripts how a peer answers.
+type fakePeer struct {
+	// connect makes the peer report that it received the request.
+	connect bool
+	// delay is how long the peer takes before its first chunk.
+	delay time.Duration
+	// chunks is the number of chunks streamed before err, or before the
+	// final chunk if err is nil.
+	chunks int
+	err    error
+}
+
+// fakeExecutor runs requests on scripted peers and records which peers
+// were asked.
+type fakeExecutor struct {
+	peers map[string]fakePeer
+
+	mu    sync.Mutex
+	calls []string
+}
+
+func (f *fakeExecutor) begin(ctx context.Context, peerID string) (fakePeer, error) {
+	f.mu.Lock()
+	f.calls = append(f.calls, peerID)
+	p := f.peers[peerID]
+	f.mu.Unlock()
+	if p.connect {
+		inference.Connected(ctx)
+	}
+	select {
+	case <-time.After(p.delay):
+		return p, nil
+	case <-ctx.Done():
+		return p, ctx.Err()
+	}
+}
+
+func (f *fakeExecutor) called() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.calls...)
+}
+
+func (f *fakeExecutor) Process(ctx context.Context, peerID string, req *inference.Request) (*inference.Response, error) {
+	p, err := f.begin(ctx, peerID)
+	if err != nil {
+		return nil, err
+	}
+	if p.err != nil {
+		return nil, p.err
+	}
+	return &inference.Response{Model: req.Model, Response: peerID, Done: true}, nil
+}
+
+func (f *fakeExecutor) ProcessStream(ctx context.Context, peerID string, req *inference.Request, fn func(*inference.Response) error) error {
+	p, err := f.begin(ctx, peerID)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < p.chunks; i++ {
+		if err := fn(&inference.Response{Model: req.Model, Response: peerID}); err != nil {
+			return err
+		}
+	}
+	if p.err != nil {
+		return p.err
+	}
+	return fn(&inference.Response{Model: req.Model, Response: peerID, Done: true})
+}
+
+func (f *fakeExecutor) Chat(context.Context, string, *inference.ChatRequest) (*inference.ChatResponse, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (f *fakeExecutor) ChatStream(context.Context, string, *inference.ChatRequest, func(*inference.ChatResponse) error) error {
+	return errors.New("not implemented")
+}
+
+func (f *fakeExecutor) Embed(context.Context, string, *inference.EmbedRequest) (*inference.EmbedResponse, error) {
+	return nil, errors.New("not implemented")
+}
+
+// fakeSource reports a fixed list of peers, or blocks until the lookup is
+// canceled if block is set.
+type fakeSource struct {
+	peers []string
+	block bool
+
+	mu      sync.Mutex
+	lookups int
+}
+
+func (s *fakeSource) PeersWithModel(ctx context.Context, model string) ([]string, error) {
+	s.mu.Lock()
+	s.lookups++
+	s.mu.Unlock()
+	if s.block {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	return s.peers, nil
+}
+
+func (s *fakeSource) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lookups
+}
+
+// newLocalBackend starts an Ollama stand-in holding models. If failing,
+// it answers generate requests with an error.
+func newLocalBackend(t *testing.T, failing bool, models ...string) string {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/tags":
+			list := make([]inference.Model, len(models))
+			for i, name := range models {
+				list[i] = inference.Model{Name: name, Model: name}
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"models": list})
+		case "/api/generate":
+			if failing {
+				http.Error(w, `{"error":"out of memory"}`, http.StatusInternalServerError)
+				return
+			}
+			json.NewEncoder(w).Encode(inference.Response{Response: "local", Done: true})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+// newTestRouter returns a router over a local engine backed by backendURL
+// and the given peers.
+func newTestRouter(t *testing.T, cfg Config, backendURL string, exec *fakeExecutor, sources ...PeerSource) *PeerRouter {
+	t.Helper()
+	ecfg := inference.DefaultConfig()
+	ecfg.Backends = []inference.BackendConfig{{Name: "local", URL: backendURL}}
+	ecfg.Timeout = 5 * time.Second
+	engine := inference.NewEngine()
+	engine.SetConfig(ecfg)
+	engine.SetRemote(exec)
+	r, err := NewPeerRouter(engine, cfg, sources...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestRouterFailover(t *testing.T) {
+	errBusy := errors.New("peer busy")
+	unreachable := fmt.Errorf("%w: connection refused", inference.ErrUnreachable)
+
+	tests := []struct {
+		name    string
+		stream  bool
+		peers   map[string]fakePeer
+		want    string // peer that answers; empty if the request fails
+		calls   []string
+		wantErr error
+	}{
+		{
+			name:  "first peer answers",
+			peers: map[string]fakePeer{"a": {}, "b": {}},
+			want:  "a",
+			calls: []string{"a"},
+		},
+		{
+			name:  "failed peer is skipped",
+			peers: map[string]fakePeer{"a": {err: errBusy}, "b": {}},
+			want:  "b",
+			calls: []string{"a", "b"},
+		},
+		{
+			name:    "all peers unreachable",
+			peers:   map[string]fakePeer{"a": {err: unreachable}, "b": {err: unreachable}, "c": {err: unreachable}},
+			calls:   []string{"a", "b", "c"},
+			wantErr: inference.ErrUnreachable,
+		},
+		{
+			name:    "invalid request is not retried",
+			peers:   map[string]fakePeer{"a": {err: fmt.Errorf("%w: bad prompt", inference.ErrInvalidRequest)}, "b": {}},
+			calls:   []string{"a"},
+			wantErr: inference.ErrInvalidRequest,
+		},
+		{
+			name:   "stream fails over before the first chunk",
+			stream: true,
+			peers:  map[string]fakePeer{"a": {err: errBusy}, "b": {chunks: 2}},
+			want:   "b",
+			calls:  []string{"a", "b"},
+		},
+		{
+			name:    "stream does not fail over after the first chunk",
+			stream:  true,
+			peers:   map[string]fakePeer{"a": {chunks: 1, err: errBusy}, "b": {}},
+			calls:   []string{"a"},
+			wantErr: errBusy,
+		},
+		{
+			name:   "stream times out before the first chunk",
+			stream: true,
+			peers:  map[string]fakePeer{"a": {delay: time.Minute}, "b": {}},
+			want:   "b",
+			calls:  []string{"a", "b"},
+		},
+		{
+			name:  "silent peer times out",
+			peers: map[string]fakePeer{"a": {delay: time.Minute}, "b": {}},
+			want:  "b",
+			calls: []string{"a", "b"},
+		},
+		{
+			name:  "connected peer may take longer than the attempt timeout",
+			peers: map[string]fakePeer{"a": {connect: true, delay: 300 * time.Millisecond}, "b": {}},
+			want:  "a",
+			calls: []string{"a"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exec := &fakeExecutor{peers: tt.peers}
+			src := &fakeSource{peers: []string{"a", "b", "c"}}
+			cfg := Config{Policy: PolicyLocalityFirst, AttemptTimeout: 100 * time.Millisecond, MaxAttempts: 3}
+			r := newTestRouter(t, cfg, newLocalBackend(t, false), exec, src)
+			req := &inference.Request{Model: "llama3"}
+
+			var got string
+			var err error
+			if tt.stream {
+				err = r.ProcessStream(context.Background(), req, func(resp *inference.Response) error {
+					got = resp.Response
+					return nil
+				})
+			} else {
+				var resp *inference.Response
+				resp, err = r.Process(context.Background(), req)
+				if resp != nil {
+					got = resp.Response
+				}
+			}
+
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("err = %v, want %v", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			} else if got != tt.want {
+				t.Errorf("answered by %q, want %q", got, tt.want)
+			}
+			if calls := exec.called(); !reflect.DeepEqual(calls, tt.calls) {
+				t.Errorf("peers asked: %v, want %v", calls, tt.calls)
+			}
+		})
+	}
+}
+
+func TestRouterLocalityFirstDefersLookup(t *testing.T) {
+	cfg := Config{Policy: PolicyLocalityFirst, AttemptTimeout: time.Second, MaxAttempts: 3}
+
+	// A local engine with the model answers without peers being looked up.
+	exec := &fakeExecutor{peers: map[string]fakePeer{"a": {}}}
+	src := &fakeSource{peers: []string{"a"}}
+	r := newTestRouter(t, cfg, newLocalBackend(t, false, "llama3:latest"), exec, src)
+	resp, err := r.Process(context.Background(), &inference.Request{Model: "llama3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Response != "local" {
+		t.Errorf("answered by %q, want local", resp.Response)
+	}
+	if n := src.count(); n != 0 {
+		t.Errorf("%d peer lookups, want none", n)
+	}
+
+	// When it fails, the peers are looked up and tried.
+	exec = &fakeExecutor{peers: map[string]fakePeer{"a": {}}}
+	src = &fakeSource{peers: []string{"a"}}
+	r = newTestRouter(t, cfg, newLocalBackend(t, true, "llama3:latest"), exec, src)
+	resp, err = r.Process(context.Background(), &inference.Request{Model: "llama3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Response != "a" {
+		t.Errorf("answered by %q, want a", resp.Response)
+	}
+	if n := src.count(); n != 1 {
+		t.Errorf("%d peer lookups, want 1", n)
+	}
+
+	// Other policies weigh the local engine against the peers up front.
+	cfg.Policy = PolicyLeastLoaded
+	src = &fakeSource{peers: []string{"a"}}
+	r = newTestRouter(t, cfg, newLocalBackend(t, false, "llama3:latest"), exec, src)
+	if _, err := r.Process(context.Background(), &inference.Request{Model: "llama3"}); err != nil {
+		t.Fatal(err)
+	}
+	if n := src.count(); n != 1 {
+		t.Errorf("%d peer lookups with %s, want 1", n, PolicyLeastLoaded)
+	}
+}
+
+func TestRouterLookupBounded(t *testing.T) {
+	cfg := Config{Policy: PolicyLocalityFirst, AttemptTimeout: 50 * time.Millisecond, MaxAttempts: 3}
+	src := &fakeSource{block: true}
+	r := newTestRouter(t, cfg, newLocalBackend(t, false), &fakeExecutor{}, src)
+
+	start := time.Now()
+	resp, err := r.Process(context.Background(), &inference.Request{Model: "llama3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Response != "local" {
+		t.Errorf("answered by %q, want local", resp.Response)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("lookup took %s, want about %s", elapsed, cfg.AttemptTimeout)
+	}
+}
+
+func TestLatencyBounded(t *testing.T) {
+	r := newTestRouter(t, DefaultConfig(), newLocalBackend(t, false), &fakeExecutor{})
+
+	for i := 0; i < maxLatencyPeers+10; i++ {
+		r.observeLatency(fmt.Sprintf("peer-%d", i), time.Millisecond)
+	}
+	if len(r.latency) != maxLatencyPeers {
+		t.Fatalf("%d peers remembered, want %d", len(r.latency), maxLatencyPeers)
+	}
+	if _, ok := r.latency["peer-0"]; ok {
+		t.Error("peer measured longest ago was kept")
+	}
+	if _, ok := r.latency[fmt.Sprintf("peer-%d", maxLatencyPeers+9)]; !ok {
+		t.Error("latest peer was not remembered")
+	}
+
+	// A known peer is updated in place.
+	r.observeLatency("peer-20", 11*time.Millisecond)
+	if got := r.latency["peer-20"].mean; got != 3*time.Millisecond {
+		t.Errorf("mean = %s, want 3ms", got)
+	}
+}
@@ -0,0 +1,289 @@
+package security
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// apiKeyPrefix starts every API key, which tells them apart from other
+// bearer tokens and makes leaked keys easy to scan for.
+const apiKeyPrefix = "nova_"
+
+// ErrKeyNotFound is returned when revoking a key ID that does not exist.
+var ErrKeyNotFound = errors.New("API key not found")
+
+// APIKey describes an issued key. The secret itself is only returned once,
+// by Issue; the store keeps its SHA-256 hash.
+type APIKey struct {
+	ID        string     `json:"id"`
+	Name      string     `json:"name,omitempty"`
+	Scopes    []Scope    `json:"scopes"`
+	Models    []string   `json:"models,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// Expired reports whether the key is past its expiry at t.
+func (k *APIKey) Expired(t time.Time) bool {
+	return k.ExpiresAt != nil && !t.Before(*k.ExpiresAt)
+}
+
+type storedKey struct {
+	APIKey
+	Hash string `json:"hash"`
+}
+
+type keyFile struct {
+	Keys []storedKey `json:"keys"`
+}
+
+// keyCheckInterval limits how often the store looks for changes made to
+// the file by another process, such as "novacron keys create --file".
+const keyCheckInterval = time.Second
+
+// KeyStore holds API keys in a JSON file. Keys are stored hashed, so the
+// file does not reveal them. Changes to the file are picked up without a
+// restart.
+type KeyStore struct {
+	mu      sync.Mutex
+	path    string
+	keys    map[string]*storedKey
+	modTime time.Time
+	size    int64
+	checked time.Time
+}
+
+// OpenKeyStore loads the keys at path. A missing file is an empty store;
+// it is created when the first key is issued.
+func OpenKeyStore(path string) (*KeyStore, error) {
+	s := &KeyStore{path: path, keys: make(map[string]*storedKey)}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Issue creates a key and returns its secret along with its description.
+// A zero ttl never expires.
+func (s *KeyStore) Issue(name string, scopes []Scope, models []string, ttl time.Duration) (string, APIKey, error) {
+	if len(scopes) == 0 {
+		return "", APIKey{}, errors.New("an API key needs at least one scope")
+	}
+
+	id, err := randomString(6, hex.EncodeToString)
+	if err != nil {
+		return "", APIKey{}, err
+	}
+	secret, err := randomString(32, base64.RawURLEncoding.EncodeToString)
+	if err != nil {
+		return "", APIKey{}, err
+	}
+	token := apiKeyPrefix + id + "_" + secret
+
+	key := APIKey{
+		ID:        id,
+		Name:      name,
+		Scopes:    scopes,
+		Models:    models,
+		CreatedAt: time.Now().UTC().Truncate(time.Second),
+	}
+	if ttl > 0 {
+		expires := key.CreatedAt.Add(ttl)
+		key.ExpiresAt = &expires
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.refresh(true); err != nil {
+		return "", APIKey{}, err
+	}
+	s.keys[id] = &storedKey{APIKey: key, Hash: hashToken(token)}
+	if err := s.save(); err != nil {
+		delete(s.keys, id)
+		return "", APIKey{}, err
+	}
+	return token, key, nil
+}
+
+// List returns the issued keys, oldest first, including expired ones.
+func (s *KeyStore) List() ([]APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.refresh(true); err != nil {
+		return nil, err
+	}
+	keys := make([]APIKey, 0, len(s.keys))
+	for _, k := range s.keys {
+		keys = append(keys, k.APIKey)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if !keys[i].CreatedAt.Equal(keys[j].CreatedAt) {
+			return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+		}
+		return keys[i].ID < keys[j].ID
+	})
+	return keys, nil
+}
+
+// Revoke deletes the key with the given ID.
+func (s *KeyStore) Revoke(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.refresh(true); err != nil {
+		return err
+	}
+	key, ok := s.keys[id]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrKeyNotFound, id)
+	}
+	delete(s.keys, id)
+	if err := s.save(); err != nil {
+		s.keys[id] = key
+		return err
+	}
+	return nil
+}
+
+// Authenticate accepts requests bearing an API key issued by the store.
+// Bearer tokens that are not API keys are left to other authenticators.
+func (s *KeyStore) Authenticate(r *http.Request) (*Identity, error) {
+	token, ok := bearerToken(r)
+	if !ok || !strings.HasPrefix(token, apiKeyPrefix) {
+		return nil, ErrNoCredentials
+	}
+	id, _, _ := strings.Cut(strings.TrimPrefix(token, apiKeyPrefix), "_")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.refresh(false); err != nil {
+		return nil, err
+	}
+	key, ok := s.keys[id]
+	if !ok || subtle.ConstantTimeCompare([]byte(key.Hash), []byte(hashToken(token))) != 1 {
+		return nil, fmt.Errorf("%w: unknown API key", ErrUnauthenticated)
+	}
+	if key.Expired(time.Now()) {
+		return nil, fmt.Errorf("%w: API key %s has expired", ErrUnauthenticated, id)
+	}
+	return &Identity{
+		Name:   "key:" + id,
+		Scopes: key.Scopes,
+		Models: key.Models,
+	}, nil
+}
+
+// Challenge is the WWW-Authenticate value that asks for an API key.
+func (s *KeyStore) Challenge() string {
+	return `Bearer realm="nova"`
+}
+
+// refresh reloads the file if it changed since it was last read. Unless
+// force is set, the file is looked at no more than once per
+// keyCheckInterval. Callers hold s.mu.
+func (s *KeyStore) refresh(force bool) error {
+	if !force && time.Since(s.checked) < keyCheckInterval {
+		return nil
+	}
+	return s.load()
+}
+
+// load reads the key file. Callers hold s.mu.
+func (s *KeyStore) load() error {
+	s.checked = time.Now()
+	info, err := os.Stat(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		s.keys = make(map[string]*storedKey)
+		s.modTime, s.size = time.Time{}, 0
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read API keys: %w", err)
+	}
+	if info.ModTime().Equal(s.modTime) && info.Size() == s.size {
+		return nil
+	}
+
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return fmt.Errorf("failed to read API keys: %w", err)
+	}
+	var file keyFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", s.path, err)
+	}
+	keys := make(map[string]*storedKey, len(file.Keys))
+	for i := range file.Keys {
+		keys[file.Keys[i].ID] = &file.Keys[i]
+	}
+	s.keys = keys
+	s.modTime, s.size = info.ModTime(), info.Size()
+	return nil
+}
+
+// save writes the keys to a temporary file and renames it into place, so
+// readers never see a partial file. Callers hold s.mu.
+func (s *KeyStore) save() error {
+	file := keyFile{Keys: make([]storedKey, 0, len(s.keys))}
+	for _, k := range s.keys {
+		file.Keys = append(file.Keys, *k)
+	}
+	sort.Slice(file.Keys, func(i, j int) bool { return file.Keys[i].ID < file.Keys[j].ID })
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode API keys: %w", err)
+	}
+
+	dir := filepath.Dir(s.path)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+	tmp, err := os.CreateTemp(dir, ".api-keys-*")
+	if err != nil {
+		return fmt.Errorf("failed to write API keys: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write API keys: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write API keys: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("failed to write API keys: %w", err)
+	}
+
+	if info, err := os.Stat(s.path); err == nil {
+		s.modTime, s.size = info.ModTime(), info.Size()
+	}
+	s.checked = time.Now()
+	return nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomString(n int, encode func([]byte) string) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate API key: %w", err)
+	}
+	return encode(b), nil
+}
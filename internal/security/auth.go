@@ -0,0 +1,124 @@
+package security
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+var (
+	// ErrNoCredentials is returned by an Authenticator for requests that
+	// carry no credentials of its kind, so that the next one can try.
+	ErrNoCredentials = errors.New("authentication required")
+	// ErrUnauthenticated is wrapped by errors for credentials that were
+	// presented but are invalid, expired or revoked.
+	ErrUnauthenticated = errors.New("invalid credentials")
+	// ErrForbidden is wrapped by errors for authenticated callers that lack
+	// the scope or model access a request needs.
+	ErrForbidden = errors.New("permission denied")
+	// ErrAuthUnavailable is wrapped by errors for credentials that could
+	// not be checked because the directory behind them is unreachable.
+	ErrAuthUnavailable = errors.New("authentication service unavailable")
+)
+
+// Scope grants access to a group of API endpoints.
+type Scope string
+
+const (
+	// ScopeGenerate allows generate, chat and embed requests and reading
+	// the model lists.
+	ScopeGenerate Scope = "generate"
+	// ScopeManageModels allows pulling, creating, copying and deleting
+	// models.
+	ScopeManageModels Scope = "manage-models"
+	// ScopePriority allows requests in the high priority class, which are
+	// admitted ahead of everyone else's.
+	ScopePriority Scope = "priority"
+	// ScopeAdmin allows everything, including managing API keys.
+	ScopeAdmin Scope = "admin"
+)
+
+var scopes = []Scope{ScopeGenerate, ScopeManageModels, ScopePriority, ScopeAdmin}
+
+// ParseScope accepts "generate", "manage-models", "priority" or "admin".
+func ParseScope(s string) (Scope, error) {
+	for _, scope := range scopes {
+		if strings.EqualFold(s, string(scope)) {
+			return scope, nil
+		}
+	}
+	return "", fmt.Errorf("unknown scope %q (want one of generate, manage-models, priority, admin)", s)
+}
+
+// Identity is an authenticated API caller.
+type Identity struct {
+	// Name identifies the caller, e.g. "key:1a2b3c4d5e6f" for an API key.
+	// It is also the client the fair queue schedules by.
+	Name   string
+	Scopes []Scope
+	// Models limits the models the caller may use; empty allows all. An
+	// entry without a tag allows every tag of that model.
+	Models []string
+}
+
+// Can reports whether the identity holds scope. Admin implies every scope.
+func (id *Identity) Can(scope Scope) bool {
+	for _, s := range id.Scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsModel reports whether the identity may use model.
+func (id *Identity) AllowsModel(model string) bool {
+	if len(id.Models) == 0 {
+		return true
+	}
+	name, tag := splitModel(model)
+	for _, allowed := range id.Models {
+		allowedName, allowedTag, hasTag := strings.Cut(allowed, ":")
+		if allowedName != name {
+			continue
+		}
+		if !hasTag || allowedTag == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// splitModel splits a model reference into name and tag, defaulting the tag
+// to "latest" as Ollama does.
+func splitModel(model string) (string, string) {
+	name, tag, _ := strings.Cut(model, ":")
+	if tag == "" {
+		tag = "latest"
+	}
+	return name, tag
+}
+
+// Authenticator verifies the credentials of an API request. It returns
+// ErrNoCredentials when the request carries none it understands, and an
+// error wrapping ErrUnauthenticated when they are invalid.
+type Authenticator interface {
+	Authenticate(r *http.Request) (*Identity, error)
+}
+
+// Challenger is implemented by authenticators that tell clients how to
+// authenticate, in the WWW-Authenticate header of 401 responses.
+type Challenger interface {
+	Challenge() string
+}
+
+// bearerToken returns the token of an "Authorization: Bearer" header.
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
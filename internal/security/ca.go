@@ -0,0 +1,619 @@
+package security
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Files of a CA directory.
+const (
+	caCertFile   = "ca.crt"
+	caKeyFile    = "ca.key"
+	caIssuedFile = "issued.json"
+	caCRLFile    = "crl.pem"
+)
+
+// Default lifetimes of the certificates a CA issues.
+const (
+	DefaultCAValidity   = 10 * 365 * 24 * time.Hour
+	DefaultCertValidity = 365 * 24 * time.Hour
+)
+
+// crlValidity is how long a CRL written by the CA is declared current.
+// "novacron ca crl" writes a fresh one.
+const crlValidity = 30 * 24 * time.Hour
+
+// backdate is subtracted from NotBefore so that new certificates are
+// accepted by hosts whose clocks run slightly behind.
+const backdate = 5 * time.Minute
+
+var (
+	// ErrCAExists is returned when initializing a directory that already
+	// holds a CA.
+	ErrCAExists = errors.New("a CA already exists")
+	// ErrNoCA is returned when opening a directory without a CA.
+	ErrNoCA = errors.New("no CA found")
+	// ErrSerialNotFound is returned when revoking a serial number the CA
+	// has not issued.
+	ErrSerialNotFound = errors.New("no certificate with that serial number")
+)
+
+// KeyAlgorithm is the type of key generated for a certificate.
+type KeyAlgorithm string
+
+const (
+	KeyECDSA   KeyAlgorithm = "ecdsa"
+	KeyEd25519 KeyAlgorithm = "ed25519"
+)
+
+// ParseKeyAlgorithm accepts "ecdsa" (P-256) or "ed25519". An empty string
+// selects ECDSA.
+func ParseKeyAlgorithm(s string) (KeyAlgorithm, error) {
+	switch strings.ToLower(s) {
+	case "", string(KeyECDSA):
+		return KeyECDSA, nil
+	case string(KeyEd25519):
+		return KeyEd25519, nil
+	}
+	return "", fmt.Errorf("unknown key algorithm %q (want ecdsa or ed25519)", s)
+}
+
+// CertKind is what an issued certificate is for.
+type CertKind string
+
+const (
+	// CertKindCA is an intermediate CA signed by this one.
+	CertKindCA CertKind = "ca"
+	// CertKindNode serves the API and authenticates the node to its
+	// peers, so it is valid for both server and client authentication.
+	CertKindNode CertKind = "node"
+	// CertKindClient authenticates an API client over mutual TLS.
+	CertKindClient CertKind = "client"
+)
+
+// IssuedCert records a certificate issued by a CA.
+type IssuedCert struct {
+	// Serial is the certificate's serial number in hex.
+	Serial      string   `json:"serial"`
+	Kind        CertKind `json:"kind"`
+	Subject     string   `json:"subject"`
+	DNSNames    []string `json:"dns_names,omitempty"`
+	IPAddresses []string `json:"ip_addresses,omitempty"`
+	// PeerID is the libp2p peer ID a node certificate is bound to.
+	PeerID    string     `json:"peer_id,omitempty"`
+	NotBefore time.Time  `json:"not_before"`
+	NotAfter  time.Time  `json:"not_after"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+type issuedFile struct {
+	Certificates []IssuedCert `json:"certificates"`
+	// CRLNumber is the number of the last CRL the CA wrote.
+	CRLNumber int64 `json:"crl_number,omitempty"`
+}
+
+// CAOptions describes a new CA.
+type CAOptions struct {
+	CommonName   string
+	Organization string
+	// Validity defaults to DefaultCAValidity.
+	Validity     time.Duration
+	KeyAlgorithm KeyAlgorithm
+	// Parent signs the new CA, making it an intermediate that can only
+	// issue node and client certificates. Without a parent the CA is a
+	// self-signed root.
+	Parent *CA
+}
+
+// CertRequest describes a node or client certificate to issue.
+type CertRequest struct {
+	Kind CertKind
+	// CommonName defaults to the first DNS name of a node certificate.
+	// Client certificates must have one; it names the API caller.
+	CommonName          string
+	OrganizationalUnits []string
+	DNSNames            []string
+	IPAddresses         []net.IP
+	// PeerID binds a node certificate to the libp2p peer ID of the node,
+	// which ValidatePeer checks.
+	PeerID string
+	// Validity defaults to DefaultCertValidity and is capped at the
+	// expiry of the CA.
+	Validity     time.Duration
+	KeyAlgorithm KeyAlgorithm
+}
+
+// CA issues node and client certificates from a directory holding its
+// certificate chain, its private key and a record of every serial number
+// it has issued.
+type CA struct {
+	mu  sync.Mutex
+	dir string
+	// chain is the CA's certificate followed by those of its issuers, up
+	// to the root.
+	chain []*x509.Certificate
+	key   crypto.Signer
+}
+
+// InitCA creates a CA in dir, which must not already hold one.
+func InitCA(dir string, opts CAOptions) (*CA, error) {
+	if _, err := os.Stat(filepath.Join(dir, caKeyFile)); err == nil {
+		return nil, fmt.Errorf("%w in %s", ErrCAExists, dir)
+	}
+	if opts.CommonName == "" {
+		return nil, errors.New("a CA needs a common name")
+	}
+	if opts.Validity <= 0 {
+		opts.Validity = DefaultCAValidity
+	}
+
+	key, err := generateKey(opts.KeyAlgorithm)
+	if err != nil {
+		return nil, err
+	}
+	tmpl := &x509.Certificate{
+		Subject:               pkix.Name{CommonName: opts.CommonName},
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	if opts.Organization != "" {
+		tmpl.Subject.Organization = []string{opts.Organization}
+	}
+
+	var chain []*x509.Certificate
+	if opts.Parent == nil {
+		serial, err := randomSerial()
+		if err != nil {
+			return nil, err
+		}
+		tmpl.SerialNumber = serial
+		tmpl.NotBefore = time.Now().Add(-backdate)
+		tmpl.NotAfter = tmpl.NotBefore.Add(opts.Validity)
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create CA certificate: %w", err)
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, err
+		}
+		chain = []*x509.Certificate{cert}
+	} else {
+		tmpl.MaxPathLenZero = true
+		cert, err := opts.Parent.sign(CertKindCA, tmpl, key.Public(), opts.Validity)
+		if err != nil {
+			return nil, err
+		}
+		chain = append([]*x509.Certificate{cert}, opts.Parent.chain...)
+	}
+
+	keyPEM, err := encodeKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+	if err := writeFileAtomic(filepath.Join(dir, caKeyFile), keyPEM, 0o600); err != nil {
+		return nil, err
+	}
+	if err := writeFileAtomic(filepath.Join(dir, caCertFile), encodeCerts(chain), 0o644); err != nil {
+		return nil, err
+	}
+	return &CA{dir: dir, chain: chain, key: key}, nil
+}
+
+// OpenCA loads the CA in dir.
+func OpenCA(dir string) (*CA, error) {
+	keyPEM, err := os.ReadFile(filepath.Join(dir, caKeyFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w in %s", ErrNoCA, dir)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA key: %w", err)
+	}
+	key, err := decodeKey(keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CA key: %w", err)
+	}
+	certPEM, err := os.ReadFile(filepath.Join(dir, caCertFile))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA certificate: %w", err)
+	}
+	chain, err := decodeCerts(certPEM)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CA certificate: %w", err)
+	}
+	if !publicKeysEqual(chain[0].PublicKey, key.Public()) {
+		return nil, fmt.Errorf("the CA key in %s does not match its certificate", dir)
+	}
+	return &CA{dir: dir, chain: chain, key: key}, nil
+}
+
+// Certificate returns the CA's own certificate.
+func (ca *CA) Certificate() *x509.Certificate {
+	return ca.chain[0]
+}
+
+// RootPEM returns the root certificate of the CA's chain, which is what
+// nodes and clients list in their ca_path to trust it.
+func (ca *CA) RootPEM() []byte {
+	return encodeCerts(ca.chain[len(ca.chain)-1:])
+}
+
+// Issue creates a key pair and a certificate for req. The certificate PEM
+// includes the intermediate CAs, if any, so that it can be served as is.
+func (ca *CA) Issue(req CertRequest) (certPEM, keyPEM []byte, issued IssuedCert, err error) {
+	tmpl := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: req.CommonName, OrganizationalUnit: req.OrganizationalUnits},
+		DNSNames:    req.DNSNames,
+		IPAddresses: req.IPAddresses,
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+	}
+	switch req.Kind {
+	case CertKindNode:
+		if len(req.DNSNames) == 0 && len(req.IPAddresses) == 0 {
+			return nil, nil, IssuedCert{}, errors.New("a node certificate needs at least one DNS name or IP address")
+		}
+		if tmpl.Subject.CommonName == "" && len(req.DNSNames) > 0 {
+			tmpl.Subject.CommonName = req.DNSNames[0]
+		}
+		tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}
+		if req.PeerID != "" {
+			tmpl.URIs = []*url.URL{peerIDURI(req.PeerID)}
+		}
+	case CertKindClient:
+		if req.CommonName == "" {
+			return nil, nil, IssuedCert{}, errors.New("a client certificate needs a common name")
+		}
+		tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	default:
+		return nil, nil, IssuedCert{}, fmt.Errorf("cannot issue a %q certificate", req.Kind)
+	}
+	if issuer := ca.Certificate().Subject.Organization; len(issuer) > 0 {
+		tmpl.Subject.Organization = issuer
+	}
+
+	key, err := generateKey(req.KeyAlgorithm)
+	if err != nil {
+		return nil, nil, IssuedCert{}, err
+	}
+	validity := req.Validity
+	if validity <= 0 {
+		validity = DefaultCertValidity
+	}
+	cert, err := ca.sign(req.Kind, tmpl, key.Public(), validity)
+	if err != nil {
+		return nil, nil, IssuedCert{}, err
+	}
+	if keyPEM, err = encodeKey(key); err != nil {
+		return nil, nil, IssuedCert{}, err
+	}
+
+	// Serve the intermediates along with the certificate, but not the
+	// root, which peers already have.
+	chain := append([]*x509.Certificate{cert}, ca.chain[:len(ca.chain)-1]...)
+	return encodeCerts(chain), keyPEM, issuedRecord(req.Kind, cert), nil
+}
+
+// Issued returns the certificates the CA has issued, oldest first.
+func (ca *CA) Issued() ([]IssuedCert, error) {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+	file, err := ca.loadIssued()
+	if err != nil {
+		return nil, err
+	}
+	return file.Certificates, nil
+}
+
+// Revoke marks the certificate with the given serial number, in hex, as
+// revoked and writes a new CRL to crl.pem in the CA directory.
+func (ca *CA) Revoke(serial string) error {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+
+	file, err := ca.loadIssued()
+	if err != nil {
+		return err
+	}
+	serial = normalizeSerial(serial)
+	found := false
+	for i := range file.Certificates {
+		c := &file.Certificates[i]
+		if c.Serial != serial {
+			continue
+		}
+		found = true
+		if c.RevokedAt == nil {
+			now := time.Now().UTC().Truncate(time.Second)
+			c.RevokedAt = &now
+		}
+	}
+	if !found {
+		return fmt.Errorf("%w: %s", ErrSerialNotFound, serial)
+	}
+	return ca.writeCRL(file)
+}
+
+// CRL writes a fresh CRL listing the revoked certificates to crl.pem in
+// the CA directory and returns it.
+func (ca *CA) CRL() ([]byte, error) {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+
+	file, err := ca.loadIssued()
+	if err != nil {
+		return nil, err
+	}
+	if err := ca.writeCRL(file); err != nil {
+		return nil, err
+	}
+	return os.ReadFile(filepath.Join(ca.dir, caCRLFile))
+}
+
+// writeCRL signs a CRL for the revoked certificates in file and saves both.
+// Callers hold ca.mu.
+func (ca *CA) writeCRL(file *issuedFile) error {
+	file.CRLNumber++
+	now := time.Now()
+	tmpl := &x509.RevocationList{
+		Number:     big.NewInt(file.CRLNumber),
+		ThisUpdate: now,
+		NextUpdate: now.Add(crlValidity),
+	}
+	for _, c := range file.Certificates {
+		if c.RevokedAt == nil {
+			continue
+		}
+		serial, ok := new(big.Int).SetString(c.Serial, 16)
+		if !ok {
+			return fmt.Errorf("invalid serial number %q in %s", c.Serial, caIssuedFile)
+		}
+		tmpl.RevokedCertificateEntries = append(tmpl.RevokedCertificateEntries, x509.RevocationListEntry{
+			SerialNumber:   serial,
+			RevocationTime: *c.RevokedAt,
+		})
+	}
+	der, err := x509.CreateRevocationList(rand.Reader, tmpl, ca.Certificate(), ca.key)
+	if err != nil {
+		return fmt.Errorf("failed to create CRL: %w", err)
+	}
+
+	if err := ca.saveIssued(file); err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(ca.dir, caCRLFile), pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der}), 0o644)
+}
+
+// sign signs tmpl with a fresh serial number that is recorded before the
+// certificate is returned, so that no serial is ever handed out twice.
+func (ca *CA) sign(kind CertKind, tmpl *x509.Certificate, pub crypto.PublicKey, validity time.Duration) (*x509.Certificate, error) {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+
+	file, err := ca.loadIssued()
+	if err != nil {
+		return nil, err
+	}
+	used := make(map[string]bool, len(file.Certificates))
+	for _, c := range file.Certificates {
+		used[c.Serial] = true
+	}
+	for tmpl.SerialNumber == nil || used[tmpl.SerialNumber.Text(16)] {
+		if tmpl.SerialNumber, err = randomSerial(); err != nil {
+			return nil, err
+		}
+	}
+
+	tmpl.NotBefore = time.Now().Add(-backdate)
+	tmpl.NotAfter = tmpl.NotBefore.Add(validity)
+	if caExpiry := ca.Certificate().NotAfter; tmpl.NotAfter.After(caExpiry) {
+		tmpl.NotAfter = caExpiry
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.Certificate(), pub, ca.key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create certificate: %w", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+
+	file.Certificates = append(file.Certificates, issuedRecord(kind, cert))
+	if err := ca.saveIssued(file); err != nil {
+		return nil, err
+	}
+	return cert, nil
+}
+
+// loadIssued reads the record of issued certificates. Callers hold ca.mu.
+func (ca *CA) loadIssued() (*issuedFile, error) {
+	var file issuedFile
+	data, err := os.ReadFile(filepath.Join(ca.dir, caIssuedFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return &file, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read issued certificates: %w", err)
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", caIssuedFile, err)
+	}
+	return &file, nil
+}
+
+// saveIssued writes the record of issued certificates. Callers hold ca.mu.
+func (ca *CA) saveIssued(file *issuedFile) error {
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode issued certificates: %w", err)
+	}
+	return writeFileAtomic(filepath.Join(ca.dir, caIssuedFile), append(data, '\n'), 0o600)
+}
+
+func issuedRecord(kind CertKind, cert *x509.Certificate) IssuedCert {
+	ips := make([]string, len(cert.IPAddresses))
+	for i, ip := range cert.IPAddresses {
+		ips[i] = ip.String()
+	}
+	return IssuedCert{
+		Serial:      cert.SerialNumber.Text(16),
+		Kind:        kind,
+		Subject:     cert.Subject.String(),
+		DNSNames:    cert.DNSNames,
+		IPAddresses: ips,
+		PeerID:      CertPeerID(cert),
+		NotBefore:   cert.NotBefore,
+		NotAfter:    cert.NotAfter,
+	}
+}
+
+// normalizeSerial accepts serial numbers as printed by "novacron ca list"
+// or by OpenSSL, with or without colons and leading zeros.
+func normalizeSerial(serial string) string {
+	serial = strings.ToLower(strings.ReplaceAll(serial, ":", ""))
+	serial = strings.TrimLeft(serial, "0")
+	if serial == "" {
+		return "0"
+	}
+	return serial
+}
+
+// randomSerial returns a random positive 128-bit serial number.
+func randomSerial() (*big.Int, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate serial number: %w", err)
+	}
+	if serial.Sign() == 0 {
+		serial.SetInt64(1)
+	}
+	return serial, nil
+}
+
+func generateKey(alg KeyAlgorithm) (crypto.Signer, error) {
+	var key crypto.Signer
+	var err error
+	switch alg {
+	case "", KeyECDSA:
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case KeyEd25519:
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, fmt.Errorf("unknown key algorithm %q", alg)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate private key: %w", err)
+	}
+	return key, nil
+}
+
+func encodeKey(key crypto.Signer) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode private key: %w", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+func decodeKey(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, errors.New("no PRIVATE KEY block found")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported key type %T", key)
+	}
+	return signer, nil
+}
+
+func encodeCerts(certs []*x509.Certificate) []byte {
+	var buf bytes.Buffer
+	for _, cert := range certs {
+		pem.Encode(&buf, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	}
+	return buf.Bytes()
+}
+
+func decodeCerts(data []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("no CERTIFICATE block found")
+	}
+	return certs, nil
+}
+
+func publicKeysEqual(a, b crypto.PublicKey) bool {
+	k, ok := a.(interface{ Equal(crypto.PublicKey) bool })
+	return ok && k.Equal(b)
+}
+
+// writeFileAtomic writes data to a temporary file in the same directory and
+// renames it over path, so readers never see a partial file.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
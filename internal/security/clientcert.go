@@ -0,0 +1,62 @@
+package security
+
+import (
+	"net/http"
+	"sync"
+)
+
+// ClientCertConfig maps the verified client certificates of API callers to
+// identities. It needs client_auth to be optional or require.
+type ClientCertConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Roles maps certificate subjects to the scopes they grant. A key
+	// matches the subject's common name, one of its organizational units
+	// written as "OU=<unit>", or the whole subject such as
+	// "CN=ci,OU=ml,O=Example".
+	Roles map[string][]Scope `yaml:"roles"`
+}
+
+// CertAuthenticator identifies callers by the client certificate verified
+// during the TLS handshake.
+type CertAuthenticator struct {
+	mu  sync.RWMutex
+	cfg ClientCertConfig
+}
+
+func NewCertAuthenticator(cfg ClientCertConfig) *CertAuthenticator {
+	return &CertAuthenticator{cfg: cfg}
+}
+
+// SetConfig replaces the role mapping.
+func (a *CertAuthenticator) SetConfig(cfg ClientCertConfig) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.cfg = cfg
+}
+
+// Authenticate accepts requests made over a connection with a verified
+// client certificate. The identity is named after the certificate's
+// subject.
+func (a *CertAuthenticator) Authenticate(r *http.Request) (*Identity, error) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil, ErrNoCredentials
+	}
+	subject := r.TLS.VerifiedChains[0][0].Subject
+
+	names := []string{subject.String()}
+	if subject.CommonName != "" {
+		names = append(names, subject.CommonName)
+	}
+	for _, unit := range subject.OrganizationalUnit {
+		names = append(names, "OU="+unit)
+	}
+
+	name := subject.CommonName
+	if name == "" {
+		name = subject.String()
+	}
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return &Identity{Name: "cert:" + name, Scopes: rolesFor(a.cfg.Roles, names)}, nil
+}
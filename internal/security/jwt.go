@@ -0,0 +1,522 @@
+package security
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	_ "crypto/sha256" // hashes for RS256, PS256 and ES256
+	_ "crypto/sha512" // hashes for the 384 and 512 variants
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// OIDCConfig configures validation of JWT bearer tokens issued by an
+// OpenID Connect provider.
+type OIDCConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Issuer must match the iss claim of every token.
+	Issuer string `yaml:"issuer"`
+	// Audience must be one of the aud claim values.
+	Audience string `yaml:"audience"`
+	// JWKSURL serves the provider's signing keys. When empty it is
+	// discovered from the issuer's /.well-known/openid-configuration.
+	JWKSURL string `yaml:"jwks_url"`
+	// JWKSCacheTTL is how long fetched keys are used before they are
+	// fetched again. Tokens signed by an unknown key trigger an early
+	// refresh.
+	JWKSCacheTTL time.Duration `yaml:"jwks_cache_ttl"`
+	// ClockSkew is the tolerance applied to the exp, nbf and iat claims.
+	ClockSkew time.Duration `yaml:"clock_skew"`
+	// UsernameClaim names the caller, e.g. "sub" or "preferred_username".
+	UsernameClaim string `yaml:"username_claim"`
+	// RolesClaim holds the caller's roles or groups as a list or a
+	// space-separated string. Nested claims are given as a dotted path,
+	// e.g. "realm_access.roles".
+	RolesClaim string `yaml:"roles_claim"`
+	// Roles maps values of RolesClaim to the scopes they grant.
+	Roles   map[string][]Scope `yaml:"roles"`
+	Timeout time.Duration      `yaml:"timeout"`
+}
+
+// DefaultOIDCConfig returns the settings used for fields the configuration
+// leaves out.
+func DefaultOIDCConfig() OIDCConfig {
+	return OIDCConfig{
+		JWKSCacheTTL:  time.Hour,
+		ClockSkew:     time.Minute,
+		UsernameClaim: "sub",
+		RolesClaim:    "groups",
+		Timeout:       10 * time.Second,
+	}
+}
+
+// jwksMinRefresh limits how often tokens with an unknown key ID can make
+// the validator fetch the key set again.
+const jwksMinRefresh = 30 * time.Second
+
+// JWTValidator authenticates requests bearing JWTs signed by the keys of
+// the configured provider.
+type JWTValidator struct {
+	mu  sync.Mutex
+	cfg OIDCConfig
+	// gen counts configuration changes, so that a fetch started under an
+	// older configuration does not replace the keys of the new one.
+	gen     uint64
+	client  *http.Client
+	keys    []jwk
+	fetched time.Time
+	// refresh lets concurrent requests share one fetch of the key set.
+	refresh singleflight.Group
+}
+
+// jwk is a parsed signing key from the provider's key set.
+type jwk struct {
+	kid string
+	key crypto.PublicKey
+}
+
+// NewJWTValidator creates a validator for cfg. Keys are fetched with the
+// first token.
+func NewJWTValidator(cfg OIDCConfig) *JWTValidator {
+	v := &JWTValidator{client: &http.Client{}}
+	v.SetConfig(cfg)
+	return v
+}
+
+// SetConfig replaces the configuration and drops the cached keys.
+func (v *JWTValidator) SetConfig(cfg OIDCConfig) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.cfg = cfg
+	v.gen++
+	v.keys = nil
+	v.fetched = time.Time{}
+}
+
+// Authenticate accepts requests whose bearer token is a valid JWT. Other
+// bearer tokens, such as API keys, are left to other authenticators.
+func (v *JWTValidator) Authenticate(r *http.Request) (*Identity, error) {
+	token, ok := bearerToken(r)
+	if !ok || strings.Count(token, ".") != 2 {
+		return nil, ErrNoCredentials
+	}
+	return v.Validate(r.Context(), token)
+}
+
+// Challenge is the WWW-Authenticate value that asks for a bearer token.
+func (v *JWTValidator) Challenge() string {
+	return `Bearer realm="nova"`
+}
+
+// Validate checks the signature and claims of token and returns the
+// caller's identity.
+func (v *JWTValidator) Validate(ctx context.Context, token string) (*Identity, error) {
+	v.mu.Lock()
+	cfg, gen := v.cfg, v.gen
+	v.mu.Unlock()
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, invalidToken("malformed token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, invalidToken("malformed header")
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, invalidToken("malformed signature")
+	}
+	if err := v.verify(ctx, cfg, gen, header.Alg, header.Kid, parts[0]+"."+parts[1], sig); err != nil {
+		return nil, err
+	}
+
+	var claims map[string]interface{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, invalidToken("malformed claims")
+	}
+	if err := checkClaims(cfg, claims, time.Now()); err != nil {
+		return nil, err
+	}
+
+	name, _ := claim(claims, cfg.UsernameClaim).(string)
+	if name == "" {
+		return nil, invalidToken(fmt.Sprintf("missing %s claim", cfg.UsernameClaim))
+	}
+	return &Identity{
+		Name:   "oidc:" + name,
+		Scopes: rolesFor(cfg.Roles, claimValues(claim(claims, cfg.RolesClaim))),
+	}, nil
+}
+
+// verify checks sig against the provider keys matching kid, fetching the
+// key set when it is stale or does not contain kid.
+func (v *JWTValidator) verify(ctx context.Context, cfg OIDCConfig, gen uint64, alg, kid, signed string, sig []byte) error {
+	verifier, ok := verifiers[alg]
+	if !ok {
+		return invalidToken(fmt.Sprintf("unsupported algorithm %q", alg))
+	}
+
+	keys, err := v.signingKeys(ctx, cfg, gen, kid)
+	if err != nil {
+		return err
+	}
+	if kid != "" && !hasKey(keys, kid) {
+		return invalidToken(fmt.Sprintf("unknown signing key %q", kid))
+	}
+	for _, k := range keys {
+		if kid != "" && k.kid != kid {
+			continue
+		}
+		if verifier(k.key, []byte(signed), sig) {
+			return nil
+		}
+	}
+	return invalidToken("invalid signature")
+}
+
+// signingKeys returns the cached key set, refreshing it when it is older
+// than JWKSCacheTTL or lacks kid. The fetch runs without holding v.mu and
+// is shared by all requests that need it. Tokens the cached keys can
+// still verify do not wait for it.
+func (v *JWTValidator) signingKeys(ctx context.Context, cfg OIDCConfig, gen uint64, kid string) ([]jwk, error) {
+	v.mu.Lock()
+	keys, fetched := v.keys, v.fetched
+	v.mu.Unlock()
+
+	age := time.Since(fetched)
+	stale := fetched.IsZero() || age >= cfg.JWKSCacheTTL
+	if !stale && kid != "" && !hasKey(keys, kid) && age >= jwksMinRefresh {
+		stale = true
+	}
+	if !stale {
+		return keys, nil
+	}
+
+	ch := v.refresh.DoChan(strconv.FormatUint(gen, 10), func() (interface{}, error) {
+		return v.refreshKeys(cfg, gen)
+	})
+	if len(keys) > 0 && (kid == "" || hasKey(keys, kid)) {
+		return keys, nil
+	}
+	select {
+	case res := <-ch:
+		if res.Err != nil {
+			return nil, res.Err
+		}
+		return res.Val.([]jwk), nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("%w: waiting for signing keys: %w", ErrAuthUnavailable, ctx.Err())
+	}
+}
+
+// refreshKeys fetches the key set for configuration generation gen and
+// caches it unless the configuration has changed since. The fetch is not
+// bound to any one request, so a caller giving up does not fail it for
+// the others.
+func (v *JWTValidator) refreshKeys(cfg OIDCConfig, gen uint64) ([]jwk, error) {
+	keys, err := v.fetchKeys(context.Background(), cfg)
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if gen != v.gen {
+		return keys, err
+	}
+	if err != nil {
+		if v.keys != nil {
+			// Keep using the keys we have while the provider is down, and
+			// try again after jwksMinRefresh.
+			v.fetched = time.Now().Add(jwksMinRefresh - cfg.JWKSCacheTTL)
+			return v.keys, nil
+		}
+		return nil, err
+	}
+	v.keys = keys
+	v.fetched = time.Now()
+	return keys, nil
+}
+
+func hasKey(keys []jwk, kid string) bool {
+	for _, k := range keys {
+		if k.kid == kid {
+			return true
+		}
+	}
+	return false
+}
+
+// fetchKeys downloads the provider's key set, discovering its URL first if
+// it is not configured.
+func (v *JWTValidator) fetchKeys(ctx context.Context, cfg OIDCConfig) ([]jwk, error) {
+	if cfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.Timeout)
+		defer cancel()
+	}
+
+	jwksURL := cfg.JWKSURL
+	if jwksURL == "" {
+		var discovery struct {
+			JWKSURI string `json:"jwks_uri"`
+		}
+		if err := v.getJSON(ctx, strings.TrimRight(cfg.Issuer, "/")+"/.well-known/openid-configuration", &discovery); err != nil {
+			return nil, err
+		}
+		if discovery.JWKSURI == "" {
+			return nil, fmt.Errorf("%w: OIDC discovery returned no jwks_uri", ErrAuthUnavailable)
+		}
+		jwksURL = discovery.JWKSURI
+	}
+
+	var set struct {
+		Keys []json.RawMessage `json:"keys"`
+	}
+	if err := v.getJSON(ctx, jwksURL, &set); err != nil {
+		return nil, err
+	}
+	keys := make([]jwk, 0, len(set.Keys))
+	for _, raw := range set.Keys {
+		// Keys of unknown types or for encryption are skipped.
+		if k, err := parseJWK(raw); err == nil {
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%w: no usable signing keys at %s", ErrAuthUnavailable, jwksURL)
+	}
+	return keys, nil
+}
+
+func (v *JWTValidator) getJSON(ctx context.Context, url string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrAuthUnavailable, err)
+	}
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: failed to fetch %s: %w", ErrAuthUnavailable, url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: failed to fetch %s: %s", ErrAuthUnavailable, url, resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("%w: failed to decode %s: %w", ErrAuthUnavailable, url, err)
+	}
+	return nil
+}
+
+// checkClaims validates the time, issuer and audience claims.
+func checkClaims(cfg OIDCConfig, claims map[string]interface{}, now time.Time) error {
+	exp, ok := numericDate(claims["exp"])
+	if !ok {
+		return invalidToken("missing exp claim")
+	}
+	if !now.Before(exp.Add(cfg.ClockSkew)) {
+		return invalidToken("token has expired")
+	}
+	if nbf, ok := numericDate(claims["nbf"]); ok && now.Add(cfg.ClockSkew).Before(nbf) {
+		return invalidToken("token is not valid yet")
+	}
+	if iat, ok := numericDate(claims["iat"]); ok && now.Add(cfg.ClockSkew).Before(iat) {
+		return invalidToken("token was issued in the future")
+	}
+
+	if iss, _ := claims["iss"].(string); iss != cfg.Issuer {
+		return invalidToken(fmt.Sprintf("unexpected issuer %q", iss))
+	}
+	if cfg.Audience != "" {
+		found := false
+		for _, aud := range claimValues(claims["aud"]) {
+			if aud == cfg.Audience {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return invalidToken("token is not meant for this audience")
+		}
+	}
+	return nil
+}
+
+func numericDate(v interface{}) (time.Time, bool) {
+	n, ok := v.(float64)
+	if !ok {
+		return time.Time{}, false
+	}
+	sec := int64(n)
+	return time.Unix(sec, int64((n-float64(sec))*1e9)), true
+}
+
+// claim looks up a claim by its dotted path.
+func claim(claims map[string]interface{}, path string) interface{} {
+	var v interface{} = claims
+	for _, name := range strings.Split(path, ".") {
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		v = obj[name]
+	}
+	return v
+}
+
+// claimValues returns the strings of a list claim, or the fields of a
+// space-separated string claim.
+func claimValues(v interface{}) []string {
+	switch v := v.(type) {
+	case string:
+		return strings.Fields(v)
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func invalidToken(reason string) error {
+	return fmt.Errorf("%w: %s", ErrUnauthenticated, reason)
+}
+
+// parseJWK parses an RSA, EC or Ed25519 public key from its JWK form.
+func parseJWK(raw json.RawMessage) (jwk, error) {
+	var k struct {
+		Kty string `json:"kty"`
+		Kid string `json:"kid"`
+		Use string `json:"use"`
+		Crv string `json:"crv"`
+		N   string `json:"n"`
+		E   string `json:"e"`
+		X   string `json:"x"`
+		Y   string `json:"y"`
+	}
+	if err := json.Unmarshal(raw, &k); err != nil {
+		return jwk{}, err
+	}
+	if k.Use != "" && k.Use != "sig" {
+		return jwk{}, fmt.Errorf("key %s is not for signing", k.Kid)
+	}
+
+	switch k.Kty {
+	case "RSA":
+		n, err1 := decodeBigInt(k.N)
+		e, err2 := decodeBigInt(k.E)
+		if err := errors.Join(err1, err2); err != nil || !e.IsInt64() {
+			return jwk{}, fmt.Errorf("invalid RSA key %s", k.Kid)
+		}
+		return jwk{kid: k.Kid, key: &rsa.PublicKey{N: n, E: int(e.Int64())}}, nil
+
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return jwk{}, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err1 := decodeBigInt(k.X)
+		y, err2 := decodeBigInt(k.Y)
+		if err := errors.Join(err1, err2); err != nil || !curve.IsOnCurve(x, y) {
+			return jwk{}, fmt.Errorf("invalid EC key %s", k.Kid)
+		}
+		return jwk{kid: k.Kid, key: &ecdsa.PublicKey{Curve: curve, X: x, Y: y}}, nil
+
+	case "OKP":
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if k.Crv != "Ed25519" || err != nil || len(x) != ed25519.PublicKeySize {
+			return jwk{}, fmt.Errorf("invalid OKP key %s", k.Kid)
+		}
+		return jwk{kid: k.Kid, key: ed25519.PublicKey(x)}, nil
+	}
+	return jwk{}, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return nil, errors.New("invalid integer")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// verifiers check a signature over signed with a public key, per JWS
+// algorithm. "none" and the HMAC algorithms are deliberately absent.
+var verifiers = map[string]func(key crypto.PublicKey, signed, sig []byte) bool{
+	"RS256": verifyRSA(crypto.SHA256, false),
+	"RS384": verifyRSA(crypto.SHA384, false),
+	"RS512": verifyRSA(crypto.SHA512, false),
+	"PS256": verifyRSA(crypto.SHA256, true),
+	"PS384": verifyRSA(crypto.SHA384, true),
+	"PS512": verifyRSA(crypto.SHA512, true),
+	"ES256": verifyECDSA(crypto.SHA256, elliptic.P256()),
+	"ES384": verifyECDSA(crypto.SHA384, elliptic.P384()),
+	"ES512": verifyECDSA(crypto.SHA512, elliptic.P521()),
+	"EdDSA": func(key crypto.PublicKey, signed, sig []byte) bool {
+		pub, ok := key.(ed25519.PublicKey)
+		return ok && ed25519.Verify(pub, signed, sig)
+	},
+}
+
+func verifyRSA(hash crypto.Hash, pss bool) func(crypto.PublicKey, []byte, []byte) bool {
+	return func(key crypto.PublicKey, signed, sig []byte) bool {
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return false
+		}
+		h := hash.New()
+		h.Write(signed)
+		if pss {
+			return rsa.VerifyPSS(pub, hash, h.Sum(nil), sig, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}) == nil
+		}
+		return rsa.VerifyPKCS1v15(pub, hash, h.Sum(nil), sig) == nil
+	}
+}
+
+func verifyECDSA(hash crypto.Hash, curve elliptic.Curve) func(crypto.PublicKey, []byte, []byte) bool {
+	size := (curve.Params().BitSize + 7) / 8
+	return func(key crypto.PublicKey, signed, sig []byte) bool {
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok || pub.Curve != curve || len(sig) != 2*size {
+			return false
+		}
+		h := hash.New()
+		h.Write(signed)
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		return ecdsa.Verify(pub, h.Sum(nil), r, s)
+	}
+}
@@ -0,0 +1,591 @@
+package security
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeOIDC is a stand-in provider serving a discovery document and a key
+// set, and counting how often each is fetched.
+type fakeOIDC struct {
+	srv *httptest.Server
+
+	mu   sync.Mutex
+	keys map[string]crypto.Signer // published keys by kid
+	// gate, if set, holds key set requests until it is closed.
+	gate          chan struct{}
+	fail          bool
+	discoveryHits int
+	jwksHits      int
+}
+
+func newFakeOIDC(t *testing.T) *fakeOIDC {
+	t.Helper()
+	p := &fakeOIDC{keys: map[string]crypto.Signer{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		p.mu.Lock()
+		p.discoveryHits++
+		p.mu.Unlock()
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":   p.srv.URL,
+			"jwks_uri": p.srv.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		p.mu.Lock()
+		p.jwksHits++
+		gate, fail := p.gate, p.fail
+		p.mu.Unlock()
+		if gate != nil {
+			<-gate
+		}
+		if fail {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode(p.keySet())
+	})
+	p.srv = httptest.NewServer(mux)
+	t.Cleanup(p.srv.Close)
+	return p
+}
+
+func (p *fakeOIDC) publish(kid string, key crypto.Signer) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.keys[kid] = key
+}
+
+func (p *fakeOIDC) setFail(fail bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.fail = fail
+}
+
+// hold makes key set requests wait until the returned function is called.
+func (p *fakeOIDC) hold(t *testing.T) func() {
+	gate := make(chan struct{})
+	p.mu.Lock()
+	p.gate = gate
+	p.mu.Unlock()
+	var once sync.Once
+	release := func() {
+		once.Do(func() {
+			p.mu.Lock()
+			p.gate = nil
+			p.mu.Unlock()
+			close(gate)
+		})
+	}
+	// Registered after the server's Close, so it runs first.
+	t.Cleanup(release)
+	return release
+}
+
+func (p *fakeOIDC) hits() (discovery, jwks int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.discoveryHits, p.jwksHits
+}
+
+func (p *fakeOIDC) keySet() map[string]interface{} {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	b64 := base64.RawURLEncoding.EncodeToString
+	keys := []map[string]string{}
+	for kid, key := range p.keys {
+		switch pub := key.Public().(type) {
+		case *ecdsa.PublicKey:
+			size := (pub.Curve.Params().BitSize + 7) / 8
+			keys = append(keys, map[string]string{
+				"kty": "EC", "kid": kid, "use": "sig", "crv": pub.Curve.Params().Name,
+				"x": b64(pub.X.FillBytes(make([]byte, size))),
+				"y": b64(pub.Y.FillBytes(make([]byte, size))),
+			})
+		case ed25519.PublicKey:
+			keys = append(keys, map[string]string{"kty": "OKP", "kid": kid, "crv": "Ed25519", "x": b64(pub)})
+		case *rsa.PublicKey:
+			keys = append(keys, map[string]string{
+				"kty": "RSA", "kid": kid, "n": b64(pub.N.Bytes()), "e": b64(big.NewInt(int64(pub.E)).Bytes()),
+			})
+		}
+	}
+	// An encryption key the validator must skip.
+	keys = append(keys, map[string]string{"kty": "RSA", "kid": "enc", "use": "enc", "n": "AQAB", "e": "AQAB"})
+	return map[string]interface{}{"keys": keys}
+}
+
+// signToken creates a JWT over claims. The algorithm follows from the key
+// type.
+func signToken(t *testing.T, kid string, key crypto.Signer, claims map[string]interface{}) string {
+	t.Helper()
+	var alg string
+	switch key.(type) {
+	case *ecdsa.PrivateKey:
+		alg = "ES256"
+	case ed25519.PrivateKey:
+		alg = "EdDSA"
+	case *rsa.PrivateKey:
+		alg = "RS256"
+	}
+	return signWith(t, map[string]string{"alg": alg, "kid": kid}, key, claims)
+}
+
+func signWith(t *testing.T, header map[string]string, key crypto.Signer, claims map[string]interface{}) string {
+	t.Helper()
+	segment := func(v interface{}) string {
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+	signed := segment(header) + "." + segment(claims)
+	digest := sha256.Sum256([]byte(signed))
+
+	var sig []byte
+	var err error
+	switch k := key.(type) {
+	case *ecdsa.PrivateKey:
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, k, digest[:])
+		if err == nil {
+			sig = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+		}
+	case ed25519.PrivateKey:
+		sig = ed25519.Sign(k, []byte(signed))
+	case *rsa.PrivateKey:
+		sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func newECKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func testOIDCConfig(issuer string) OIDCConfig {
+	cfg := DefaultOIDCConfig()
+	cfg.Enabled = true
+	cfg.Issuer = issuer
+	cfg.Audience = "nova"
+	cfg.UsernameClaim = "preferred_username"
+	cfg.Roles = map[string][]Scope{
+		"ml-admins": {ScopeAdmin},
+		"ml-users":  {ScopeGenerate},
+	}
+	cfg.Timeout = 5 * time.Second
+	return cfg
+}
+
+// testClaims returns valid claims for a token issued by issuer.
+func testClaims(issuer string) map[string]interface{} {
+	now := time.Now()
+	return map[string]interface{}{
+		"iss":                issuer,
+		"aud":                "nova",
+		"sub":                "1234",
+		"preferred_username": "alice",
+		"groups":             []string{"ml-users"},
+		"iat":                now.Unix(),
+		"nbf":                now.Unix(),
+		"exp":                now.Add(time.Hour).Unix(),
+	}
+}
+
+// age makes the cached key set look fetched d ago.
+func (v *JWTValidator) age(d time.Duration) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.fetched = time.Now().Add(-d)
+}
+
+// fetchAge returns how long ago the cached key set was fetched, counting
+// the backoff after a failed refresh.
+func (v *JWTValidator) fetchAge() time.Duration {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return time.Since(v.fetched)
+}
+
+// eventually waits for cond to hold.
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestJWTValidate(t *testing.T) {
+	p := newFakeOIDC(t)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := map[string]crypto.Signer{"ec": newECKey(t), "ed": edKey, "rsa": rsaKey}
+	for kid, key := range keys {
+		p.publish(kid, key)
+	}
+	v := NewJWTValidator(testOIDCConfig(p.srv.URL))
+
+	for kid, key := range keys {
+		t.Run(kid, func(t *testing.T) {
+			id, err := v.Validate(context.Background(), signToken(t, kid, key, testClaims(p.srv.URL)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if id.Name != "oidc:alice" {
+				t.Errorf("name = %q, want oidc:alice", id.Name)
+			}
+			if got := sortedScopes(id.Scopes); !reflect.DeepEqual(got, []string{"generate"}) {
+				t.Errorf("scopes = %v, want [generate]", got)
+			}
+		})
+	}
+
+	// One discovery and one key set fetch serve all tokens.
+	if discovery, jwks := p.hits(); discovery != 1 || jwks != 1 {
+		t.Errorf("fetched discovery %d and key set %d times, want once each", discovery, jwks)
+	}
+}
+
+func TestJWTAuthenticate(t *testing.T) {
+	p := newFakeOIDC(t)
+	key := newECKey(t)
+	p.publish("k1", key)
+	v := NewJWTValidator(testOIDCConfig(p.srv.URL))
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Authorization", "Bearer "+signToken(t, "k1", key, testClaims(p.srv.URL)))
+	if _, err := v.Authenticate(r); err != nil {
+		t.Fatal(err)
+	}
+
+	// API keys and other bearer tokens are left to other authenticators.
+	r.Header.Set("Authorization", "Bearer nova_0123456789")
+	if _, err := v.Authenticate(r); !errors.Is(err, ErrNoCredentials) {
+		t.Errorf("err = %v, want ErrNoCredentials", err)
+	}
+	if _, jwks := p.hits(); jwks != 1 {
+		t.Errorf("fetched key set %d times, want once", jwks)
+	}
+}
+
+func TestJWTRoles(t *testing.T) {
+	p := newFakeOIDC(t)
+	key := newECKey(t)
+	p.publish("k1", key)
+
+	tests := []struct {
+		name       string
+		rolesClaim string
+		value      interface{}
+		want       []string
+	}{
+		{"list", "groups", []string{"ml-users", "ml-admins"}, []string{"admin", "generate"}},
+		{"space-separated", "groups", "ml-admins other", []string{"admin"}},
+		{"nested", "realm_access.roles", map[string]interface{}{"roles": []string{"ml-users"}}, []string{"generate"}},
+		{"unknown role", "groups", []string{"sales"}, []string{}},
+		{"missing", "roles", nil, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testOIDCConfig(p.srv.URL)
+			cfg.RolesClaim = tt.rolesClaim
+			v := NewJWTValidator(cfg)
+
+			claims := testClaims(p.srv.URL)
+			delete(claims, "groups")
+			if tt.value != nil {
+				claims[strings.Split(tt.rolesClaim, ".")[0]] = tt.value
+			}
+			id, err := v.Validate(context.Background(), signToken(t, "k1", key, claims))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := sortedScopes(id.Scopes); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("scopes = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJWTClaims(t *testing.T) {
+	p := newFakeOIDC(t)
+	key := newECKey(t)
+	p.publish("k1", key)
+	v := NewJWTValidator(testOIDCConfig(p.srv.URL)) // one minute of skew
+
+	now := time.Now()
+	tests := []struct {
+		name    string
+		edit    func(claims map[string]interface{})
+		wantErr string
+	}{
+		{"audience in list", func(c map[string]interface{}) { c["aud"] = []string{"other", "nova"} }, ""},
+		{"wrong audience", func(c map[string]interface{}) { c["aud"] = "other" }, "audience"},
+		{"missing audience", func(c map[string]interface{}) { delete(c, "aud") }, "audience"},
+		{"wrong issuer", func(c map[string]interface{}) { c["iss"] = "https://evil.example.com" }, "unexpected issuer"},
+		{"missing issuer", func(c map[string]interface{}) { delete(c, "iss") }, "unexpected issuer"},
+		{"missing exp", func(c map[string]interface{}) { delete(c, "exp") }, "missing exp"},
+		{"expired within skew", func(c map[string]interface{}) { c["exp"] = now.Add(-30 * time.Second).Unix() }, ""},
+		{"expired", func(c map[string]interface{}) { c["exp"] = now.Add(-2 * time.Minute).Unix() }, "expired"},
+		{"not yet valid within skew", func(c map[string]interface{}) { c["nbf"] = now.Add(30 * time.Second).Unix() }, ""},
+		{"not yet valid", func(c map[string]interface{}) { c["nbf"] = now.Add(2 * time.Minute).Unix() }, "not valid yet"},
+		{"issued in the future", func(c map[string]interface{}) { c["iat"] = now.Add(2 * time.Minute).Unix() }, "future"},
+		{"missing username", func(c map[string]interface{}) { delete(c, "preferred_username") }, "missing preferred_username"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := testClaims(p.srv.URL)
+			tt.edit(claims)
+			_, err := v.Validate(context.Background(), signToken(t, "k1", key, claims))
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrUnauthenticated) || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("err = %v, want ErrUnauthenticated about %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestJWTSignatureRejected(t *testing.T) {
+	p := newFakeOIDC(t)
+	key := newECKey(t)
+	p.publish("k1", key)
+	v := NewJWTValidator(testOIDCConfig(p.srv.URL))
+	claims := testClaims(p.srv.URL)
+
+	valid := signToken(t, "k1", key, claims)
+	parts := strings.Split(valid, ".")
+	tampered := testClaims(p.srv.URL)
+	tampered["groups"] = []string{"ml-admins"}
+	tamperedPayload, _ := json.Marshal(tampered)
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr string
+	}{
+		{"other key", signToken(t, "k1", newECKey(t), claims), "invalid signature"},
+		{"tampered claims", parts[0] + "." + base64.RawURLEncoding.EncodeToString(tamperedPayload) + "." + parts[2], "invalid signature"},
+		{"alg none", signWith(t, map[string]string{"alg": "none", "kid": "k1"}, key, claims), "unsupported algorithm"},
+		{"HMAC", signWith(t, map[string]string{"alg": "HS256", "kid": "k1"}, key, claims), "unsupported algorithm"},
+		{"algorithm of another key type", signWith(t, map[string]string{"alg": "RS256", "kid": "k1"}, key, claims), "invalid signature"},
+		{"unknown kid", signToken(t, "k2", key, claims), "unknown signing key"},
+		{"malformed", "a.b.c", "malformed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := v.Validate(context.Background(), tt.token)
+			if !errors.Is(err, ErrUnauthenticated) || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("err = %v, want ErrUnauthenticated about %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestJWTKeyCache(t *testing.T) {
+	p := newFakeOIDC(t)
+	key := newECKey(t)
+	p.publish("k1", key)
+	cfg := testOIDCConfig(p.srv.URL)
+	cfg.JWKSURL = p.srv.URL + "/jwks"
+	v := NewJWTValidator(cfg)
+	token := signToken(t, "k1", key, testClaims(p.srv.URL))
+
+	for i := 0; i < 3; i++ {
+		if _, err := v.Validate(context.Background(), token); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if discovery, jwks := p.hits(); discovery != 0 || jwks != 1 {
+		t.Fatalf("fetched discovery %d and key set %d times, want 0 and 1", discovery, jwks)
+	}
+
+	// Once the cache expires, tokens the cached keys verify are accepted
+	// right away while the key set is fetched again in the background.
+	v.age(cfg.JWKSCacheTTL)
+	release := p.hold(t)
+	if _, err := v.Validate(context.Background(), token); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, "the key set request", func() bool { _, jwks := p.hits(); return jwks == 2 })
+	release()
+	eventually(t, "the refreshed keys", func() bool { return v.fetchAge() < time.Minute })
+
+	// A provider outage keeps the cached keys in use.
+	p.setFail(true)
+	v.age(cfg.JWKSCacheTTL)
+	if _, err := v.Validate(context.Background(), token); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, "the refresh backoff", func() bool { return v.fetchAge() < cfg.JWKSCacheTTL-time.Second })
+	if _, err := v.Validate(context.Background(), token); err != nil {
+		t.Fatal(err)
+	}
+	if _, jwks := p.hits(); jwks != 3 {
+		t.Errorf("fetched key set %d times during backoff, want 3", jwks)
+	}
+}
+
+func TestJWTProviderUnavailable(t *testing.T) {
+	p := newFakeOIDC(t)
+	key := newECKey(t)
+	p.publish("k1", key)
+	p.setFail(true)
+	v := NewJWTValidator(testOIDCConfig(p.srv.URL))
+
+	_, err := v.Validate(context.Background(), signToken(t, "k1", key, testClaims(p.srv.URL)))
+	if !errors.Is(err, ErrAuthUnavailable) {
+		t.Errorf("err = %v, want ErrAuthUnavailable", err)
+	}
+}
+
+func TestJWTKeyRotation(t *testing.T) {
+	p := newFakeOIDC(t)
+	oldKey, newKey := newECKey(t), newECKey(t)
+	p.publish("old", oldKey)
+	v := NewJWTValidator(testOIDCConfig(p.srv.URL))
+
+	if _, err := v.Validate(context.Background(), signToken(t, "old", oldKey, testClaims(p.srv.URL))); err != nil {
+		t.Fatal(err)
+	}
+	p.publish("new", newKey)
+	rotated := signToken(t, "new", newKey, testClaims(p.srv.URL))
+
+	// Right after a fetch, unknown key IDs do not trigger another one.
+	if _, err := v.Validate(context.Background(), rotated); !errors.Is(err, ErrUnauthenticated) {
+		t.Fatalf("err = %v, want ErrUnauthenticated", err)
+	}
+	if _, jwks := p.hits(); jwks != 1 {
+		t.Fatalf("fetched key set %d times, want once", jwks)
+	}
+
+	// After jwksMinRefresh, a token signed by the new key refreshes the set.
+	v.age(jwksMinRefresh)
+	if _, err := v.Validate(context.Background(), rotated); err != nil {
+		t.Fatal(err)
+	}
+	if _, jwks := p.hits(); jwks != 2 {
+		t.Fatalf("fetched key set %d times, want 2", jwks)
+	}
+
+	// Made-up key IDs cannot force fetches.
+	for i := 0; i < 3; i++ {
+		_, err := v.Validate(context.Background(), signToken(t, "bogus", newKey, testClaims(p.srv.URL)))
+		if !errors.Is(err, ErrUnauthenticated) {
+			t.Fatalf("err = %v, want ErrUnauthenticated", err)
+		}
+	}
+	if _, jwks := p.hits(); jwks != 2 {
+		t.Errorf("fetched key set %d times, want 2", jwks)
+	}
+}
+
+func TestJWTConcurrentFetch(t *testing.T) {
+	p := newFakeOIDC(t)
+	key := newECKey(t)
+	p.publish("k1", key)
+	v := NewJWTValidator(testOIDCConfig(p.srv.URL))
+	token := signToken(t, "k1", key, testClaims(p.srv.URL))
+
+	release := p.hold(t)
+	const callers = 8
+	errs := make(chan error, callers)
+	for i := 0; i < callers; i++ {
+		go func() {
+			_, err := v.Validate(context.Background(), token)
+			errs <- err
+		}()
+	}
+	eventually(t, "the key set request", func() bool { _, jwks := p.hits(); return jwks == 1 })
+
+	// A caller that gives up does not wait for the fetch, and the lock is
+	// not held while it runs.
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := v.Validate(ctx, token); !errors.Is(err, ErrAuthUnavailable) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v, want ErrAuthUnavailable after the deadline", err)
+	}
+
+	release()
+	for i := 0; i < callers; i++ {
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+	}
+	if _, jwks := p.hits(); jwks != 1 {
+		t.Errorf("fetched key set %d times, want once", jwks)
+	}
+}
+
+func TestJWTSetConfigDuringFetch(t *testing.T) {
+	p := newFakeOIDC(t)
+	key := newECKey(t)
+	p.publish("k1", key)
+	cfg := testOIDCConfig(p.srv.URL)
+	v := NewJWTValidator(cfg)
+	token := signToken(t, "k1", key, testClaims(p.srv.URL))
+
+	release := p.hold(t)
+	done := make(chan error, 1)
+	go func() {
+		_, err := v.Validate(context.Background(), token)
+		done <- err
+	}()
+	eventually(t, "the key set request", func() bool { _, jwks := p.hits(); return jwks == 1 })
+
+	// SetConfig does not wait for the fetch, and its result is not cached
+	// for the new configuration.
+	cfg.Audience = "other"
+	v.SetConfig(cfg)
+	release()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	v.mu.Lock()
+	cached := v.keys
+	v.mu.Unlock()
+	if cached != nil {
+		t.Error("keys fetched for the old configuration were cached")
+	}
+}
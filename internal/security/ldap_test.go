@@ -0,0 +1,633 @@
+package security
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+const (
+	testServiceDN       = "cn=nova,ou=services,dc=example,dc=com"
+	testServicePassword = "service-secret"
+	testBaseDN          = "dc=example,dc=com"
+	testAdminsDN        = "cn=admins,ou=groups,dc=example,dc=com"
+	testUsersDN         = "cn=users,ou=groups,dc=example,dc=com"
+	startTLSOID         = "1.3.6.1.4.1.1466.20037"
+)
+
+// ldapEntry is an entry of the stand-in directory. Passwords of entries
+// that may bind are kept outside the attributes.
+type ldapEntry struct {
+	dn       string
+	password string
+	attrs    map[string][]string
+}
+
+// fakeLDAP is an in-process LDAP server that understands just enough of
+// the protocol for LDAPAuthenticator: simple binds, subtree searches with
+// and, or, not, equality and presence filters, and StartTLS.
+type fakeLDAP struct {
+	ln       net.Listener
+	ldaps    bool
+	tls      *tls.Config
+	entries  []ldapEntry
+	beforeDN func(dn string) // called before each user bind, if set
+
+	mu    sync.Mutex
+	conns []net.Conn
+	// accepted counts connections, userBinds binds as an entry other than
+	// the service account.
+	accepted  int
+	userBinds int
+}
+
+// newFakeLDAP starts a stand-in directory. With ldaps, connections are
+// TLS from the start; otherwise tlsConfig, if set, enables StartTLS.
+func newFakeLDAP(t *testing.T, ldaps bool, tlsConfig *tls.Config) *fakeLDAP {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ldaps {
+		ln = tls.NewListener(ln, tlsConfig)
+	}
+	s := &fakeLDAP{
+		ln:    ln,
+		ldaps: ldaps,
+		tls:   tlsConfig,
+		entries: []ldapEntry{
+			{dn: testServiceDN, password: testServicePassword},
+			{
+				dn:       "uid=alice,ou=people,dc=example,dc=com",
+				password: "alice-secret",
+				attrs: map[string][]string{
+					"objectClass": {"person"},
+					"uid":         {"alice"},
+					"memberOf":    {strings.ToUpper(testAdminsDN), testUsersDN},
+				},
+			},
+			{
+				dn:       "uid=bob,ou=people,dc=example,dc=com",
+				password: "bob-secret",
+				attrs: map[string][]string{
+					"objectClass": {"person"},
+					"uid":         {"bob"},
+					"memberOf":    {testUsersDN},
+				},
+			},
+			{
+				dn: testAdminsDN,
+				attrs: map[string][]string{
+					"objectClass": {"groupOfNames"},
+					"member":      {"uid=alice,ou=people,dc=example,dc=com"},
+				},
+			},
+			{
+				dn: testUsersDN,
+				attrs: map[string][]string{
+					"objectClass": {"groupOfNames"},
+					"member":      {"uid=alice,ou=people,dc=example,dc=com", "uid=bob,ou=people,dc=example,dc=com"},
+				},
+			},
+		},
+	}
+	go s.serve()
+	t.Cleanup(s.close)
+	return s
+}
+
+func (s *fakeLDAP) url() string {
+	if s.ldaps {
+		return "ldaps://" + s.ln.Addr().String()
+	}
+	return "ldap://" + s.ln.Addr().String()
+}
+
+func (s *fakeLDAP) close() {
+	s.ln.Close()
+	s.dropConnections()
+}
+
+// dropConnections closes every open client connection, as a restarting
+// server would.
+func (s *fakeLDAP) dropConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, c := range s.conns {
+		c.Close()
+	}
+	s.conns = nil
+}
+
+func (s *fakeLDAP) stats() (accepted, userBinds int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.accepted, s.userBinds
+}
+
+func (s *fakeLDAP) serve() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.accepted++
+		s.conns = append(s.conns, conn)
+		s.mu.Unlock()
+		go s.handle(conn)
+	}
+}
+
+func (s *fakeLDAP) handle(conn net.Conn) {
+	defer conn.Close()
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil {
+			return
+		}
+		if len(packet.Children) < 2 {
+			return
+		}
+		id := packet.Children[0].Value.(int64)
+		op := packet.Children[1]
+		switch op.Tag {
+		case ldap.ApplicationBindRequest:
+			dn, password := op.Children[1].Data.String(), op.Children[2].Data.String()
+			s.write(conn, id, result(ldap.ApplicationBindResponse, s.bind(dn, password)))
+		case ldap.ApplicationSearchRequest:
+			for _, e := range s.search(op) {
+				s.write(conn, id, e)
+			}
+			s.write(conn, id, result(ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess))
+		case ldap.ApplicationExtendedRequest:
+			if op.Children[0].Data.String() != startTLSOID || s.tls == nil {
+				s.write(conn, id, result(ldap.ApplicationExtendedResponse, ldap.LDAPResultProtocolError))
+				continue
+			}
+			s.write(conn, id, result(ldap.ApplicationExtendedResponse, ldap.LDAPResultSuccess))
+			tlsConn := tls.Server(conn, s.tls)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			conn = tlsConn
+		case ldap.ApplicationUnbindRequest:
+			return
+		default:
+			return
+		}
+	}
+}
+
+func (s *fakeLDAP) bind(dn, password string) uint16 {
+	if dn == "" && password == "" {
+		return ldap.LDAPResultSuccess
+	}
+	if !strings.EqualFold(dn, testServiceDN) {
+		if s.beforeDN != nil {
+			s.beforeDN(dn)
+		}
+		s.mu.Lock()
+		s.userBinds++
+		s.mu.Unlock()
+	}
+	for _, e := range s.entries {
+		if strings.EqualFold(e.dn, dn) && e.password != "" && e.password == password {
+			return ldap.LDAPResultSuccess
+		}
+	}
+	return ldap.LDAPResultInvalidCredentials
+}
+
+func (s *fakeLDAP) search(op *ber.Packet) []*ber.Packet {
+	base := strings.ToLower(op.Children[0].Data.String())
+	filter := op.Children[6]
+	var attrs []string
+	for _, a := range op.Children[7].Children {
+		attrs = append(attrs, a.Data.String())
+	}
+
+	var entries []*ber.Packet
+	for _, e := range s.entries {
+		if !strings.HasSuffix(strings.ToLower(e.dn), base) || !matches(filter, e) {
+			continue
+		}
+		entry := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "")
+		entry.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, e.dn, ""))
+		list := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+		for _, name := range attrs {
+			values, ok := e.attrs[name]
+			if !ok {
+				continue
+			}
+			attr := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+			attr.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, ""))
+			set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "")
+			for _, v := range values {
+				set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, v, ""))
+			}
+			attr.AppendChild(set)
+			list.AppendChild(attr)
+		}
+		entry.AppendChild(list)
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// matches evaluates an LDAP filter against e.
+func matches(f *ber.Packet, e ldapEntry) bool {
+	switch f.Tag {
+	case ldap.FilterAnd:
+		for _, c := range f.Children {
+			if !matches(c, e) {
+				return false
+			}
+		}
+		return true
+	case ldap.FilterOr:
+		for _, c := range f.Children {
+			if matches(c, e) {
+				return true
+			}
+		}
+		return false
+	case ldap.FilterNot:
+		return !matches(f.Children[0], e)
+	case ldap.FilterEqualityMatch:
+		name, want := f.Children[0].Data.String(), f.Children[1].Data.String()
+		for _, v := range e.attrs[name] {
+			if strings.EqualFold(v, want) {
+				return true
+			}
+		}
+		return false
+	case ldap.FilterPresent:
+		_, ok := e.attrs[f.Data.String()]
+		return ok
+	}
+	return false
+}
+
+func result(tag ber.Tag, code uint16) *ber.Packet {
+	p := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "")
+	p.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, int64(code), ""))
+	p.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+	p.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+	return p
+}
+
+func (s *fakeLDAP) write(conn net.Conn, id int64, op *ber.Packet) {
+	envelope := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+	envelope.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, ""))
+	envelope.AppendChild(op)
+	conn.Write(envelope.Bytes())
+}
+
+// testServerTLS issues a certificate for 127.0.0.1 from a new CA and
+// returns a server configuration using it and the path of the CA bundle.
+func testServerTLS(t *testing.T) (*tls.Config, string) {
+	t.Helper()
+	dir := t.TempDir()
+	ca, err := InitCA(dir, CAOptions{CommonName: "Test CA"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM, keyPEM, _, err := ca.Issue(CertRequest{
+		Kind:        CertKindNode,
+		CommonName:  "localhost",
+		IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &tls.Config{Certificates: []tls.Certificate{cert}}, filepath.Join(dir, caCertFile)
+}
+
+func testLDAPConfig(url string) LDAPConfig {
+	cfg := DefaultLDAPConfig()
+	cfg.Enabled = true
+	cfg.URL = url
+	cfg.BindDN = testServiceDN
+	cfg.BindPassword = testServicePassword
+	cfg.BaseDN = testBaseDN
+	cfg.UserFilter = "(&(objectClass=person)(uid=%s))"
+	cfg.Timeout = 5 * time.Second
+	cfg.Roles = map[string][]Scope{
+		testAdminsDN: {ScopeManageModels},
+		testUsersDN:  {ScopeGenerate},
+	}
+	return cfg
+}
+
+func newTestLDAP(t *testing.T, cfg LDAPConfig) *LDAPAuthenticator {
+	t.Helper()
+	a, err := NewLDAPAuthenticator(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(a.Close)
+	return a
+}
+
+func sortedScopes(scopes []Scope) []string {
+	names := make([]string, len(scopes))
+	for i, s := range scopes {
+		names[i] = string(s)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestLDAPLogin(t *testing.T) {
+	server := newFakeLDAP(t, false, nil)
+	memberOf := testLDAPConfig(server.url())
+	groupSearch := testLDAPConfig(server.url())
+	groupSearch.GroupFilter = "(&(objectClass=groupOfNames)(member=%s))"
+	groupSearch.GroupBaseDN = "ou=groups," + testBaseDN
+
+	for name, cfg := range map[string]LDAPConfig{"memberOf": memberOf, "group search": groupSearch} {
+		t.Run(name, func(t *testing.T) {
+			a := newTestLDAP(t, cfg)
+
+			id, err := a.Login("alice", "alice-secret")
+			if err != nil {
+				t.Fatalf("Login(alice): %v", err)
+			}
+			if id.Name != "ldap:alice" {
+				t.Errorf("Name = %q, want ldap:alice", id.Name)
+			}
+			if got, want := sortedScopes(id.Scopes), []string{"generate", "manage-models"}; !reflect.DeepEqual(got, want) {
+				t.Errorf("alice's scopes = %v, want %v", got, want)
+			}
+
+			id, err = a.Login("bob", "bob-secret")
+			if err != nil {
+				t.Fatalf("Login(bob): %v", err)
+			}
+			if got, want := sortedScopes(id.Scopes), []string{"generate"}; !reflect.DeepEqual(got, want) {
+				t.Errorf("bob's scopes = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestLDAPLoginRejects(t *testing.T) {
+	server := newFakeLDAP(t, false, nil)
+	a := newTestLDAP(t, testLDAPConfig(server.url()))
+
+	tests := []struct {
+		name, user, password string
+	}{
+		{"wrong password", "alice", "bob-secret"},
+		{"unknown user", "carol", "alice-secret"},
+		{"empty password", "alice", ""},
+		// Escaped, the wildcard only matches a user literally named "*".
+		{"filter wildcard", "*", "alice-secret"},
+		{"filter injection", "alice)(uid=*", "alice-secret"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := a.Login(tt.user, tt.password); !errors.Is(err, ErrUnauthenticated) {
+				t.Errorf("Login(%q, %q) error = %v, want ErrUnauthenticated", tt.user, tt.password, err)
+			}
+		})
+	}
+
+	// The connection survives failed sign-ins.
+	if _, err := a.Login("alice", "alice-secret"); err != nil {
+		t.Errorf("Login after failures: %v", err)
+	}
+}
+
+func TestLDAPServiceAccountRejected(t *testing.T) {
+	server := newFakeLDAP(t, false, nil)
+	cfg := testLDAPConfig(server.url())
+	cfg.BindPassword = "wrong"
+	a := newTestLDAP(t, cfg)
+
+	if _, err := a.Login("alice", "alice-secret"); !errors.Is(err, ErrAuthUnavailable) {
+		t.Errorf("Login with a bad service account: error = %v, want ErrAuthUnavailable", err)
+	}
+}
+
+func TestLDAPUnreachable(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	a := newTestLDAP(t, testLDAPConfig("ldap://"+addr))
+	if _, err := a.Login("alice", "alice-secret"); !errors.Is(err, ErrAuthUnavailable) {
+		t.Errorf("Login with the server down: error = %v, want ErrAuthUnavailable", err)
+	}
+}
+
+func TestLDAPTLS(t *testing.T) {
+	serverTLS, caPath := testServerTLS(t)
+	_, otherCA := testServerTLS(t)
+
+	ldaps := newFakeLDAP(t, true, serverTLS)
+	startTLS := newFakeLDAP(t, false, serverTLS)
+
+	tests := []struct {
+		name     string
+		url      string
+		startTLS bool
+		caPath   string
+		ok       bool
+	}{
+		{"ldaps", ldaps.url(), false, caPath, true},
+		{"ldaps with an untrusted certificate", ldaps.url(), false, otherCA, false},
+		{"StartTLS", startTLS.url(), true, caPath, true},
+		{"StartTLS with an untrusted certificate", startTLS.url(), true, otherCA, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !strings.HasPrefix(tt.url, "ldaps://") && !tt.startTLS {
+				t.Fatalf("test would run in the clear")
+			}
+			cfg := testLDAPConfig(tt.url)
+			cfg.StartTLS = tt.startTLS
+			cfg.CAPath = tt.caPath
+			a := newTestLDAP(t, cfg)
+
+			_, err := a.Login("alice", "alice-secret")
+			if tt.ok && err != nil {
+				t.Errorf("Login: %v", err)
+			}
+			if !tt.ok && !errors.Is(err, ErrAuthUnavailable) {
+				t.Errorf("Login error = %v, want ErrAuthUnavailable", err)
+			}
+		})
+	}
+}
+
+func TestLDAPBadCAPath(t *testing.T) {
+	cfg := testLDAPConfig("ldaps://127.0.0.1:636")
+	cfg.CAPath = filepath.Join(t.TempDir(), "missing.pem")
+	if _, err := NewLDAPAuthenticator(cfg); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("NewLDAPAuthenticator with a missing CA bundle: error = %v, want not exist", err)
+	}
+}
+
+func TestLDAPPool(t *testing.T) {
+	server := newFakeLDAP(t, false, nil)
+	cfg := testLDAPConfig(server.url())
+	cfg.CacheTTL = 0
+	cfg.PoolSize = 1
+	a := newTestLDAP(t, cfg)
+
+	for i := 0; i < 3; i++ {
+		if _, err := a.Login("alice", "alice-secret"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if accepted, _ := server.stats(); accepted != 1 {
+		t.Errorf("3 sign-ins with a pool of 1 made %d connections, want 1", accepted)
+	}
+
+	// A stale pooled connection is replaced transparently.
+	server.dropConnections()
+	if _, err := a.Login("alice", "alice-secret"); err != nil {
+		t.Fatalf("Login after the server dropped the connection: %v", err)
+	}
+	if accepted, _ := server.stats(); accepted != 2 {
+		t.Errorf("connections after a drop = %d, want 2", accepted)
+	}
+
+	// A new configuration does not reuse connections made under the old
+	// one.
+	if err := a.SetConfig(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Login("alice", "alice-secret"); err != nil {
+		t.Fatal(err)
+	}
+	if accepted, _ := server.stats(); accepted != 3 {
+		t.Errorf("connections after SetConfig = %d, want 3", accepted)
+	}
+
+	cfg.PoolSize = 0
+	if err := a.SetConfig(cfg); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := a.Login("alice", "alice-secret"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if accepted, _ := server.stats(); accepted != 5 {
+		t.Errorf("connections without a pool = %d, want 5", accepted)
+	}
+}
+
+func TestLDAPCache(t *testing.T) {
+	server := newFakeLDAP(t, false, nil)
+	cfg := testLDAPConfig(server.url())
+	cfg.CacheTTL = 200 * time.Millisecond
+	a := newTestLDAP(t, cfg)
+
+	binds := func() int {
+		_, n := server.stats()
+		return n
+	}
+
+	if _, err := a.Login("alice", "alice-secret"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Login("alice", "alice-secret"); err != nil {
+		t.Fatal(err)
+	}
+	if n := binds(); n != 1 {
+		t.Errorf("binds after a cached sign-in = %d, want 1", n)
+	}
+
+	// A different password is checked with the server, not the cache.
+	if _, err := a.Login("alice", "guess"); !errors.Is(err, ErrUnauthenticated) {
+		t.Errorf("Login with a wrong password while cached: error = %v, want ErrUnauthenticated", err)
+	}
+	if n := binds(); n != 2 {
+		t.Errorf("binds after a wrong password = %d, want 2", n)
+	}
+
+	time.Sleep(cfg.CacheTTL + 50*time.Millisecond)
+	if _, err := a.Login("alice", "alice-secret"); err != nil {
+		t.Fatal(err)
+	}
+	if n := binds(); n != 3 {
+		t.Errorf("binds after the cache expired = %d, want 3", n)
+	}
+
+	// SetConfig forgets cached sign-ins.
+	if err := a.SetConfig(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Login("alice", "alice-secret"); err != nil {
+		t.Fatal(err)
+	}
+	if n := binds(); n != 4 {
+		t.Errorf("binds after SetConfig = %d, want 4", n)
+	}
+}
+
+func TestLDAPCacheSkipsStaleConfig(t *testing.T) {
+	server := newFakeLDAP(t, false, nil)
+	entered, proceed := make(chan struct{}), make(chan struct{})
+	var once sync.Once
+	server.beforeDN = func(string) {
+		once.Do(func() {
+			close(entered)
+			<-proceed
+		})
+	}
+	cfg := testLDAPConfig(server.url())
+	a := newTestLDAP(t, cfg)
+
+	done := make(chan error)
+	go func() {
+		_, err := a.Login("alice", "alice-secret")
+		done <- err
+	}()
+
+	// Replace the configuration while the sign-in is checked against the
+	// old one.
+	<-entered
+	cfg.Roles = nil
+	if err := a.SetConfig(cfg); err != nil {
+		t.Fatal(err)
+	}
+	close(proceed)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := a.Login("alice", "alice-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(id.Scopes) != 0 {
+		t.Errorf("scopes after the roles were removed = %v, want none", id.Scopes)
+	}
+	if _, binds := server.stats(); binds != 2 {
+		t.Errorf("binds = %d, want 2: the sign-in under the old configuration was cached", binds)
+	}
+}
@@ -1,145 +1,319 @@
 package security
 
 import (
-"crypto/rand"
-"crypto/rsa"
-"crypto/tls"
-"crypto/x509"
-"crypto/x509/pkix"
-"encoding/pem"
-"fmt"
-"math/big"
-"os"
-"time"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
 )
 
 type Manager struct {
-certPool    *x509.CertPool
-privateKey  *rsa.PrivateKey
-certificate *x509.Certificate
-config      *Config
-}
+	mu       sync.RWMutex
+	certPool *x509.CertPool
+	config   *Config
+	metrics  Metrics
 
-type Config struct {
-TLSEnabled bool   `yaml:"tls_enabled"`
-CertPath   string `yaml:"cert_path"`
-KeyPath    string `yaml:"key_path"`
-CAPath     string `yaml:"ca_path"`
-}
+	// serving is the certificate presented by the API, reloaded by
+	// GetCertificate when its files change; certificate is its leaf.
+	serving        *tls.Certificate
+	certificate    *x509.Certificate
+	servingStamp   pairStamp
+	servingChecked time.Time
 
-type PeerInfo struct {
-ID        string
-Address   string
-PublicKey []byte
-Verified  bool
+	// crls are the revocation lists in effect, reloaded by
+	// revocationLists when their files change. crlErr is the error of the
+	// last reload, returned until the next one.
+	crls        []*crlFile
+	crlErr      error
+	crlsChecked time.Time
 }
 
-func NewManager() *Manager {
-return &Manager{
-certPool: x509.NewCertPool(),
-config: &Config{
-TLSEnabled: false,
-CertPath:   "/certs/server.crt",
-KeyPath:    "/certs/server.key",
-CAPath:     "/certs/ca.crt",
-},
-}
+// pairStamp identifies the version of a certificate and key file pair.
+type pairStamp struct {
+	certMod, keyMod   time.Time
+	certSize, keySize int64
 }
 
-func (m *Manager) GenerateSelfSignedCert() error {
-privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
-if err != nil {
-return fmt.Errorf("failed to generate private key: %w", err)
-}
+// Client certificate modes for the API.
+const (
+	ClientAuthNone     = "none"
+	ClientAuthOptional = "optional"
+	ClientAuthRequire  = "require"
+)
 
-template := x509.Certificate{
-SerialNumber: big.NewInt(1),
-Subject: pkix.Name{
-Organization: []string{"Ollama-Nova"},
-CommonName:   "ollama-nova.local",
-},
-NotBefore:             time.Now(),
-NotAfter:              time.Now().AddDate(1, 0, 0),
-KeyUsage:              x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
-ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
-BasicConstraintsValid: true,
+type Config struct {
+	TLSEnabled bool   `yaml:"tls"`
+	CertPath   string `yaml:"cert_path"`
+	KeyPath    string `yaml:"key_path"`
+	CAPath     string `yaml:"ca_path"`
+	// CADir holds the built-in CA managed with "novacron ca". Its CRL is
+	// checked along with those in CRLPaths.
+	CADir string `yaml:"ca_dir"`
+	// CRLPaths lists CRL files, PEM or DER, of the CAs in the bundle.
+	// Certificates they revoke are rejected for peers and API clients.
+	CRLPaths []string `yaml:"crl_paths"`
+	// Rotation renews the node certificate before it expires.
+	Rotation RotationConfig `yaml:"rotation"`
+	// ClientAuth asks API clients for a certificate signed by the CA
+	// bundle: "none", "optional" or "require".
+	ClientAuth string `yaml:"client_auth"`
+	// Auth controls who may use the HTTP API.
+	Auth AuthConfig `yaml:"auth"`
 }
 
-certBytes, err := x509.CreateCertificate(rand.Reader, &template, &template, &privateKey.PublicKey, privateKey)
-if err != nil {
-return fmt.Errorf("failed to create certificate: %w", err)
+// AuthConfig controls authentication of the HTTP API. While it is disabled
+// every request is served with full access.
+type AuthConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// KeyFile holds the hashed API keys managed with "novacron keys".
+	KeyFile string `yaml:"key_file"`
+	// LDAP lets directory users sign in with HTTP Basic credentials.
+	LDAP LDAPConfig `yaml:"ldap"`
+	// OIDC accepts JWT bearer tokens from an OpenID Connect provider.
+	OIDC OIDCConfig `yaml:"oidc"`
+	// ClientCerts identifies callers by their verified client
+	// certificate.
+	ClientCerts ClientCertConfig `yaml:"client_certs"`
 }
 
-// Ensure directory exists
-os.MkdirAll("/certs", 0755)
+type PeerInfo struct {
+	ID        string
+	Address   string
+	PublicKey []byte
+	Verified  bool
+}
 
-// Save certificate
-certFile, err := os.Create(m.config.CertPath)
-if err != nil {
-return fmt.Errorf("failed to create cert file: %w", err)
+// DefaultConfig returns the settings NewManager starts with.
+func DefaultConfig() Config {
+	return Config{
+		TLSEnabled: false,
+		CertPath:   "/certs/server.crt",
+		KeyPath:    "/certs/server.key",
+		CAPath:     "/certs/ca.crt",
+		CADir:      "/var/lib/nova/ca",
+		Rotation:   DefaultRotationConfig(),
+		ClientAuth: ClientAuthNone,
+		Auth: AuthConfig{
+			KeyFile: "/var/lib/nova/api-keys.json",
+			LDAP:    DefaultLDAPConfig(),
+			OIDC:    DefaultOIDCConfig(),
+		},
+	}
 }
-defer certFile.Close()
 
-certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certBytes})
-if _, err := certFile.Write(certPEM); err != nil {
-return fmt.Errorf("failed to write certificate: %w", err)
+func NewManager() *Manager {
+	cfg := DefaultConfig()
+	return &Manager{
+		certPool: x509.NewCertPool(),
+		config:   &cfg,
+	}
 }
 
-// Save private key
-keyFile, err := os.Create(m.config.KeyPath)
-if err != nil {
-return fmt.Errorf("failed to create key file: %w", err)
+// SetConfig replaces the manager configuration. With TLS enabled the
+// trusted pool is rebuilt from the CA bundle, so calling it again picks up
+// a replaced bundle; if that fails the previous configuration stays in
+// effect. The serving certificate and the CRLs are looked at again when
+// next used.
+func (m *Manager) SetConfig(cfg Config) error {
+	var pool *x509.CertPool
+	if cfg.TLSEnabled && cfg.CAPath != "" {
+		var err error
+		if pool, err = readCAPool(cfg.CAPath); err != nil {
+			return err
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if pool != nil {
+		m.certPool = pool
+	}
+	m.config = &cfg
+	m.servingChecked = time.Time{}
+	m.crlsChecked = time.Time{}
+	return nil
 }
-defer keyFile.Close()
 
-keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(privateKey)})
-if _, err := keyFile.Write(keyPEM); err != nil {
-return fmt.Errorf("failed to write private key: %w", err)
+// Config returns the current configuration.
+func (m *Manager) Config() Config {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return *m.config
 }
 
-m.privateKey = privateKey
-return nil
+// InstallNodeCert issues a node certificate from ca and writes it to
+// cert_path and key_path, where a running node picks it up. The CA's root
+// is written to ca_path unless that file already exists, since it may be
+// a bundle trusting other CAs too.
+func (m *Manager) InstallNodeCert(ca *CA, req CertRequest) (IssuedCert, error) {
+	req.Kind = CertKindNode
+	certPEM, keyPEM, issued, err := ca.Issue(req)
+	if err != nil {
+		return IssuedCert{}, err
+	}
+
+	cfg := m.Config()
+	if err := writeFileAtomic(cfg.KeyPath, keyPEM, 0o600); err != nil {
+		return IssuedCert{}, err
+	}
+	if err := writeFileAtomic(cfg.CertPath, certPEM, 0o644); err != nil {
+		return IssuedCert{}, err
+	}
+	if cfg.CAPath != "" {
+		if _, err := os.Stat(cfg.CAPath); errors.Is(err, os.ErrNotExist) {
+			if err := writeFileAtomic(cfg.CAPath, ca.RootPEM(), 0o644); err != nil {
+				return IssuedCert{}, err
+			}
+		}
+	}
+	return issued, nil
 }
 
+// CreateTLSConfig returns the server TLS configuration for the API, or nil
+// when TLS is disabled. Every handshake uses the current configuration, so
+// a reloaded CA bundle or client_auth mode and replaced certificate files
+// take effect without a restart.
 func (m *Manager) CreateTLSConfig() (*tls.Config, error) {
-if !m.config.TLSEnabled {
-return nil, nil
-}
+	cfg := m.Config()
+	if !cfg.TLSEnabled {
+		return nil, nil
+	}
 
-cert, err := tls.LoadX509KeyPair(m.config.CertPath, m.config.KeyPath)
-if err != nil {
-return nil, fmt.Errorf("failed to load certificate: %w", err)
-}
+	// Load the certificate now so that a broken pair fails at startup.
+	if _, err := m.GetCertificate(nil); err != nil {
+		return nil, err
+	}
 
-return &tls.Config{
-Certificates: []tls.Certificate{cert},
-MinVersion:   tls.VersionTLS12,
-NextProtos:   []string{"h2", "http/1.1"},
-}, nil
+	tlsConfig := m.serverTLSConfig()
+	tlsConfig.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		return m.serverTLSConfig(), nil
+	}
+	return tlsConfig, nil
 }
 
-func (m *Manager) ValidatePeer(peerID string, cert *x509.Certificate) bool {
-// Implement peer certificate validation
-opts := x509.VerifyOptions{
-Roots: m.certPool,
+func (m *Manager) serverTLSConfig() *tls.Config {
+	m.mu.RLock()
+	mode, pool := m.config.ClientAuth, m.certPool
+	m.mu.RUnlock()
+
+	tlsConfig := &tls.Config{
+		GetCertificate: m.GetCertificate,
+		MinVersion:     tls.VersionTLS12,
+		NextProtos:     []string{"h2", "http/1.1"},
+	}
+	switch mode {
+	case ClientAuthOptional:
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		tlsConfig.ClientCAs = pool
+	case ClientAuthRequire:
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		tlsConfig.ClientCAs = pool
+	}
+	if mode != ClientAuthNone {
+		tlsConfig.VerifyConnection = func(cs tls.ConnectionState) error {
+			if len(cs.VerifiedChains) == 0 {
+				return nil
+			}
+			return m.checkRevoked(cs.VerifiedChains)
+		}
+	}
+	return tlsConfig
 }
 
-if _, err := cert.Verify(opts); err != nil {
-return false
+// certCheckInterval limits how often GetCertificate looks for replaced
+// certificate or key files.
+const certCheckInterval = 5 * time.Second
+
+// GetCertificate returns the serving certificate, reloading it when the
+// certificate or key file has changed. If a replaced pair fails to load,
+// for instance because only one of the files has been written so far, the
+// previous certificate stays in use.
+func (m *Manager) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.serving != nil && time.Since(m.servingChecked) < certCheckInterval {
+		return m.serving, nil
+	}
+	m.servingChecked = time.Now()
+
+	cfg := m.config
+	stamp, err := statPair(cfg.CertPath, cfg.KeyPath)
+	if err == nil && m.serving != nil && stamp == m.servingStamp {
+		return m.serving, nil
+	}
+	cert, err := tls.LoadX509KeyPair(cfg.CertPath, cfg.KeyPath)
+	if err != nil {
+		if m.serving != nil {
+			slog.Warn("Keeping the current TLS certificate", "err", err)
+			return m.serving, nil
+		}
+		return nil, fmt.Errorf("failed to load certificate: %w", err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		if m.serving != nil {
+			slog.Warn("Keeping the current TLS certificate", "err", err)
+			return m.serving, nil
+		}
+		return nil, fmt.Errorf("failed to parse certificate: %w", err)
+	}
+	cert.Leaf = leaf
+	if m.serving != nil {
+		log.Printf("Loaded new TLS certificate from %s", cfg.CertPath)
+	}
+	m.serving = &cert
+	m.certificate = leaf
+	m.servingStamp = stamp
+	return m.serving, nil
 }
 
-return true
+func statPair(certPath, keyPath string) (pairStamp, error) {
+	cert, err := os.Stat(certPath)
+	if err != nil {
+		return pairStamp{}, err
+	}
+	key, err := os.Stat(keyPath)
+	if err != nil {
+		return pairStamp{}, err
+	}
+	return pairStamp{
+		certMod:  cert.ModTime(),
+		keyMod:   key.ModTime(),
+		certSize: cert.Size(),
+		keySize:  key.Size(),
+	}, nil
 }
 
 func (m *Manager) LoadCA(caPath string) error {
-caData, err := os.ReadFile(caPath)
-if err != nil {
-return fmt.Errorf("failed to read CA file: %w", err)
-}
+	caData, err := os.ReadFile(caPath)
+	if err != nil {
+		return fmt.Errorf("failed to read CA file: %w", err)
+	}
 
-if !m.certPool.AppendCertsFromPEM(caData) {
-return fmt.Errorf("failed to parse CA certificate")
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.certPool.AppendCertsFromPEM(caData) {
+		return fmt.Errorf("failed to parse CA certificate")
+	}
+
+	return nil
 }
 
-return nil
+// readCAPool builds a fresh pool from the PEM bundle at caPath.
+func readCAPool(caPath string) (*x509.CertPool, error) {
+	caData, err := os.ReadFile(caPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA file: %w", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caData) {
+		return nil, fmt.Errorf("failed to parse CA certificate")
+	}
+	return pool, nil
 }
@@ -0,0 +1,257 @@
+package security
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var (
+	// ErrUntrusted is wrapped by errors for peer certificates that do not
+	// chain to a trusted CA or are not valid for a node.
+	ErrUntrusted = errors.New("certificate not trusted")
+	// ErrRevoked is wrapped by errors for certificates listed in a CRL.
+	ErrRevoked = errors.New("certificate revoked")
+	// ErrPeerMismatch is wrapped by errors for certificates that are not
+	// bound to the peer ID presenting them.
+	ErrPeerMismatch = errors.New("certificate does not belong to peer")
+)
+
+// peerURIScheme is the scheme of the URI SAN that binds a node certificate
+// to a libp2p peer ID, as in "libp2p:12D3KooW...".
+const peerURIScheme = "libp2p"
+
+func peerIDURI(peerID string) *url.URL {
+	return &url.URL{Scheme: peerURIScheme, Opaque: peerID}
+}
+
+// CertPeerID returns the libp2p peer ID cert is bound to, or "".
+func CertPeerID(cert *x509.Certificate) string {
+	for _, u := range cert.URIs {
+		if u.Scheme == peerURIScheme && u.Opaque != "" {
+			return u.Opaque
+		}
+	}
+	return ""
+}
+
+// ValidatePeer checks the certificate chain a peer presented, leaf first:
+// it must lead to a trusted CA, be issued for a node, be bound to peerID
+// and not be revoked by any CRL the manager knows of. The error says which
+// check failed.
+func (m *Manager) ValidatePeer(peerID string, chain []*x509.Certificate) error {
+	if len(chain) == 0 {
+		return fmt.Errorf("%w: no certificate presented", ErrUntrusted)
+	}
+	leaf := chain[0]
+
+	m.mu.RLock()
+	roots := m.certPool
+	m.mu.RUnlock()
+	intermediates := x509.NewCertPool()
+	for _, cert := range chain[1:] {
+		intermediates.AddCert(cert)
+	}
+	verified, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrUntrusted, err)
+	}
+
+	switch bound := CertPeerID(leaf); bound {
+	case peerID:
+	case "":
+		return fmt.Errorf("%w: %s is not bound to a peer ID", ErrPeerMismatch, leaf.Subject)
+	default:
+		return fmt.Errorf("%w: %s is bound to %s, not %s", ErrPeerMismatch, leaf.Subject, bound, peerID)
+	}
+
+	return m.checkRevoked(verified)
+}
+
+// checkRevoked accepts a certificate if at least one of its verified chains
+// has no revoked certificate in it.
+func (m *Manager) checkRevoked(chains [][]*x509.Certificate) error {
+	crls, err := m.revocationLists()
+	if err != nil {
+		return err
+	}
+	var revokedErr error
+	for _, chain := range chains {
+		if revokedErr = revokedIn(chain, crls); revokedErr == nil {
+			return nil
+		}
+	}
+	return revokedErr
+}
+
+// revokedIn looks up every certificate of a verified chain in the CRLs
+// signed by its issuer.
+func revokedIn(chain []*x509.Certificate, crls []*crlFile) error {
+	for i := 0; i+1 < len(chain); i++ {
+		cert, issuer := chain[i], chain[i+1]
+		for _, crl := range crls {
+			if !bytes.Equal(crl.list.RawIssuer, issuer.RawSubject) {
+				continue
+			}
+			if err := crl.list.CheckSignatureFrom(issuer); err != nil {
+				continue
+			}
+			if at, ok := crl.revoked[cert.SerialNumber.Text(16)]; ok {
+				return fmt.Errorf("%w: %s (serial %s) was revoked at %s", ErrRevoked, cert.Subject, cert.SerialNumber.Text(16), at.Format(time.RFC3339))
+			}
+		}
+	}
+	return nil
+}
+
+// crlCheckInterval limits how often the CRL files are looked at for
+// changes.
+const crlCheckInterval = 10 * time.Second
+
+// crlFile is a loaded CRL and the serial numbers, in hex, it revokes.
+type crlFile struct {
+	path    string
+	modTime time.Time
+	size    int64
+	list    *x509.RevocationList
+	revoked map[string]time.Time
+}
+
+// crlPaths returns the CRLs to check: those configured in crl_paths and
+// the one the built-in CA keeps in ca_dir, if it exists.
+func crlPaths(cfg *Config) (required, optional []string) {
+	if cfg.CADir != "" {
+		optional = append(optional, filepath.Join(cfg.CADir, caCRLFile))
+	}
+	return cfg.CRLPaths, optional
+}
+
+// revocationLists returns the current CRLs, reloading those whose files
+// changed. A CRL that fails to reload stays in effect as it was; a
+// configured one that never loaded, or any CRL past its next update,
+// fails every check until it is replaced.
+func (m *Manager) revocationLists() ([]*crlFile, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.crlsChecked.IsZero() && time.Since(m.crlsChecked) < crlCheckInterval {
+		return m.crls, m.crlErr
+	}
+	m.crlsChecked = time.Now()
+
+	previous := make(map[string]*crlFile, len(m.crls))
+	for _, crl := range m.crls {
+		previous[crl.path] = crl
+	}
+	required, optional := crlPaths(m.config)
+	crls := make([]*crlFile, 0, len(required)+len(optional))
+	var failed error
+	load := func(path string, mustExist bool) {
+		crl, err := loadCRL(path, previous[path])
+		switch {
+		case err == nil:
+		case previous[path] != nil:
+			slog.Warn("Keeping the current CRL", "path", path, "err", err)
+			crl = previous[path]
+		case mustExist || !errors.Is(err, os.ErrNotExist):
+			slog.Error("CRL not loaded", "err", err)
+			if mustExist && failed == nil {
+				failed = fmt.Errorf("revocation list unavailable: %w", err)
+			}
+			return
+		default:
+			return
+		}
+		crls = append(crls, crl)
+		// An expired CRL may be missing recent revocations.
+		if next := crl.list.NextUpdate; !next.IsZero() && time.Now().After(next) && failed == nil {
+			slog.Error("CRL expired", "path", path, "next_update", next.Format(time.RFC3339))
+			failed = fmt.Errorf("revocation list unavailable: %s expired at %s", path, next.Format(time.RFC3339))
+		}
+	}
+	for _, path := range required {
+		load(path, true)
+	}
+	for _, path := range optional {
+		load(path, false)
+	}
+	m.crls = crls
+	m.crlErr = failed
+	return crls, failed
+}
+
+// refreshCRL re-signs the CRL of the CA in ca_dir once half of its
+// validity has passed, so that it does not expire while no certificates
+// are revoked. Nodes that hold only a copy of the CRL leave it alone.
+func (m *Manager) refreshCRL() error {
+	dir := m.Config().CADir
+	if dir == "" {
+		return nil
+	}
+	crl, err := loadCRL(filepath.Join(dir, caCRLFile), nil)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if next := crl.list.NextUpdate; next.IsZero() || time.Until(next) > crlValidity/2 {
+		return nil
+	}
+
+	ca, err := OpenCA(dir)
+	if errors.Is(err, ErrNoCA) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := ca.CRL(); err != nil {
+		return err
+	}
+	log.Printf("Re-signed CRL %s", crl.path)
+	return nil
+}
+
+// loadCRL reads the PEM or DER CRL at path, unless it is unchanged since
+// prev was loaded.
+func loadCRL(path string, prev *crlFile) (*crlFile, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if prev != nil && info.ModTime().Equal(prev.modTime) && info.Size() == prev.size {
+		return prev, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if block, _ := pem.Decode(data); block != nil {
+		if block.Type != "X509 CRL" {
+			return nil, fmt.Errorf("%s: expected an X509 CRL block, found %s", path, block.Type)
+		}
+		data = block.Bytes
+	}
+	list, err := x509.ParseRevocationList(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CRL %s: %w", path, err)
+	}
+
+	revoked := make(map[string]time.Time, len(list.RevokedCertificateEntries))
+	for _, entry := range list.RevokedCertificateEntries {
+		revoked[entry.SerialNumber.Text(16)] = entry.RevocationTime
+	}
+	return &crlFile{path: path, modTime: info.ModTime(), size: info.Size(), list: list, revoked: revoked}, nil
+}
@@ -0,0 +1,198 @@
+package security
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testCA creates a CA and a node certificate issued by it, returning the
+// CA and the certificate's verified chain.
+func testCA(t *testing.T) (*CA, []*x509.Certificate) {
+	t.Helper()
+	ca, err := InitCA(t.TempDir(), CAOptions{CommonName: "Test CA"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM, _, _, err := ca.Issue(CertRequest{Kind: CertKindNode, CommonName: "node-1", DNSNames: []string{"node-1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(certPEM)
+	leaf, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ca, []*x509.Certificate{leaf, ca.Certificate()}
+}
+
+// writeTestCRL writes a CRL signed by ca to path, valid from thisUpdate
+// to nextUpdate.
+func writeTestCRL(t *testing.T, ca *CA, path string, thisUpdate, nextUpdate time.Time) {
+	t.Helper()
+	der, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:     big.NewInt(time.Now().UnixNano()),
+		ThisUpdate: thisUpdate,
+		NextUpdate: nextUpdate,
+	}, ca.Certificate(), ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der}), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newTestManager(t *testing.T, cfg Config) *Manager {
+	t.Helper()
+	m := NewManager()
+	if err := m.SetConfig(cfg); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestCheckRevoked(t *testing.T) {
+	ca, chain := testCA(t)
+	cfg := DefaultConfig()
+	cfg.CADir = ca.dir
+	m := newTestManager(t, cfg)
+
+	// Without a CRL in ca_dir nothing is revoked.
+	if err := m.checkRevoked([][]*x509.Certificate{chain}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ca.Revoke(chain[0].SerialNumber.Text(16)); err != nil {
+		t.Fatal(err)
+	}
+	m.SetConfig(cfg)
+	if err := m.checkRevoked([][]*x509.Certificate{chain}); !errors.Is(err, ErrRevoked) {
+		t.Errorf("err = %v, want ErrRevoked", err)
+	}
+}
+
+func TestMissingCRLFailsClosed(t *testing.T) {
+	ca, chain := testCA(t)
+	path := filepath.Join(t.TempDir(), "crl.pem")
+	cfg := DefaultConfig()
+	cfg.CRLPaths = []string{path}
+	m := newTestManager(t, cfg)
+
+	// Every check fails, not just the one that found the CRL missing.
+	for i := 0; i < 3; i++ {
+		if err := m.checkRevoked([][]*x509.Certificate{chain}); err == nil {
+			t.Fatalf("check %d passed without the required CRL", i+1)
+		}
+	}
+
+	writeTestCRL(t, ca, path, time.Now(), time.Now().Add(time.Hour))
+	m.SetConfig(cfg)
+	if err := m.checkRevoked([][]*x509.Certificate{chain}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExpiredCRLFailsClosed(t *testing.T) {
+	ca, chain := testCA(t)
+	dir := t.TempDir()
+
+	tests := []struct {
+		name string
+		cfg  func(cfg *Config, path string)
+	}{
+		{"configured", func(cfg *Config, path string) { cfg.CRLPaths = []string{path} }},
+		{"built-in CA", func(cfg *Config, path string) { cfg.CADir = filepath.Dir(path) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.name, caCRLFile)
+			if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			writeTestCRL(t, ca, path, time.Now().Add(-48*time.Hour), time.Now().Add(-time.Hour))
+			cfg := DefaultConfig()
+			cfg.CADir = ""
+			tt.cfg(&cfg, path)
+			m := newTestManager(t, cfg)
+
+			for i := 0; i < 2; i++ {
+				err := m.checkRevoked([][]*x509.Certificate{chain})
+				if err == nil || !strings.Contains(err.Error(), "expired") {
+					t.Fatalf("check %d: err = %v, want an expired CRL", i+1, err)
+				}
+			}
+
+			writeTestCRL(t, ca, path, time.Now(), time.Now().Add(time.Hour))
+			m.SetConfig(cfg)
+			if err := m.checkRevoked([][]*x509.Certificate{chain}); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestRefreshCRL(t *testing.T) {
+	ca, _ := testCA(t)
+	path := filepath.Join(ca.dir, caCRLFile)
+	cfg := DefaultConfig()
+	cfg.CADir = ca.dir
+	m := newTestManager(t, cfg)
+
+	// Nothing to do before the CA has written a CRL.
+	if err := m.refreshCRL(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("refreshCRL created %s", path)
+	}
+
+	nextUpdate := func() time.Time {
+		t.Helper()
+		crl, err := loadCRL(path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return crl.list.NextUpdate
+	}
+
+	// A CRL with more than half its validity left is kept.
+	fresh := time.Now().Add(crlValidity).Truncate(time.Second).UTC()
+	writeTestCRL(t, ca, path, time.Now(), fresh)
+	if err := m.refreshCRL(); err != nil {
+		t.Fatal(err)
+	}
+	if got := nextUpdate(); !got.Equal(fresh) {
+		t.Errorf("next update = %s, want %s unchanged", got, fresh)
+	}
+
+	// One closer to expiry is re-signed.
+	writeTestCRL(t, ca, path, time.Now().Add(-crlValidity), time.Now().Add(time.Hour))
+	if err := m.refreshCRL(); err != nil {
+		t.Fatal(err)
+	}
+	if got := nextUpdate(); time.Until(got) < crlValidity-time.Hour {
+		t.Errorf("next update = %s, want about %s from now", got, crlValidity)
+	}
+
+	// A copy of the CRL without the CA key is left alone.
+	copyDir := t.TempDir()
+	stale := time.Now().Add(time.Hour).Truncate(time.Second).UTC()
+	writeTestCRL(t, ca, filepath.Join(copyDir, caCRLFile), time.Now().Add(-crlValidity), stale)
+	cfg.CADir = copyDir
+	m.SetConfig(cfg)
+	if err := m.refreshCRL(); err != nil {
+		t.Fatal(err)
+	}
+	path = filepath.Join(copyDir, caCRLFile)
+	if got := nextUpdate(); !got.Equal(stale) {
+		t.Errorf("next update = %s, want %s unchanged", got, stale)
+	}
+}
@@ -0,0 +1,178 @@
+package security
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"time"
+)
+
+// RotationConfig controls renewal of the node certificate from the
+// built-in CA and the warning given before it expires.
+type RotationConfig struct {
+	// RenewBefore re-issues the certificate from the CA in ca_dir this
+	// long before it expires, or a third of its lifetime before if that
+	// is shorter. Zero turns renewal off.
+	RenewBefore time.Duration `yaml:"renew_before"`
+	// WarnBefore fails the certificate health check when the
+	// certificate expires within this window.
+	WarnBefore time.Duration `yaml:"warn_before"`
+	// CheckInterval is how often the certificate is looked at.
+	CheckInterval time.Duration `yaml:"check_interval"`
+}
+
+// DefaultRotationConfig renews certificates 30 days before they expire and
+// warns 7 days before.
+func DefaultRotationConfig() RotationConfig {
+	return RotationConfig{
+		RenewBefore:   30 * 24 * time.Hour,
+		WarnBefore:    7 * 24 * time.Hour,
+		CheckInterval: time.Hour,
+	}
+}
+
+// Metrics receives the state of the serving certificate. It is implemented
+// by monitoring.Monitor.
+type Metrics interface {
+	SetCertExpiry(remaining time.Duration)
+}
+
+// SetMetrics makes the manager report the time left on the serving
+// certificate to metrics.
+func (m *Manager) SetMetrics(metrics Metrics) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.metrics = metrics
+}
+
+// ServingCertificate returns the certificate the API currently serves.
+func (m *Manager) ServingCertificate() (*x509.Certificate, error) {
+	if _, err := m.GetCertificate(nil); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.certificate, nil
+}
+
+// CheckCertExpiry is a health check that fails once the serving
+// certificate expires within warn_before.
+func (m *Manager) CheckCertExpiry() error {
+	leaf, err := m.ServingCertificate()
+	if err != nil {
+		return err
+	}
+	remaining := m.observeExpiry(leaf)
+	if remaining <= 0 {
+		return fmt.Errorf("TLS certificate expired at %s", leaf.NotAfter.Format(time.RFC3339))
+	}
+	if remaining < m.Config().Rotation.WarnBefore {
+		return fmt.Errorf("TLS certificate expires in %s, at %s", remaining.Round(time.Minute), leaf.NotAfter.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// RunCertRotation renews the serving certificate when it comes within
+// renew_before of expiring, until ctx is canceled. The new certificate
+// takes over on the next handshake, without restarting the listeners. It
+// also keeps the CRL of the CA in ca_dir from expiring.
+func (m *Manager) RunCertRotation(ctx context.Context) {
+	for {
+		if err := m.rotateIfDue(); err != nil {
+			slog.Error("TLS certificate not renewed", "err", err)
+		}
+		if err := m.refreshCRL(); err != nil {
+			slog.Error("CRL not re-signed", "err", err)
+		}
+
+		interval := m.Config().Rotation.CheckInterval
+		if interval <= 0 {
+			interval = DefaultRotationConfig().CheckInterval
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+func (m *Manager) rotateIfDue() error {
+	leaf, err := m.ServingCertificate()
+	if err != nil {
+		return err
+	}
+	remaining := m.observeExpiry(leaf)
+	cfg := m.Config()
+	window := cfg.Rotation.RenewBefore
+	if lifetime := leaf.NotAfter.Sub(leaf.NotBefore); lifetime/3 < window {
+		// Otherwise a short-lived certificate would be renewed on every
+		// check.
+		window = lifetime / 3
+	}
+	if window <= 0 || remaining > window {
+		return nil
+	}
+
+	ca, err := OpenCA(cfg.CADir)
+	if err != nil {
+		return err
+	}
+	// Replacing a certificate from another CA would break the clients
+	// that only trust that one.
+	if err := leaf.CheckSignatureFrom(ca.Certificate()); err != nil {
+		return fmt.Errorf("%s was not issued by the CA in %s", cfg.CertPath, cfg.CADir)
+	}
+
+	issued, err := m.InstallNodeCert(ca, renewalRequest(leaf))
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	m.servingChecked = time.Time{}
+	m.mu.Unlock()
+	if leaf, err = m.ServingCertificate(); err != nil {
+		return err
+	}
+	if leaf.SerialNumber.Text(16) != issued.Serial {
+		return errors.New("the renewed certificate was written but failed to load")
+	}
+	m.observeExpiry(leaf)
+	log.Printf("Renewed TLS certificate for %s, valid until %s", issued.Subject, issued.NotAfter.Format(time.RFC3339))
+	return nil
+}
+
+// observeExpiry reports the time left on leaf to the metrics and returns
+// it.
+func (m *Manager) observeExpiry(leaf *x509.Certificate) time.Duration {
+	remaining := time.Until(leaf.NotAfter)
+	m.mu.RLock()
+	metrics := m.metrics
+	m.mu.RUnlock()
+	if metrics != nil {
+		metrics.SetCertExpiry(remaining)
+	}
+	return remaining
+}
+
+// renewalRequest asks for a certificate with the same names, peer ID, key
+// type and lifetime as leaf.
+func renewalRequest(leaf *x509.Certificate) CertRequest {
+	alg := KeyECDSA
+	if leaf.PublicKeyAlgorithm == x509.Ed25519 {
+		alg = KeyEd25519
+	}
+	return CertRequest{
+		Kind:                CertKindNode,
+		CommonName:          leaf.Subject.CommonName,
+		OrganizationalUnits: leaf.Subject.OrganizationalUnit,
+		DNSNames:            leaf.DNSNames,
+		IPAddresses:         leaf.IPAddresses,
+		PeerID:              CertPeerID(leaf),
+		Validity:            leaf.NotAfter.Sub(leaf.NotBefore),
+		KeyAlgorithm:        alg,
+	}
+}